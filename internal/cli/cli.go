@@ -1,8 +1,21 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"audio-steganography-lsb/pkg/attacks"
 	"audio-steganography-lsb/pkg/embed"
 	"audio-steganography-lsb/pkg/extract"
+	"audio-steganography-lsb/pkg/lame"
+	"audio-steganography-lsb/pkg/steganalysis"
+	"audio-steganography-lsb/pkg/stego"
+	"audio-steganography-lsb/pkg/utils"
 //	"audio-steganography-lsb/pkg/encrypt" // added import for encryption
 
 	"github.com/spf13/cobra"
@@ -17,6 +30,9 @@ func Execute() error {
 
 	rootCmd.AddCommand(embedCmd())
 	rootCmd.AddCommand(extractCmd())
+	rootCmd.AddCommand(analyzeCmd())
+	rootCmd.AddCommand(capacityCmd())
+	rootCmd.AddCommand(attackCmd())
 
 	return rootCmd.Execute()
 }
@@ -27,35 +43,81 @@ func embedCmd() *cobra.Command {
 		Short: "Embed a secret message into an MP3 file",
 		Long:  "Embed a secret message into an MP3 audio file using the LSB method.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cover, _ := cmd.Flags().GetString("cover")
-			message, _ := cmd.Flags().GetString("message")
-			key, _ := cmd.Flags().GetString("key")
+			covers, _ := cmd.Flags().GetStringArray("cover")
+			messages, _ := cmd.Flags().GetStringArray("message")
+			keys, _ := cmd.Flags().GetStringArray("key")
 			lsb, _ := cmd.Flags().GetInt("lsb")
 			random, _ := cmd.Flags().GetBool("random")
 			encrypt, _ := cmd.Flags().GetBool("encrypt") // args untuk enkripsi
 			output, _ := cmd.Flags().GetString("output")
+			method, _ := cmd.Flags().GetString("method")
+			layout, _ := cmd.Flags().GetString("layout")
+			copyTags, _ := cmd.Flags().GetBool("copy-tags")
+			threshold, _ := cmd.Flags().GetInt("threshold")
+			erasure, _ := cmd.Flags().GetBool("erasure-coding")
+			deniable, _ := cmd.Flags().GetBool("deniable")
+			fillNoise, _ := cmd.Flags().GetBool("fill-noise")
+			matching, _ := cmd.Flags().GetBool("matching")
+			matrix, _ := cmd.Flags().GetBool("matrix")
+			adaptive, _ := cmd.Flags().GetBool("adaptive")
+			variable, _ := cmd.Flags().GetBool("variable-lsb")
+			resync, _ := cmd.Flags().GetBool("resync")
+
+			if len(messages) != len(keys) {
+				return fmt.Errorf("every --message needs its own --key")
+			}
+			var recipients []embed.Recipient
+			for i := 1; i < len(messages); i++ {
+				recipients = append(recipients, embed.Recipient{SecretMessage: messages[i], StegoKey: keys[i]})
+			}
 
 			config := &embed.EmbedConfig{
-				CoverAudio:    cover,
-				SecretMessage: message,
-				StegoKey:      key,
-				NLsb:          lsb,
-				UseRandomSeed: random,
-				UseEncryption: encrypt, // set config sesuai var encrypt
-				OutputPath:    output,
+				CoverAudio:      covers[0],
+				CoverAudios:     covers,
+				SecretMessage:   messages[0],
+				StegoKey:        keys[0],
+				NLsb:            lsb,
+				UseRandomSeed:   random,
+				UseEncryption:   encrypt, // set config sesuai var encrypt
+				OutputPath:      output,
+				Method:          method,
+				ChannelLayout:   layout,
+				CopyTags:        copyTags,
+				Threshold:       threshold,
+				ErasureCoding:   erasure,
+				Recipients:      recipients,
+				Deniable:        deniable,
+				NoiseFill:       fillNoise,
+				Matching:        matching,
+				MatrixEmbedding: matrix,
+				Adaptive:        adaptive,
+				VariableLsb:     variable,
+				Resync:          resync,
 			}
 
 			return embed.Embed(config)
 		},
 	}
 
-	cmd.Flags().StringP("cover", "c", "", "Cover audio file (MP3)")
-	cmd.Flags().StringP("message", "m", "", "Secret file to embed (any file type)")
-	cmd.Flags().StringP("key", "k", "", "Steganography key (max 25 characters)")
+	cmd.Flags().StringArrayP("cover", "c", nil, "Cover audio file (MP3 or WAV) or directory; repeat to shard the payload across several covers")
+	cmd.Flags().StringArrayP("message", "m", nil, "Secret file to embed (any file type); repeat with --key to hide one message per key")
+	cmd.Flags().StringArrayP("key", "k", nil, "Steganography key (max 25 characters); the n-th key extracts the n-th message")
 	cmd.Flags().IntP("lsb", "l", 1, "Number of LSB bits to use (1-4)")
 	cmd.Flags().BoolP("random", "r", false, "Use random seed for embedding positions")
 	cmd.Flags().BoolP("encrypt", "e", false, "Encrypt the message before embedding") // flag untuk enkripsi
-	cmd.Flags().StringP("output", "o", "", "Output stego audio file")
+	cmd.Flags().StringP("output", "o", "", "Output stego audio file (.wav keeps sample-domain methods lossless), or a directory when sharding")
+	cmd.Flags().String("method", embed.DefaultMethod, "Embedding method ("+methodNames()+")")
+	cmd.Flags().String("layout", "interleaved", "Channel layout for sample-domain methods (interleaved, per-channel)")
+	cmd.Flags().Bool("copy-tags", false, "Copy ID3/APE tags from the cover when re-encoding to MP3")
+	cmd.Flags().Int("threshold", 0, "Give every cover a share so that any k of them recover the message (0 disables)")
+	cmd.Flags().Bool("variable-lsb", false, "Let loud samples carry up to --lsb bits and quiet ones fewer (lsb only)")
+	cmd.Flags().Bool("adaptive", false, "Embed only in the loudest parts of the cover (lsb, lsb-robust)")
+	cmd.Flags().Bool("matrix", false, "Use Hamming-code matrix embedding to change fewer positions (bitstream only)")
+	cmd.Flags().Bool("matching", false, "Use LSB matching (±1) instead of LSB replacement (bitstream only)")
+	cmd.Flags().Bool("resync", false, "Split the payload into blocks with keyed sync markers so a trimmed file still extracts (bitstream only)")
+	cmd.Flags().Bool("fill-noise", false, "Overwrite all unused capacity with keyed noise so the payload length cannot be inferred")
+	cmd.Flags().Bool("deniable", false, "Encrypt headers and payloads and fill unused positions with noise (bitstream only); the first message becomes a decoy")
+	cmd.Flags().Bool("erasure-coding", false, "With --threshold, make each share about 1/k of the message instead of all of it")
 
 	cmd.MarkFlagRequired("cover")
 	cmd.MarkFlagRequired("message")
@@ -71,17 +133,20 @@ func extractCmd() *cobra.Command {
 		Short: "Extract a secret message from an MP3 file",
 		Long:  "Extract a secret message from an MP3 audio file that contains embedded data.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stego, _ := cmd.Flags().GetString("stego")
+			stegos, _ := cmd.Flags().GetStringArray("stego")
 			key, _ := cmd.Flags().GetString("key")
 			output, _ := cmd.Flags().GetString("output")
 			decrypt, _ := cmd.Flags().GetBool("decrypt") // args untuk enkripsi
+			method, _ := cmd.Flags().GetString("method")
 
 
 			config := &extract.ExtractConfig{
-				StegoAudio: stego,
+				StegoAudio:  stegos[0],
+				StegoAudios: stegos,
 				StegoKey:   key,
 				OutputPath: output,
 				UseDecryption: decrypt, // set config sesuai var decrypt
+				Method:     method,
 
 			}
 
@@ -89,10 +154,11 @@ func extractCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringP("stego", "s", "", "Stego audio file (MP3)")
+	cmd.Flags().StringArrayP("stego", "s", nil, "Stego audio file (MP3) or directory; repeat to reassemble a sharded payload or combine threshold shares")
 	cmd.Flags().StringP("key", "k", "", "Steganography key (max 25 characters)")
 	cmd.Flags().StringP("output", "o", "", "Output extracted file")
 	cmd.Flags().BoolP("decrypt", "d", false, "Decrypt the message after extracting") // flag untuk enkripsi
+	cmd.Flags().String("method", "", "Force an embedding method instead of detecting it from the header")
 
 
 	cmd.MarkFlagRequired("stego")
@@ -101,3 +167,306 @@ func extractCmd() *cobra.Command {
 
 	return cmd
 }
+
+func analyzeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Describe an MP3 file and run frame-level steganalysis on it",
+		Long:  "Report the stream structure, tags and per-method capacity, then parse every MP3 frame and report decode errors, big_values anomalies, part2_3_length statistics and MP3Stego indicators.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, _ := cmd.Flags().GetString("input")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+
+			structure, err := lame.AnalyzeMP3Structure(input)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(input)
+			if err != nil {
+				return fmt.Errorf("failed to read MP3 file: %w", err)
+			}
+			printStructure(structure, stego.Capacities(data, &stego.Params{StegoKey: "capacity", NLsb: 1}))
+
+			report, err := steganalysis.AnalyzeFile(input)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("\nFrames: %d\n", report.Frames)
+			fmt.Printf("Damaged frames: %d (%.2f%%)\n", report.DamagedFrames, report.DamagedRatio()*100)
+			fmt.Printf("Huffman/main data decode errors: %d\n", report.DecodeErrors)
+			fmt.Printf("big_values anomalies: %d\n", report.BigValuesAnomalies)
+			fmt.Printf("part2_3_length: mean %.1f, stddev %.1f, outliers %d, odd parity %.3f\n",
+				report.Part23Mean, report.Part23StdDev, report.Part23Outliers, report.Part23ParityRatio)
+			fmt.Printf("Bytes skipped to regain sync: %d\n", report.SkippedBytes)
+			fmt.Printf("MP3Stego score: %.3f (suspected: %t)\n", report.MP3StegoScore, report.MP3StegoSuspected)
+
+			if verbose {
+				for _, a := range report.Anomalies {
+					fmt.Printf("  frame %d @0x%x [%s] %s\n", a.Frame, a.Offset, a.Kind, a.Detail)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("input", "i", "", "MP3 file to analyze")
+	cmd.Flags().BoolP("verbose", "v", false, "List every per-frame anomaly")
+
+	cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+// printStructure prints a stream description and the capacity of every
+// method that can use the file.
+func printStructure(a *lame.MP3Analysis, capacities map[string]int) {
+	fmt.Printf("Format: %s Layer III, %d Hz, %s\n", a.Version, a.SampleRate, a.ChannelMode)
+	fmt.Printf("Audio frames: %d (%s)\n", a.FrameCount, a.Duration.Round(time.Millisecond))
+	if a.VBR {
+		fmt.Printf("Bitrate: VBR, %d kbps average\n", a.Bitrate)
+		bitrates := make([]int, 0, len(a.BitrateHistogram))
+		for bitrate := range a.BitrateHistogram {
+			bitrates = append(bitrates, bitrate)
+		}
+		sort.Ints(bitrates)
+		for _, bitrate := range bitrates {
+			fmt.Printf("  %3d kbps: %d frames\n", bitrate, a.BitrateHistogram[bitrate])
+		}
+	} else {
+		fmt.Printf("Bitrate: CBR, %d kbps\n", a.Bitrate)
+	}
+
+	if a.EncoderTag != nil {
+		fmt.Printf("Encoder tag: %s", a.EncoderTag.Kind)
+		if a.EncoderTag.Encoder != "" {
+			fmt.Printf(" (%s)", a.EncoderTag.Encoder)
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("Encoder tag: none")
+	}
+	fmt.Printf("Tags: ID3v2 %d bytes, ID3v1 %d bytes, APE %d bytes\n", a.ID3v2Size, a.ID3v1Size, a.APESize)
+
+	fmt.Println("Capacity at 1 LSB:")
+	for _, m := range stego.Methods() {
+		if capacity, ok := capacities[m.Name()]; ok {
+			fmt.Printf("  %-20s %d bytes\n", m.Name(), capacity)
+		}
+	}
+}
+
+func capacityCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capacity",
+		Short: "Show how many bytes each method can hide in a cover",
+		Long:  "Compute the payload capacity of an MP3 cover for every registered embedding method, or for the one selected with --method.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cover, _ := cmd.Flags().GetString("cover")
+			key, _ := cmd.Flags().GetString("key")
+			lsb, _ := cmd.Flags().GetInt("lsb")
+			random, _ := cmd.Flags().GetBool("random")
+			method, _ := cmd.Flags().GetString("method")
+			variable, _ := cmd.Flags().GetBool("variable-lsb")
+
+			if err := utils.ValidateNLsb(lsb); err != nil {
+				return fmt.Errorf("invalid n_lsb: %w", err)
+			}
+
+			coverData, err := os.ReadFile(cover)
+			if err != nil {
+				return fmt.Errorf("failed to read MP3 file: %w", err)
+			}
+
+			methods := stego.Methods()
+			if method != "" {
+				m, err := stego.Lookup(method)
+				if err != nil {
+					return err
+				}
+				methods = []stego.Method{m}
+			}
+
+			params := &stego.Params{
+				StegoKey:      key,
+				NLsb:          lsb,
+				UseRandomSeed: random,
+			}
+			for _, m := range methods {
+				capacity, err := m.Capacity(coverData, params)
+				if err != nil {
+					fmt.Printf("%-20s unavailable: %v\n", m.Name(), err)
+					continue
+				}
+				if !variable || m.ID() != stego.LSBID {
+					fmt.Printf("%-20s %d bytes\n", m.Name(), capacity)
+					continue
+				}
+
+				// Variable nLsb lets loud samples go up to 4 bits.
+				variableParams := *params
+				variableParams.NLsb = 4
+				variableParams.VariableLsb = true
+				variableCapacity, err := m.Capacity(coverData, &variableParams)
+				if err != nil {
+					fmt.Printf("%-20s %d bytes\n", m.Name(), capacity)
+					continue
+				}
+				gain := 0.0
+				if capacity > 0 {
+					gain = float64(variableCapacity-capacity) / float64(capacity) * 100
+				}
+				fmt.Printf("%-20s %d bytes, %d bytes with variable nLsb up to 4 (%+.1f%%)\n", m.Name(), capacity, variableCapacity, gain)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("cover", "c", "", "Cover audio file (MP3 or WAV)")
+	cmd.Flags().StringP("key", "k", "capacity", "Steganography key (affects random position generation)")
+	cmd.Flags().IntP("lsb", "l", 1, "Number of LSB bits to use (1-4)")
+	cmd.Flags().BoolP("random", "r", false, "Use random seed for embedding positions")
+	cmd.Flags().String("method", "", "Only report this method")
+	cmd.Flags().Bool("variable-lsb", false, "Also show the capacity with variable nLsb of up to 4 bits per sample")
+
+	cmd.MarkFlagRequired("cover")
+
+	return cmd
+}
+
+func attackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attack",
+		Short: "Measure how well each method survives common transformations",
+		Long:  "Embed a random payload with every registered method, or the ones selected with --method, apply each attack of pkg/attacks to the stego file (ID3 rewrite, trimming, frame drops, bit flips, re-encoding, resampling, gain change, concatenation) and extract again. Prints a matrix with ok for an intact payload, fail when extraction failed, or the bit error rate.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cover, _ := cmd.Flags().GetString("cover")
+			key, _ := cmd.Flags().GetString("key")
+			lsb, _ := cmd.Flags().GetInt("lsb")
+			names, _ := cmd.Flags().GetStringArray("method")
+			size, _ := cmd.Flags().GetInt("size")
+			format, _ := cmd.Flags().GetString("format")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+			resync, _ := cmd.Flags().GetBool("resync")
+
+			if err := utils.ValidateNLsb(lsb); err != nil {
+				return fmt.Errorf("invalid n_lsb: %w", err)
+			}
+			if size < 1 {
+				return fmt.Errorf("payload size must be at least 1 byte")
+			}
+
+			coverData, err := os.ReadFile(cover)
+			if err != nil {
+				return fmt.Errorf("failed to read cover file: %w", err)
+			}
+
+			methods := stego.Methods()
+			if len(names) > 0 {
+				methods = nil
+				for _, name := range names {
+					m, err := stego.Lookup(name)
+					if err != nil {
+						return err
+					}
+					methods = append(methods, m)
+				}
+			}
+
+			params := &stego.Params{StegoKey: key, NLsb: lsb, OutputFormat: format, CopyTags: true}
+			if resync && (len(methods) != 1 || methods[0].ID() != stego.BitstreamID) {
+				return fmt.Errorf("--resync needs --method bitstream")
+			}
+			params.Resync = resync
+			suite := attacks.Standard()
+			rows := make([][]attacks.Result, len(methods))
+			embedErrs := make([]error, len(methods))
+			for i, m := range methods {
+				rows[i], embedErrs[i] = attackMethod(m, coverData, size, params, suite)
+			}
+
+			fmt.Printf("\n%-20s", "method")
+			for _, a := range suite {
+				fmt.Printf(" %10s", a.Name)
+			}
+			fmt.Println()
+			for i, m := range methods {
+				fmt.Printf("%-20s", m.Name())
+				if embedErrs[i] != nil {
+					fmt.Printf(" embed failed: %v\n", embedErrs[i])
+					continue
+				}
+				for _, r := range rows[i] {
+					fmt.Printf(" %10s", r)
+				}
+				fmt.Println()
+			}
+
+			if verbose {
+				fmt.Println()
+				for i, m := range methods {
+					for _, r := range rows[i] {
+						if r.Err != nil {
+							fmt.Printf("%s after %s: %v\n", m.Name(), r.Attack, r.Err)
+						}
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("cover", "c", "", "Cover audio file (MP3 or WAV)")
+	cmd.Flags().StringP("key", "k", "attack", "Steganography key")
+	cmd.Flags().IntP("lsb", "l", 1, "Number of LSB bits to use (1-4)")
+	cmd.Flags().StringArray("method", nil, "Only attack this method; repeat for several")
+	cmd.Flags().Int("size", 32, "Payload size in bytes, reduced to the capacity of methods that hold less")
+	cmd.Flags().String("format", "", "Output format for methods that re-encode (mp3, wav); empty keeps the format of the cover")
+	cmd.Flags().BoolP("verbose", "v", false, "List why every failed extraction failed")
+	cmd.Flags().Bool("resync", false, "Embed with resync blocks (bitstream only)")
+
+	cmd.MarkFlagRequired("cover")
+
+	return cmd
+}
+
+// attackMethod embeds a random payload of up to size bytes with m and runs
+// the attacks on the result.
+func attackMethod(m stego.Method, cover []byte, size int, params *stego.Params, suite []attacks.Attack) ([]attacks.Result, error) {
+	capacity, err := m.Capacity(cover, params)
+	if err != nil {
+		return nil, err
+	}
+	if capacity < 1 {
+		return nil, fmt.Errorf("no capacity")
+	}
+
+	payload := make([]byte, min(size, capacity))
+	rand.New(rand.NewSource(1)).Read(payload)
+	stegoData, err := m.Embed(cover, payload, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// A payload with lost resync blocks still has a bit error rate.
+	extract := func(data []byte) ([]byte, error) {
+		payload, err := m.Extract(data, params.StegoKey)
+		var partial *stego.PartialError
+		if errors.As(err, &partial) {
+			return partial.Payload, nil
+		}
+		return payload, err
+	}
+	return attacks.Run(stegoData, payload, extract, suite), nil
+}
+
+func methodNames() string {
+	var names []string
+	for _, m := range stego.Methods() {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, ", ")
+}
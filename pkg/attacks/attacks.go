@@ -0,0 +1,402 @@
+// Package attacks applies to stego files the transformations they meet on
+// the way to a recipient: tag editors, cutting, transmission errors,
+// re-encoding and level changes. Every attack takes a file and returns a
+// new one in the same container, so their effect on each embedding method
+// can be measured by extracting afterwards.
+//
+// Attacks that change samples decode the file and write it back in its own
+// format. An MP3 file is re-encoded with the built-in encoder at its own
+// bitrate and keeps its tags, so such an attack on an MP3 file includes one
+// decode and re-encode.
+package attacks
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/metadata"
+	"audio-steganography-lsb/pkg/mp3enc"
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+// FrameSamples is the length of a frame in sample frames. Frame attacks on
+// a WAV file work on blocks of this many, the length of an MPEG-1 Layer III
+// frame, so that the same count cuts about the same time from either
+// format.
+const FrameSamples = 1152
+
+// Attack is one named transformation of a stego file.
+type Attack struct {
+	Name  string
+	Apply func(data []byte) ([]byte, error)
+}
+
+// Standard returns the attacks the attack command runs, starting with an
+// unchanged copy as a baseline.
+func Standard() []Attack {
+	return []Attack{
+		{"none", func(data []byte) ([]byte, error) { return data, nil }},
+		{"id3", RewriteID3},
+		{"trim-start", func(data []byte) ([]byte, error) { return TrimStart(data, 2) }},
+		{"trim-end", func(data []byte) ([]byte, error) { return TrimEnd(data, 2) }},
+		{"drop", func(data []byte) ([]byte, error) { return DropFrames(data, 3, 1) }},
+		{"flip", func(data []byte) ([]byte, error) { return FlipBits(data, 16, 1) }},
+		{"mp3-96", func(data []byte) ([]byte, error) { return Reencode(data, 96) }},
+		{"resample", func(data []byte) ([]byte, error) { return Resample(data, 32000) }},
+		{"gain", func(data []byte) ([]byte, error) { return Gain(data, -3) }},
+		{"concat", func(data []byte) ([]byte, error) {
+			lead, err := Silence(data, 1)
+			if err != nil {
+				return nil, err
+			}
+			return Concatenate(lead, data)
+		}},
+	}
+}
+
+// RewriteID3 saves the file the way a tag editor would: text frames,
+// comments and album art are kept byte for byte, private and unknown frames
+// are dropped and the title is replaced. A file without an ID3v2 tag gets
+// one holding only the title.
+func RewriteID3(data []byte) ([]byte, error) {
+	if audio.IsWAV(data) {
+		return data, nil
+	}
+	keep := func(f metadata.Frame) bool {
+		return f.ID != "TIT2" && (f.ID[0] == 'T' || f.ID == "COMM" || f.ID == "APIC")
+	}
+	// ISO-8859-1 text.
+	title := metadata.Frame{ID: "TIT2", Body: append([]byte{0}, "Edited"...)}
+	out, err := metadata.ReplaceFrames(data, func(f metadata.Frame) bool { return !keep(f) }, title)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite ID3v2 tag: %w", err)
+	}
+	return out, nil
+}
+
+// TrimStart cuts n frames from the start of the audio.
+func TrimStart(data []byte, n int) ([]byte, error) {
+	return editFrames(data, func(count int) []int {
+		return span(min(n, count), count)
+	})
+}
+
+// TrimEnd cuts n frames from the end of the audio.
+func TrimEnd(data []byte, n int) ([]byte, error) {
+	return editFrames(data, func(count int) []int {
+		return span(0, max(count-n, 0))
+	})
+}
+
+// DropFrames removes n frames picked by seed, as a lossy network would.
+// The first and last frames are kept so that the length of the file does
+// not reveal which were lost.
+func DropFrames(data []byte, n int, seed int64) ([]byte, error) {
+	return editFrames(data, func(count int) []int {
+		if count < 3 {
+			return span(0, count)
+		}
+		dropped := make(map[int]bool)
+		rng := rand.New(rand.NewSource(seed))
+		for _, i := range rng.Perm(count - 2)[:min(n, count-2)] {
+			dropped[i+1] = true
+		}
+		var keep []int
+		for i := 0; i < count; i++ {
+			if !dropped[i] {
+				keep = append(keep, i)
+			}
+		}
+		return keep
+	})
+}
+
+// span returns the indexes from first up to end.
+func span(first, end int) []int {
+	indexes := make([]int, 0, end-first)
+	for i := first; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// editFrames rebuilds data from the frames whose indexes keep returns, in
+// that order. The frames of an MP3 file are its audio frames; its tags and
+// any Xing/Info frame stay where they are. The frames of a WAV file are
+// blocks of FrameSamples sample frames, the last one possibly shorter.
+func editFrames(data []byte, keep func(count int) []int) ([]byte, error) {
+	if audio.IsWAV(data) {
+		pcm, err := audio.DecodeWAV(data)
+		if err != nil {
+			return nil, err
+		}
+		block := FrameSamples * pcm.Channels
+		count := (len(pcm.Samples) + block - 1) / block
+		var samples []int16
+		for _, i := range keep(count) {
+			samples = append(samples, pcm.Samples[i*block:min((i+1)*block, len(pcm.Samples))]...)
+		}
+		return audio.EncodeWAV(&audio.PCM{Samples: samples, SampleRate: pcm.SampleRate, Channels: pcm.Channels}), nil
+	}
+
+	stream, err := mp3frame.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	leading, trailing := stream.Tags()
+	frames := stream.Frames
+	out := append([]byte(nil), leading...)
+	if stream.Tag != nil {
+		out = append(out, frameBytes(data, frames[0])...)
+		frames = frames[1:]
+	}
+	for _, i := range keep(len(frames)) {
+		out = append(out, frameBytes(data, frames[i])...)
+	}
+	return append(out, trailing...), nil
+}
+
+func frameBytes(data []byte, f *mp3frame.Frame) []byte {
+	return data[f.Offset : f.Offset+f.Length]
+}
+
+// FlipBits inverts n bits of the audio at positions picked by seed, as a
+// noisy channel would. Tags are left alone; in an MP3 file frame headers
+// are not, so a flip may cost a frame its sync.
+func FlipBits(data []byte, n int, seed int64) ([]byte, error) {
+	rng := rand.New(rand.NewSource(seed))
+	if audio.IsWAV(data) {
+		pcm, err := audio.DecodeWAV(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(pcm.Samples) == 0 {
+			return data, nil
+		}
+		for i := 0; i < n; i++ {
+			pcm.Samples[rng.Intn(len(pcm.Samples))] ^= 1 << rng.Intn(16)
+		}
+		return audio.EncodeWAV(pcm), nil
+	}
+
+	stream, err := mp3frame.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	start := stream.Frames[0].Offset
+	if stream.Tag != nil && len(stream.Frames) > 1 {
+		start = stream.Frames[1].Offset
+	}
+	out := append([]byte(nil), data...)
+	for i := 0; i < n; i++ {
+		out[start+rng.Intn(stream.AudioEnd-start)] ^= 1 << rng.Intn(8)
+	}
+	return out, nil
+}
+
+// Reencode decodes the file and encodes it to MP3 at bitrate kbps with the
+// built-in encoder. The tags of an MP3 file are carried over.
+func Reencode(data []byte, bitrate int) ([]byte, error) {
+	pcm, err := audio.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	out, err := encodeMP3(pcm, bitrate)
+	if err != nil {
+		return nil, err
+	}
+	return withTags(data, out), nil
+}
+
+// Resample converts the audio to rate Hz and back by linear interpolation,
+// which smooths the top of the spectrum as cheap sample rate converters
+// do.
+func Resample(data []byte, rate int) ([]byte, error) {
+	return editSamples(data, func(pcm *audio.PCM) {
+		original := pcm.SampleRate
+		pcm.Samples = resample(resample(pcm.Samples, pcm.Channels, original, rate), pcm.Channels, rate, original)
+	})
+}
+
+// resample converts interleaved samples from one rate to another. The
+// output has the length of the input scaled by the ratio of the rates.
+func resample(samples []int16, channels, from, to int) []int16 {
+	in := len(samples) / channels
+	out := int(int64(in) * int64(to) / int64(from))
+	result := make([]int16, out*channels)
+	for i := 0; i < out; i++ {
+		pos := float64(i) * float64(from) / float64(to)
+		j := int(pos)
+		frac := pos - float64(j)
+		next := min(j+1, in-1)
+		for ch := 0; ch < channels; ch++ {
+			a := float64(samples[j*channels+ch])
+			b := float64(samples[next*channels+ch])
+			result[i*channels+ch] = clamp(a + (b-a)*frac)
+		}
+	}
+	return result
+}
+
+// Gain scales the audio by db decibels, clipping what no longer fits.
+func Gain(data []byte, db float64) ([]byte, error) {
+	factor := math.Pow(10, db/20)
+	return editSamples(data, func(pcm *audio.PCM) {
+		for i, s := range pcm.Samples {
+			pcm.Samples[i] = clamp(float64(s) * factor)
+		}
+	})
+}
+
+// Silence returns seconds of silence in the format of like: a WAV file of
+// the same sample rate and channels, or an untagged MP3 file that also
+// matches its bitrate. An MP3 file is only parsed, not decoded, so damaged
+// audio does not matter.
+func Silence(like []byte, seconds float64) ([]byte, error) {
+	silence := func(sampleRate, channels int) *audio.PCM {
+		return &audio.PCM{
+			Samples:    make([]int16, int(seconds*float64(sampleRate))*channels),
+			SampleRate: sampleRate,
+			Channels:   channels,
+		}
+	}
+	if audio.IsWAV(like) {
+		pcm, err := audio.DecodeWAV(like)
+		if err != nil {
+			return nil, err
+		}
+		return audio.EncodeWAV(silence(pcm.SampleRate, pcm.Channels)), nil
+	}
+
+	stream, err := mp3frame.Parse(like)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	h := stream.Frames[0].Header
+	return encodeMP3(silence(h.SampleRate, h.Channels()), h.Bitrate)
+}
+
+// Concatenate joins the audio of several files of one format, one after
+// the other. WAV files must share sample rate and channels. MP3 files are
+// joined frame by frame and must share sample rate and channel count;
+// Xing/Info frames are dropped, and the tags are those of the first file
+// that has any, as tools that join files copy the metadata of the first
+// tagged input.
+func Concatenate(parts ...[]byte) ([]byte, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("nothing to concatenate")
+	}
+
+	if audio.IsWAV(parts[0]) {
+		var joined *audio.PCM
+		for i, part := range parts {
+			pcm, err := audio.DecodeWAV(part)
+			if err != nil {
+				return nil, fmt.Errorf("part %d: %w", i+1, err)
+			}
+			if joined == nil {
+				joined = pcm.Clone()
+				continue
+			}
+			if pcm.SampleRate != joined.SampleRate || pcm.Channels != joined.Channels {
+				return nil, fmt.Errorf("part %d: %d Hz, %d channels does not match %d Hz, %d channels",
+					i+1, pcm.SampleRate, pcm.Channels, joined.SampleRate, joined.Channels)
+			}
+			joined.Samples = append(joined.Samples, pcm.Samples...)
+		}
+		return audio.EncodeWAV(joined), nil
+	}
+
+	var first *mp3frame.Header
+	var leading, trailing, frames []byte
+	for i, part := range parts {
+		stream, err := mp3frame.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("part %d: failed to parse MP3: %w", i+1, err)
+		}
+		audioFrames := stream.Frames
+		if stream.Tag != nil {
+			audioFrames = audioFrames[1:]
+		}
+		for _, f := range audioFrames {
+			if first == nil {
+				first = &f.Header
+			}
+			if f.Header.SampleRate != first.SampleRate || f.Header.Channels() != first.Channels() {
+				return nil, fmt.Errorf("part %d: %d Hz, %d channels does not match %d Hz, %d channels",
+					i+1, f.Header.SampleRate, f.Header.Channels(), first.SampleRate, first.Channels())
+			}
+			frames = append(frames, frameBytes(part, f)...)
+		}
+		if lead, trail := stream.Tags(); leading == nil && trailing == nil && len(lead)+len(trail) > 0 {
+			leading, trailing = lead, trail
+		}
+	}
+
+	out := append(append([]byte(nil), leading...), frames...)
+	return append(out, trailing...), nil
+}
+
+// editSamples decodes the file, lets edit change the samples and writes
+// the result back in the format of the file.
+func editSamples(data []byte, edit func(pcm *audio.PCM)) ([]byte, error) {
+	pcm, err := audio.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	edit(pcm)
+	if audio.IsWAV(data) {
+		return audio.EncodeWAV(pcm), nil
+	}
+
+	stream, err := mp3frame.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	out, err := encodeMP3(pcm, stream.Frames[0].Header.Bitrate)
+	if err != nil {
+		return nil, err
+	}
+	return withTags(data, out), nil
+}
+
+// encodeMP3 encodes pcm the way stego methods write MP3 output.
+func encodeMP3(pcm *audio.PCM, bitrate int) ([]byte, error) {
+	data, err := mp3enc.Encode(pcm.Samples, mp3enc.Config{
+		SampleRate: pcm.SampleRate,
+		Channels:   pcm.Channels,
+		Bitrate:    bitrate,
+		Mode:       mp3frame.ModeJointStereo,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode to MP3: %w", err)
+	}
+	return data, nil
+}
+
+// withTags wraps out in the tags of original when both are MP3 files.
+func withTags(original, out []byte) []byte {
+	if audio.IsWAV(original) {
+		return out
+	}
+	stream, err := mp3frame.Parse(original)
+	if err != nil {
+		return out
+	}
+	leading, trailing := stream.Tags()
+	tagged := append(append([]byte(nil), leading...), out...)
+	return append(tagged, trailing...)
+}
+
+func clamp(v float64) int16 {
+	v = math.Round(v)
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
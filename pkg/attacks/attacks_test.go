@@ -0,0 +1,216 @@
+package attacks
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/bits"
+	"os"
+	"testing"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/metadata"
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// covers returns 115 frames, about three seconds, of the test cover as
+// WAV, and as MP3 wrapped in an ID3v2 tag.
+func covers(t *testing.T) (wav, mp3 []byte) {
+	t.Helper()
+	data, err := os.ReadFile("../../test/cover-1.mp3")
+	require.NoError(t, err)
+	pcm, err := audio.DecodeMP3(data)
+	require.NoError(t, err)
+	start := 10 * pcm.SampleRate * pcm.Channels
+	pcm.Samples = pcm.Samples[start : start+115*FrameSamples*pcm.Channels]
+
+	encoded, err := encodeMP3(pcm, 128)
+	require.NoError(t, err)
+	mp3, err = metadata.ReplaceFrames(encoded, nil,
+		metadata.Frame{ID: "TIT2", Body: append([]byte{0}, "Cover"...)},
+		metadata.Frame{ID: "TPE1", Body: append([]byte{0}, "Artist"...)},
+		metadata.Frame{ID: "PRIV", Body: []byte("owner\x00secret")})
+	require.NoError(t, err)
+	return audio.EncodeWAV(pcm), mp3
+}
+
+func audioFrames(t *testing.T, data []byte) int {
+	t.Helper()
+	stream, err := mp3frame.Parse(data)
+	require.NoError(t, err)
+	return len(stream.Frames)
+}
+
+func samples(t *testing.T, data []byte) []int16 {
+	t.Helper()
+	pcm, err := audio.Decode(data)
+	require.NoError(t, err)
+	return pcm.Samples
+}
+
+func TestRewriteID3(t *testing.T) {
+	_, mp3 := covers(t)
+	out, err := RewriteID3(mp3)
+	require.NoError(t, err)
+
+	tag, err := metadata.ParseTag(out)
+	require.NoError(t, err)
+	ids := map[string]string{}
+	for _, f := range tag.Frames {
+		ids[f.ID] = string(f.Body[1:])
+	}
+	assert.Equal(t, map[string]string{"TIT2": "Edited", "TPE1": "Artist"}, ids)
+	assert.Equal(t, audioFrames(t, mp3), audioFrames(t, out))
+}
+
+func TestFrameEdits(t *testing.T) {
+	wav, mp3 := covers(t)
+	frames := audioFrames(t, mp3)
+	for _, tc := range []struct {
+		name   string
+		attack func([]byte) ([]byte, error)
+		lost   int
+	}{
+		{"trim-start", func(d []byte) ([]byte, error) { return TrimStart(d, 2) }, 2},
+		{"trim-end", func(d []byte) ([]byte, error) { return TrimEnd(d, 3) }, 3},
+		{"drop", func(d []byte) ([]byte, error) { return DropFrames(d, 4, 1) }, 4},
+	} {
+		out, err := tc.attack(mp3)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, frames-tc.lost, audioFrames(t, out), tc.name)
+		assert.True(t, bytes.HasPrefix(out, mp3[:32]), "%s keeps the tag", tc.name)
+
+		out, err = tc.attack(wav)
+		require.NoError(t, err, tc.name)
+		assert.Len(t, samples(t, out), len(samples(t, wav))-tc.lost*FrameSamples*2, tc.name)
+	}
+
+	// Trimming keeps the frames at the other end as they were.
+	out, err := TrimStart(wav, 1)
+	require.NoError(t, err)
+	assert.Equal(t, samples(t, wav)[FrameSamples*2:], samples(t, out))
+	out, err = TrimEnd(mp3, frames+5)
+	require.NoError(t, err)
+	_, err = mp3frame.Parse(out)
+	assert.Error(t, err)
+}
+
+func TestFlipBits(t *testing.T) {
+	wav, mp3 := covers(t)
+	out, err := FlipBits(mp3, 10, 7)
+	require.NoError(t, err)
+	require.Len(t, out, len(mp3))
+	flipped := 0
+	for i := range out {
+		flipped += bits.OnesCount8(out[i] ^ mp3[i])
+	}
+	assert.Equal(t, 10, flipped)
+	tag, err := metadata.ParseTag(mp3)
+	require.NoError(t, err)
+	assert.Equal(t, mp3[:tag.Size], out[:tag.Size])
+
+	again, err := FlipBits(mp3, 10, 7)
+	require.NoError(t, err)
+	assert.Equal(t, out, again, "the same seed flips the same bits")
+
+	out, err = FlipBits(wav, 10, 7)
+	require.NoError(t, err)
+	changed := 0
+	original := samples(t, wav)
+	for i, s := range samples(t, out) {
+		if s != original[i] {
+			changed++
+		}
+	}
+	assert.Equal(t, 10, changed)
+}
+
+func TestSampleEdits(t *testing.T) {
+	wav, mp3 := covers(t)
+	original := samples(t, wav)
+
+	out, err := Gain(wav, -6)
+	require.NoError(t, err)
+	gained := samples(t, out)
+	require.Len(t, gained, len(original))
+	for i := 0; i < len(original); i += 997 {
+		assert.InDelta(t, float64(original[i])*math.Pow(10, -6.0/20), float64(gained[i]), 0.5)
+	}
+
+	out, err = Resample(wav, 22050)
+	require.NoError(t, err)
+	assert.Len(t, samples(t, out), len(original))
+
+	for _, attack := range []func([]byte) ([]byte, error){
+		func(d []byte) ([]byte, error) { return Gain(d, 3) },
+		func(d []byte) ([]byte, error) { return Resample(d, 32000) },
+		func(d []byte) ([]byte, error) { return Reencode(d, 96) },
+	} {
+		out, err := attack(mp3)
+		require.NoError(t, err)
+		assert.False(t, audio.IsWAV(out))
+		assert.True(t, bytes.HasPrefix(out, mp3[:32]), "MP3 output keeps the tag")
+	}
+
+	out, err = Reencode(mp3, 96)
+	require.NoError(t, err)
+	stream, err := mp3frame.Parse(out)
+	require.NoError(t, err)
+	assert.Equal(t, 96, stream.Frames[0].Header.Bitrate)
+}
+
+func TestConcatenate(t *testing.T) {
+	wav, mp3 := covers(t)
+
+	lead, err := Silence(wav, 0.5)
+	require.NoError(t, err)
+	out, err := Concatenate(lead, wav)
+	require.NoError(t, err)
+	joined := samples(t, out)
+	assert.Len(t, joined, len(samples(t, wav))+44100)
+	assert.Equal(t, samples(t, wav), joined[44100:])
+
+	lead, err = Silence(mp3, 0.5)
+	require.NoError(t, err)
+	out, err = Concatenate(lead, mp3)
+	require.NoError(t, err)
+	assert.Equal(t, audioFrames(t, lead)+audioFrames(t, mp3), audioFrames(t, out))
+	assert.True(t, bytes.HasPrefix(out, mp3[:32]), "the tag of the MP3 cover is kept")
+
+	_, err = Concatenate(wav, mp3)
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	payload := []byte{0xFF, 0x00}
+	results := Run([]byte("stego"), payload, func(data []byte) ([]byte, error) {
+		switch string(data) {
+		case "stego":
+			return payload, nil
+		case "noisy":
+			return []byte{0xFE, 0x01}, nil
+		case "short":
+			return []byte{0xFF}, nil
+		}
+		return nil, errors.New("no header")
+	}, []Attack{
+		{"none", func(d []byte) ([]byte, error) { return d, nil }},
+		{"noise", func([]byte) ([]byte, error) { return []byte("noisy"), nil }},
+		{"cut", func([]byte) ([]byte, error) { return []byte("short"), nil }},
+		{"wipe", func([]byte) ([]byte, error) { return []byte("wiped"), nil }},
+		{"broken", func([]byte) ([]byte, error) { return nil, errors.New("bad input") }},
+	})
+
+	require.Len(t, results, 5)
+	assert.True(t, results[0].Recovered())
+	assert.Equal(t, "ok", results[0].String())
+	assert.InDelta(t, 0.125, results[1].BER, 1e-9)
+	assert.Equal(t, "12.5%", results[1].String())
+	assert.InDelta(t, 0.5, results[2].BER, 1e-9)
+	assert.Equal(t, "fail", results[3].String())
+	assert.ErrorContains(t, results[4].Err, "attack failed")
+	assert.Equal(t, "broken", results[4].Attack)
+}
@@ -0,0 +1,74 @@
+package attacks
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Result is what extraction recovered after one attack.
+type Result struct {
+	Attack string
+	// Err is set when the attack or the extraction failed, so nothing was
+	// recovered.
+	Err error
+	// BER is the fraction of payload bits extracted wrong. Bits missing
+	// from a short extraction count as wrong.
+	BER float64
+}
+
+// Recovered reports whether the payload came out intact.
+func (r Result) Recovered() bool {
+	return r.Err == nil && r.BER == 0
+}
+
+// String is "ok" for an intact payload, "fail" when nothing was extracted
+// and the bit error rate otherwise.
+func (r Result) String() string {
+	switch {
+	case r.Err != nil:
+		return "fail"
+	case r.BER == 0:
+		return "ok"
+	default:
+		return fmt.Sprintf("%.1f%%", r.BER*100)
+	}
+}
+
+// Run applies every attack to stego and extracts from the result with
+// extract, comparing what comes out to payload.
+func Run(stego, payload []byte, extract func([]byte) ([]byte, error), attacks []Attack) []Result {
+	results := make([]Result, len(attacks))
+	for i, a := range attacks {
+		results[i].Attack = a.Name
+		attacked, err := a.Apply(stego)
+		if err != nil {
+			results[i].Err = fmt.Errorf("attack failed: %w", err)
+			continue
+		}
+		extracted, err := extract(attacked)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].BER = BitErrorRate(payload, extracted)
+	}
+	return results
+}
+
+// BitErrorRate returns the fraction of the bits of want that got differs
+// in. Bits past the end of got count as errors; extra bytes in got are
+// ignored.
+func BitErrorRate(want, got []byte) float64 {
+	if len(want) == 0 {
+		return 0
+	}
+	errors := 0
+	for i, b := range want {
+		if i >= len(got) {
+			errors += 8
+			continue
+		}
+		errors += bits.OnesCount8(b ^ got[i])
+	}
+	return float64(errors) / float64(len(want)*8)
+}
@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// PCM is decoded 16-bit audio. Samples are interleaved when Channels > 1.
+type PCM struct {
+	Samples    []int16
+	SampleRate int
+	Channels   int
+}
+
+func (p *PCM) Clone() *PCM {
+	samples := make([]int16, len(p.Samples))
+	copy(samples, p.Samples)
+	return &PCM{Samples: samples, SampleRate: p.SampleRate, Channels: p.Channels}
+}
+
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// Decode accepts either a WAV or an MP3 file.
+func Decode(data []byte) (*PCM, error) {
+	if IsWAV(data) {
+		return DecodeWAV(data)
+	}
+	return DecodeMP3(data)
+}
+
+// DecodeMP3 decodes an MP3 file with go-mp3. go-mp3 always produces 16-bit
+// stereo output, so the channel count is taken from the first frame header
+// and mono streams are reduced back to one channel.
+func DecodeMP3(data []byte) (*PCM, error) {
+	decoder, err := mp3.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MP3 decoder: %w", err)
+	}
+
+	raw, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio data: %w", err)
+	}
+
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+	}
+
+	pcm := &PCM{
+		Samples:    samples,
+		SampleRate: decoder.SampleRate(),
+		Channels:   2,
+	}
+	if mp3Channels(data) == 1 {
+		mono := make([]int16, len(samples)/2)
+		for i := range mono {
+			mono[i] = samples[i*2]
+		}
+		pcm.Samples = mono
+		pcm.Channels = 1
+	}
+	return pcm, nil
+}
+
+// mp3Channels returns the channel count of the first frame, or 2 when no
+// frame header can be found.
+func mp3Channels(data []byte) int {
+	stream, err := mp3frame.Parse(data)
+	if err != nil || len(stream.Frames) == 0 {
+		return 2
+	}
+	return stream.Frames[0].Header.Channels()
+}
+
+func DecodeWAV(data []byte) (*PCM, error) {
+	if !IsWAV(data) {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var pcm *PCM
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := pos + 8
+		if body+size > len(data) {
+			size = len(data) - body
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("invalid WAV fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(data[body:])
+			bitsPerSample := binary.LittleEndian.Uint16(data[body+14:])
+			if (format != 1 && format != 0xFFFE) || bitsPerSample != 16 {
+				return nil, fmt.Errorf("unsupported WAV format: only 16-bit PCM is supported")
+			}
+			pcm = &PCM{
+				Channels:   int(binary.LittleEndian.Uint16(data[body+2:])),
+				SampleRate: int(binary.LittleEndian.Uint32(data[body+4:])),
+			}
+		case "data":
+			if pcm == nil {
+				return nil, fmt.Errorf("WAV data chunk before fmt chunk")
+			}
+			pcm.Samples = make([]int16, size/2)
+			for i := range pcm.Samples {
+				pcm.Samples[i] = int16(binary.LittleEndian.Uint16(data[body+i*2:]))
+			}
+			return pcm, nil
+		}
+
+		pos = body + size + size%2
+	}
+
+	return nil, fmt.Errorf("WAV file has no data chunk")
+}
+
+func EncodeWAV(pcm *PCM) []byte {
+	dataSize := len(pcm.Samples) * 2
+	out := make([]byte, 44+dataSize)
+
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+dataSize))
+	copy(out[8:], "WAVE")
+	copy(out[12:], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], 1)
+	binary.LittleEndian.PutUint16(out[22:], uint16(pcm.Channels))
+	binary.LittleEndian.PutUint32(out[24:], uint32(pcm.SampleRate))
+	binary.LittleEndian.PutUint32(out[28:], uint32(pcm.SampleRate*pcm.Channels*2))
+	binary.LittleEndian.PutUint16(out[32:], uint16(pcm.Channels*2))
+	binary.LittleEndian.PutUint16(out[34:], 16)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(dataSize))
+
+	for i, sample := range pcm.Samples {
+		binary.LittleEndian.PutUint16(out[44+i*2:], uint16(sample))
+	}
+
+	return out
+}
@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"os"
+	"testing"
+
+	"audio-steganography-lsb/pkg/mp3enc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAVRoundTrip(t *testing.T) {
+	pcm := &PCM{
+		Samples:    []int16{0, 1, -1, 32767, -32768, 1234, -4321, 7},
+		SampleRate: 22050,
+		Channels:   2,
+	}
+
+	data := EncodeWAV(pcm)
+	assert.True(t, IsWAV(data))
+	assert.Len(t, data, 44+len(pcm.Samples)*2)
+
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, pcm, decoded)
+}
+
+func TestDecodeWAVErrors(t *testing.T) {
+	_, err := DecodeWAV([]byte("not a wav file"))
+	assert.Error(t, err)
+
+	data := EncodeWAV(&PCM{Samples: []int16{1, 2}, SampleRate: 8000, Channels: 1})
+	data[34] = 8
+	_, err = DecodeWAV(data)
+	assert.Error(t, err)
+
+	_, err = DecodeWAV(data[:36])
+	assert.Error(t, err)
+}
+
+func TestDecodeMP3(t *testing.T) {
+	data, err := os.ReadFile("../../test/cover-1.mp3")
+	require.NoError(t, err)
+	assert.False(t, IsWAV(data))
+
+	pcm, err := Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, 44100, pcm.SampleRate)
+	assert.Equal(t, 2, pcm.Channels)
+	assert.NotEmpty(t, pcm.Samples)
+
+	clone := pcm.Clone()
+	clone.Samples[0]++
+	assert.NotEqual(t, pcm.Samples[0], clone.Samples[0])
+}
+
+func TestDecodeMonoMP3(t *testing.T) {
+	samples := make([]int16, 22050)
+	for i := range samples {
+		samples[i] = int16((i*41)%2000 - 1000)
+	}
+	data, err := mp3enc.Encode(samples, mp3enc.Config{SampleRate: 44100, Channels: 1, Bitrate: 96})
+	require.NoError(t, err)
+
+	pcm, err := DecodeMP3(data)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pcm.Channels)
+	assert.Equal(t, 44100, pcm.SampleRate)
+	assert.GreaterOrEqual(t, len(pcm.Samples), len(samples)+mp3enc.Delay)
+}
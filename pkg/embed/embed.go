@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"audio-steganography-lsb/pkg/shard"
+	"audio-steganography-lsb/pkg/stego"
 	"audio-steganography-lsb/pkg/utils"
 
 	// "github.com/hajimehoshi/go-mp3"
@@ -14,15 +17,67 @@ import (
 )
 
 type EmbedConfig struct {
-	CoverAudio     string
-	SecretMessage  string
-	StegoKey       string
-	NLsb           int
-	UseRandomSeed  bool
-	UseEncryption  bool
-	OutputPath     string
+	CoverAudio      string
+	// CoverAudios lists several covers, or directories of covers, to shard
+	// the payload across. When it holds more than one file, CoverAudio is
+	// ignored and OutputPath is a directory that receives one stego file
+	// per cover used, named after the cover.
+	CoverAudios     []string
+	SecretMessage   string
+	StegoKey        string
+	NLsb            int
+	UseRandomSeed   bool
+	UseEncryption   bool
+	OutputPath      string
+	Method          string
+	// ChannelLayout is "interleaved" or "per-channel"; empty means
+	// interleaved. Only sample-domain methods use it.
+	ChannelLayout   string
+	// CopyTags carries the cover's ID3 and APE tags over when the output
+	// is re-encoded to MP3.
+	CopyTags        bool
+	// Threshold, when positive, turns the covers into a k-of-n threshold
+	// scheme: every cover gets a share and any Threshold of them recover
+	// the payload. OutputPath is then always a directory.
+	Threshold       int
+	// ErasureCoding makes threshold shares about 1/Threshold of the
+	// payload instead of as large as it.
+	ErasureCoding   bool
+	// Recipients are further messages hidden in the same cover, each under
+	// its own key, next to SecretMessage. Extracting with one key only
+	// finds that key's message.
+	Recipients      []Recipient
+	// Deniable encrypts every header and payload and fills the rest of the
+	// cover with noise. Together with a recipient it makes SecretMessage a
+	// decoy that can be handed over while the other key stays secret.
+	Deniable        bool
+	// NoiseFill overwrites all unused capacity with keyed noise so the
+	// payload length cannot be inferred from the LSB statistics.
+	NoiseFill       bool
+	// Matching embeds by LSB matching (±1) instead of LSB replacement.
+	Matching        bool
+	// MatrixEmbedding layers a Hamming code over the carriers so that fewer
+	// of them change, at the cost of capacity.
+	MatrixEmbedding bool
+	// Resync splits the payload into blocks with keyed sync markers so
+	// that a file cut at either end still gives up its intact blocks.
+	Resync          bool
+	// Adaptive embeds only in the loudest parts of the cover. Only the lsb
+	// and lsb-robust methods support it.
+	Adaptive        bool
+	// VariableLsb lets loud samples carry up to NLsb bits and quiet ones
+	// fewer or none. Only the lsb method supports it.
+	VariableLsb     bool
 }
 
+// Recipient is one extra message and the key that extracts it.
+type Recipient struct {
+	SecretMessage string
+	StegoKey      string
+}
+
+const DefaultMethod = "bitstream"
+
 type FileMetadata struct {
 	OriginalFilename string
 	FileExtension    string
@@ -42,232 +97,299 @@ func Embed(config *EmbedConfig) error {
 		return fmt.Errorf("invalid n_lsb: %w", err)
 	}
 
-	messageData, err := utils.ReadFile(config.SecretMessage)
-	if err != nil {
-		return fmt.Errorf("failed to read secret message: %w", err)
-	}else if config.UseEncryption{
-		messageData = vigenere.Encrypt(messageData, config.StegoKey)
+	methodName := config.Method
+	if methodName == "" {
+		methodName = DefaultMethod
 	}
-
-	// stegoMetadata, err := metadata.CreateMetadataFromFile(
-	// 	config.SecretMessage,
-	// 	config.UseEncryption,
-	// 	config.UseRandomSeed,
-	// 	config.NLsb,
-	// )
-	fileInfo, err := os.Stat(config.SecretMessage)
+	method, err := stego.Lookup(methodName)
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return err
 	}
 
-	metadata := &FileMetadata{
-		OriginalFilename: filepath.Base(config.SecretMessage),
-		FileExtension:    filepath.Ext(config.SecretMessage),
-		FileSize:         fileInfo.Size(),
-		UseEncryption:    config.UseEncryption,
-		UseRandomSeed:    config.UseRandomSeed,
-		NLsb:             config.NLsb,
-		DataSize:         int64(len(messageData)),
+	if config.Deniable && method.ID() != stego.BitstreamID {
+		return fmt.Errorf("%s method does not support deniable mode", method.Name())
 	}
-
-	if err := embedMP3Bitstream(config.CoverAudio, messageData, metadata, config.StegoKey, config.UseRandomSeed, config.NLsb, config.OutputPath); err != nil {
-		return fmt.Errorf("failed to embed data in MP3 bitstream: %w", err)
+	if config.Matching && method.ID() != stego.BitstreamID {
+		return fmt.Errorf("%s method does not support LSB matching", method.Name())
+	}
+	if config.MatrixEmbedding && method.ID() != stego.BitstreamID {
+		return fmt.Errorf("%s method does not support matrix embedding", method.Name())
+	}
+	if config.Resync && method.ID() != stego.BitstreamID {
+		return fmt.Errorf("%s method does not support resync blocks", method.Name())
+	}
+	if config.Adaptive && method.ID() != stego.LSBID && method.ID() != stego.LSBRobustID {
+		return fmt.Errorf("%s method does not support adaptive embedding", method.Name())
+	}
+	if config.VariableLsb && method.ID() != stego.LSBID {
+		return fmt.Errorf("%s method does not support variable nLsb", method.Name())
 	}
 
-	fmt.Printf("Successfully embedded %d bytes using MP3 bitstream steganography\n", len(messageData))
-	return nil
-}
-
-func embedMP3Bitstream(coverPath string, messageData []byte, metadata *FileMetadata, stegoKey string, useRandomSeed bool, nLsb int, outputPath string) error {
-	mp3Data, err := os.ReadFile(coverPath)
+	layout, err := stego.ParseChannelLayout(config.ChannelLayout)
 	if err != nil {
-		return fmt.Errorf("failed to read MP3 file: %w", err)
+		return err
 	}
 
-	paramHeader := createParameterHeader(nLsb, useRandomSeed, stegoKey)
-
-	embeddablePositions := findEmbeddablePositions(mp3Data)
-	if len(embeddablePositions) < 64 {
-		return fmt.Errorf("not enough embeddable positions for parameter header")
+	covers := []string{config.CoverAudio}
+	if len(config.CoverAudios) > 0 {
+		covers, err = utils.ExpandAudioPaths(config.CoverAudios)
+		if err != nil {
+			return err
+		}
 	}
 
-	modifiedMP3Data := make([]byte, len(mp3Data))
-	copy(modifiedMP3Data, mp3Data)
-
-	if err := embedParameterHeader(modifiedMP3Data, embeddablePositions[:64], paramHeader); err != nil {
-		return fmt.Errorf("failed to embed parameter header: %w", err)
+	payload, messageSize, err := readPayload(config.SecretMessage, config.StegoKey, config)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Total data to embed (metadata + message): %d bytes\n", len(payload))
 
-	metadataBytes := serializeMetadata(metadata)
-
-	dataToEmbed := make([]byte, 0, 4+len(metadataBytes)+4+len(messageData))
-
-	metadataLenBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(metadataLenBytes, uint32(len(metadataBytes)))
-	dataToEmbed = append(dataToEmbed, metadataLenBytes...)
+	payloads := [][]byte{payload}
+	keys := []string{config.StegoKey}
+	for _, r := range config.Recipients {
+		if err := utils.ValidateStegoKey(r.StegoKey); err != nil {
+			return fmt.Errorf("invalid stego key: %w", err)
+		}
+		p, size, err := readPayload(r.SecretMessage, r.StegoKey, config)
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, p)
+		keys = append(keys, r.StegoKey)
+		messageSize += size
+	}
+	if len(payloads) > 1 && (len(covers) > 1 || config.Threshold > 0) {
+		return fmt.Errorf("several recipients cannot be combined with several covers")
+	}
 
-	dataToEmbed = append(dataToEmbed, metadataBytes...)
+	params := &stego.Params{
+		StegoKey:        config.StegoKey,
+		NLsb:            config.NLsb,
+		UseRandomSeed:   config.UseRandomSeed,
+		OutputFormat:    outputFormat(config.OutputPath),
+		Layout:          layout,
+		CopyTags:        config.CopyTags,
+		Deniable:        config.Deniable,
+		NoiseFill:       config.NoiseFill,
+		Matching:        config.Matching,
+		MatrixEmbedding: config.MatrixEmbedding,
+		Resync:          config.Resync,
+		Adaptive:        config.Adaptive,
+		VariableLsb:     config.VariableLsb,
+	}
 
-	messageLenBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(messageLenBytes, uint32(len(messageData)))
-	dataToEmbed = append(dataToEmbed, messageLenBytes...)
+	if config.Threshold > 0 {
+		if err := embedShares(method, covers, payload, params, config.OutputPath, config.Threshold, config.ErasureCoding); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully embedded %d bytes using %s steganography\n", messageSize, method.Name())
+		return nil
+	}
 
-	dataToEmbed = append(dataToEmbed, messageData...)
+	if len(covers) > 1 {
+		if err := embedShards(method, covers, payload, params, config.OutputPath); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully embedded %d bytes using %s steganography\n", messageSize, method.Name())
+		return nil
+	}
 
-	fmt.Printf("Total data to embed (metadata + message): %d bytes\n", len(dataToEmbed))
+	coverData, err := os.ReadFile(covers[0])
+	if err != nil {
+		return fmt.Errorf("failed to read MP3 file: %w", err)
+	}
 
-	if err := embedDataInMP3Frames(modifiedMP3Data, embeddablePositions[64:], dataToEmbed, stegoKey, useRandomSeed, nLsb); err != nil {
-		return fmt.Errorf("failed to embed data in MP3 frames: %w", err)
+	stegoData, err := stego.EmbedMany(method, coverData, payloads, keys, params)
+	if err != nil {
+		return fmt.Errorf("failed to embed data using %s method: %w", method.Name(), err)
 	}
 
-	if err := os.WriteFile(outputPath, modifiedMP3Data, 0644); err != nil {
-		return fmt.Errorf("failed to write output MP3: %w", err)
+	if err := os.WriteFile(config.OutputPath, stegoData, 0644); err != nil {
+		return fmt.Errorf("failed to write output audio: %w", err)
 	}
 
+	fmt.Printf("Successfully embedded %d bytes using %s steganography\n", messageSize, method.Name())
 	return nil
 }
 
-func createParameterHeader(nLsb int, useRandomSeed bool, stegoKey string) []byte {
-	header := make([]byte, 8)
-
-	header[0] = 0xAB 
-	header[1] = 0xCD 
-
-	header[2] = byte(nLsb)
+// readPayload reads a secret message, encrypts it under stegoKey if asked
+// and wraps it in the container extract expects. It also returns the size
+// of the message as embedded.
+func readPayload(secretMessage, stegoKey string, config *EmbedConfig) ([]byte, int, error) {
+	messageData, err := utils.ReadFile(secretMessage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read secret message: %w", err)
+	}else if config.UseEncryption{
+		messageData = vigenere.Encrypt(messageData, stegoKey)
+	}
 
-	if useRandomSeed {
-		header[3] = 1
-	} else {
-		header[3] = 0
+	// stegoMetadata, err := metadata.CreateMetadataFromFile(
+	// 	config.SecretMessage,
+	// 	config.UseEncryption,
+	// 	config.UseRandomSeed,
+	// 	config.NLsb,
+	// )
+	fileInfo, err := os.Stat(secretMessage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	keySum := uint32(0)
-	for _, b := range []byte(stegoKey) {
-		keySum += uint32(b)
+	metadata := &FileMetadata{
+		OriginalFilename: filepath.Base(secretMessage),
+		FileExtension:    filepath.Ext(secretMessage),
+		FileSize:         fileInfo.Size(),
+		UseEncryption:    config.UseEncryption,
+		UseRandomSeed:    config.UseRandomSeed,
+		NLsb:             config.NLsb,
+		DataSize:         int64(len(messageData)),
 	}
-	binary.LittleEndian.PutUint32(header[4:8], keySum)
 
-	return header
+	return buildPayload(metadata, messageData), len(messageData), nil
 }
 
-func embedParameterHeader(mp3Data []byte, positions []int, header []byte) error {
-	if len(positions) < len(header)*8 {
-		return fmt.Errorf("not enough positions for header")
-	}
+// embedShards splits the payload across covers in the given order and
+// writes a stego file for each cover it needed into outputDir.
+func embedShards(method stego.Method, covers []string, payload []byte, params *stego.Params, outputDir string) error {
+	coverData := make([][]byte, len(covers))
+	capacities := make([]int, len(covers))
+	outputs := make(map[string]bool)
+	for i, cover := range covers {
+		name := filepath.Base(cover)
+		if outputs[name] {
+			return fmt.Errorf("two covers are named %s", name)
+		}
+		outputs[name] = true
 
-	headerBits := bytesToBits(header)
+		data, err := os.ReadFile(cover)
+		if err != nil {
+			return fmt.Errorf("failed to read MP3 file: %w", err)
+		}
+		coverData[i] = data
 
-	for i, bit := range headerBits {
-		if i >= len(positions) {
-			break
+		capacities[i], err = method.Capacity(data, params)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", cover, err)
 		}
+	}
+
+	shards, err := shard.Split(payload, capacities)
+	if err != nil {
+		return err
+	}
 
-		pos := positions[i]
-		if pos >= len(mp3Data) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for i, s := range shards {
+		if s == nil {
 			continue
 		}
-
-		mp3Data[pos] = mp3Data[pos] & 0xFE 
-		if bit {
-			mp3Data[pos] = mp3Data[pos] | 0x01 
+		output := filepath.Join(outputDir, filepath.Base(covers[i]))
+		shardParams := *params
+		shardParams.OutputFormat = outputFormat(output)
+
+		fmt.Printf("Shard %d/%d: %d bytes into %s\n", s.Index+1, s.Count, len(s.Data), covers[i])
+		stegoData, err := method.Embed(coverData[i], s.Marshal(), &shardParams)
+		if err != nil {
+			return fmt.Errorf("failed to embed shard %d using %s method: %w", s.Index+1, method.Name(), err)
+		}
+		if err := os.WriteFile(output, stegoData, 0644); err != nil {
+			return fmt.Errorf("failed to write output audio: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func embedDataInMP3Frames(mp3Data []byte, positions []int, data []byte, stegoKey string, useRandomSeed bool, nLsb int) error {
-	bits := bytesToBits(data)
-
-	// fmt.Printf("Generating Positions")
-	dataPositions, err := utils.GeneratePositions(stegoKey, useRandomSeed, len(positions), nLsb)
+// embedShares splits the payload into one threshold share per cover, any
+// threshold of which recover it, and writes a stego file for every cover
+// into outputDir. Unlike embedShards, every cover must hold a share.
+func embedShares(method stego.Method, covers []string, payload []byte, params *stego.Params, outputDir string, threshold int, erasure bool) error {
+	shares, err := shard.SplitThreshold(payload, threshold, len(covers), erasure)
 	if err != nil {
-		return fmt.Errorf("failed to generate positions: %w", err)
-	}
-	// fmt.Printf("calculating capacity")
-	capacity := len(dataPositions) * nLsb
-	if len(bits) > capacity {
-		return fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(bits), capacity)
+		return err
 	}
+	size := shard.ShareSize(len(payload), threshold, erasure)
+
+	coverData := make([][]byte, len(covers))
+	outputs := make(map[string]bool)
+	for i, cover := range covers {
+		name := filepath.Base(cover)
+		if outputs[name] {
+			return fmt.Errorf("two covers are named %s", name)
+		}
+		outputs[name] = true
 
-	fmt.Printf("Embedding %d bits into %d positions using %d LSBs (capacity %d bits)\n", len(bits), len(dataPositions), nLsb, capacity)
-	bitIndex := 0
-	for _, posIndex := range dataPositions {
-		if bitIndex >= len(bits) || posIndex >= len(positions) {
-			break
+		data, err := os.ReadFile(cover)
+		if err != nil {
+			return fmt.Errorf("failed to read MP3 file: %w", err)
 		}
+		coverData[i] = data
 
-		actualPos := positions[posIndex]
-		if actualPos >= len(mp3Data) {
-			continue
+		capacity, err := method.Capacity(data, params)
+		if err != nil {
+			return fmt.Errorf("cannot hold a share in %s: %w", cover, err)
+		}
+		if capacity < size {
+			return fmt.Errorf("data too large: a share needs %d bytes, %s holds %d", size, cover, capacity)
 		}
+	}
 
-		for i := 0; i < nLsb && bitIndex < len(bits); i++ {
-			bit := bits[bitIndex]
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
 
-			mask := ^(1 << i)
-			mp3Data[actualPos] = mp3Data[actualPos] & byte(mask)
+	for i, s := range shares {
+		output := filepath.Join(outputDir, filepath.Base(covers[i]))
+		shareParams := *params
+		shareParams.OutputFormat = outputFormat(output)
 
-			if bit {
-				mp3Data[actualPos] = mp3Data[actualPos] | (1 << i)
-			}
-			bitIndex++
+		fmt.Printf("Share %d/%d (threshold %d): %d bytes into %s\n", s.X, s.Count, s.Threshold, size, covers[i])
+		stegoData, err := method.Embed(coverData[i], s.Marshal(), &shareParams)
+		if err != nil {
+			return fmt.Errorf("failed to embed share %d using %s method: %w", s.X, method.Name(), err)
+		}
+		if err := os.WriteFile(output, stegoData, 0644); err != nil {
+			return fmt.Errorf("failed to write output audio: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func findEmbeddablePositions(mp3Data []byte) []int {
-	var positions []int
-
-
-	skipStart := 512 
-
-	frameHeaders := make(map[int]bool)
-	for i := 0; i < len(mp3Data)-1; i++ {
-		if mp3Data[i] == 0xFF && (mp3Data[i+1]&0xE0) == 0xE0 {
-			for j := 0; j < 4 && i+j < len(mp3Data); j++ {
-				frameHeaders[i+j] = true
-			}
-		}
+// outputFormat picks the container for methods that re-encode samples from
+// the output file extension. WAV output keeps the modified samples exactly.
+func outputFormat(outputPath string) string {
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".wav":
+		return "wav"
+	case ".mp3":
+		return "mp3"
 	}
+	return ""
+}
 
-	for i := skipStart; i < len(mp3Data); i++ {
-		if frameHeaders[i] {
-			continue
-		}
-
-		if mp3Data[i] == 0xFF && i < len(mp3Data)-1 && (mp3Data[i+1]&0xE0) == 0xE0 {
-			continue
-		}
-
-		positions = append(positions, i)
-	}
+// buildPayload lays out the container handed to the embedding method:
+// [4 bytes: metadata length] + [metadata] + [4 bytes: message length] + [message].
+func buildPayload(metadata *FileMetadata, messageData []byte) []byte {
+	metadataBytes := serializeMetadata(metadata)
 
-	if len(positions) < 10000 && len(mp3Data) > 2000 {
-		positions = []int{}
-		start := 2000 
-		step := 1 
+	payload := make([]byte, 0, 4+len(metadataBytes)+4+len(messageData))
 
-		for i := start; i < len(mp3Data); i += step {
-			if mp3Data[i] == 0xFF {
-				continue 
-			}
+	metadataLenBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(metadataLenBytes, uint32(len(metadataBytes)))
+	payload = append(payload, metadataLenBytes...)
 
-			if i > 0 && mp3Data[i-1] == 0xFF && (mp3Data[i]&0xE0) == 0xE0 {
-				continue
-			}
+	payload = append(payload, metadataBytes...)
 
-			if i < len(mp3Data)-1 && mp3Data[i+1] == 0xFF {
-				continue
-			}
+	messageLenBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(messageLenBytes, uint32(len(messageData)))
+	payload = append(payload, messageLenBytes...)
 
-			positions = append(positions, i)
-		}
-	}
+	payload = append(payload, messageData...)
 
-	return positions
+	return payload
 }
 
 func serializeMetadata(metadata *FileMetadata) []byte {
@@ -302,13 +424,3 @@ func serializeMetadata(metadata *FileMetadata) []byte {
 
 	return data
 }
-
-func bytesToBits(data []byte) []bool {
-	bits := make([]bool, len(data)*8)
-	for i, b := range data {
-		for j := 0; j < 8; j++ {
-			bits[i*8+j] = (b>>j)&1 == 1
-		}
-	}
-	return bits
-}
\ No newline at end of file
@@ -133,6 +133,74 @@ func TestEmbedValidation(t *testing.T) {
 			expectError: true,
 			errorMsg:    "failed to read MP3 file",
 		},
+		{
+			name: "unknown channel layout",
+			config: &EmbedConfig{
+				CoverAudio:    coverFile,
+				SecretMessage: secretFile,
+				StegoKey:      "testkey",
+				NLsb:          2,
+				OutputPath:    outputFile,
+				ChannelLayout: "diagonal",
+			},
+			expectError: true,
+			errorMsg:    "unknown channel layout",
+		},
+		{
+			name: "deniable mode with a sample method",
+			config: &EmbedConfig{
+				CoverAudio:    coverFile,
+				SecretMessage: secretFile,
+				StegoKey:      "testkey",
+				NLsb:          2,
+				OutputPath:    outputFile,
+				Method:        "lsb",
+				Deniable:      true,
+			},
+			expectError: true,
+			errorMsg:    "does not support deniable mode",
+		},
+		{
+			name: "LSB matching with a tag method",
+			config: &EmbedConfig{
+				CoverAudio:    coverFile,
+				SecretMessage: secretFile,
+				StegoKey:      "testkey",
+				NLsb:          2,
+				OutputPath:    outputFile,
+				Method:        "id3",
+				Matching:      true,
+			},
+			expectError: true,
+			errorMsg:    "does not support LSB matching",
+		},
+		{
+			name: "adaptive embedding with the bitstream method",
+			config: &EmbedConfig{
+				CoverAudio:    coverFile,
+				SecretMessage: secretFile,
+				StegoKey:      "testkey",
+				NLsb:          2,
+				OutputPath:    outputFile,
+				Adaptive:      true,
+			},
+			expectError: true,
+			errorMsg:    "does not support adaptive embedding",
+		},
+		{
+			name: "variable nLsb with a robust method",
+			config: &EmbedConfig{
+				CoverAudio:    coverFile,
+				SecretMessage: secretFile,
+				StegoKey:      "testkey",
+				NLsb:          2,
+				OutputPath:    outputFile,
+				Method:        "lsb-robust",
+				VariableLsb:   true,
+			},
+			expectError: true,
+			errorMsg:    "does not support variable nLsb",
+		},
 	}
 
 	for _, tt := range tests {
@@ -175,4 +243,9 @@ func TestCalculateMetadataSize(t *testing.T) {
 	err = Embed(config)
 	assert.Error(t, err)
 	assert.NotContains(t, err.Error(), "metadata")
-}
\ No newline at end of file
+}
+func TestOutputFormat(t *testing.T) {
+	assert.Equal(t, "wav", outputFormat("stego.WAV"))
+	assert.Equal(t, "mp3", outputFormat("dir/stego.mp3"))
+	assert.Equal(t, "", outputFormat("stego"))
+}
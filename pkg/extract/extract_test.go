@@ -1,10 +1,14 @@
 package extract
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
+	"audio-steganography-lsb/pkg/embed"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractConfig(t *testing.T) {
@@ -159,3 +163,159 @@ func TestExtractKeyValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestShardedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	coverDir := filepath.Join(dir, "covers")
+	stegoDir := filepath.Join(dir, "stego")
+	require.NoError(t, os.Mkdir(coverDir, 0755))
+
+	cover, err := os.ReadFile("../../test/cover-1.mp3")
+	require.NoError(t, err)
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(coverDir, name), cover, 0644))
+	}
+
+	// One cover holds about 11000 bytes at 1 LSB, so this needs two.
+	secret := make([]byte, 15000)
+	for i := range secret {
+		secret[i] = byte(i * 7)
+	}
+	secretFile := filepath.Join(dir, "secret.bin")
+	require.NoError(t, os.WriteFile(secretFile, secret, 0644))
+
+	err = embed.Embed(&embed.EmbedConfig{
+		CoverAudios:   []string{coverDir},
+		SecretMessage: secretFile,
+		StegoKey:      "shardkey",
+		NLsb:          1,
+		OutputPath:    stegoDir,
+	})
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(stegoDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	output := filepath.Join(dir, "extracted.bin")
+	err = Extract(&ExtractConfig{
+		StegoAudios: []string{filepath.Join(stegoDir, "b.mp3"), filepath.Join(stegoDir, "a.mp3")},
+		StegoKey:    "shardkey",
+		OutputPath:  output,
+	})
+	require.NoError(t, err)
+	extracted, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, secret, extracted)
+
+	err = Extract(&ExtractConfig{
+		StegoAudio: filepath.Join(stegoDir, "a.mp3"),
+		StegoKey:   "shardkey",
+		OutputPath: output,
+	})
+	assert.ErrorContains(t, err, "shard 1 of 2")
+}
+
+func TestThresholdRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	stegoDir := filepath.Join(dir, "stego")
+
+	cover, err := os.ReadFile("../../test/cover-1.mp3")
+	require.NoError(t, err)
+	var covers []string
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, cover, 0644))
+		covers = append(covers, path)
+	}
+
+	// Erasure-coded shares are half the payload, so a payload larger than
+	// one cover still fits.
+	secret := make([]byte, 15000)
+	for i := range secret {
+		secret[i] = byte(i * 7)
+	}
+	secretFile := filepath.Join(dir, "secret.bin")
+	require.NoError(t, os.WriteFile(secretFile, secret, 0644))
+
+	err = embed.Embed(&embed.EmbedConfig{
+		CoverAudios:   covers,
+		SecretMessage: secretFile,
+		StegoKey:      "sharekey",
+		NLsb:          1,
+		OutputPath:    stegoDir,
+		Threshold:     2,
+		ErasureCoding: true,
+	})
+	require.NoError(t, err)
+
+	// Any two of the three tracks are enough.
+	require.NoError(t, os.Remove(filepath.Join(stegoDir, "b.mp3")))
+	output := filepath.Join(dir, "extracted.bin")
+	err = Extract(&ExtractConfig{
+		StegoAudios: []string{stegoDir},
+		StegoKey:    "sharekey",
+		OutputPath:  output,
+	})
+	require.NoError(t, err)
+	extracted, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, secret, extracted)
+
+	// A file without a share is reported and skipped, but one share alone
+	// is not enough.
+	err = Extract(&ExtractConfig{
+		StegoAudios: []string{filepath.Join(stegoDir, "c.mp3"), covers[1]},
+		StegoKey:    "sharekey",
+		OutputPath:  output,
+	})
+	assert.ErrorContains(t, err, "need 2 of 3 shares, found 1")
+}
+
+func TestRecipientsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	stegoFile := filepath.Join(dir, "stego.mp3")
+
+	messages := map[string][]byte{
+		"alicekey": []byte("meet at noon"),
+		"bobkey":   []byte("the parcel is under the bench"),
+	}
+	files := make(map[string]string)
+	for key, message := range messages {
+		files[key] = filepath.Join(dir, key+".txt")
+		require.NoError(t, os.WriteFile(files[key], message, 0644))
+	}
+
+	err := embed.Embed(&embed.EmbedConfig{
+		CoverAudio:    "../../test/cover-1.mp3",
+		SecretMessage: files["alicekey"],
+		StegoKey:      "alicekey",
+		NLsb:          1,
+		UseRandomSeed: true,
+		UseEncryption: true,
+		OutputPath:    stegoFile,
+		Recipients:    []embed.Recipient{{SecretMessage: files["bobkey"], StegoKey: "bobkey"}},
+	})
+	require.NoError(t, err)
+
+	for key, message := range messages {
+		output := filepath.Join(dir, key+".out")
+		err := Extract(&ExtractConfig{
+			StegoAudio:    stegoFile,
+			StegoKey:      key,
+			OutputPath:    output,
+			UseDecryption: true,
+		})
+		require.NoError(t, err)
+		extracted, err := os.ReadFile(output)
+		require.NoError(t, err)
+		assert.Equal(t, message, extracted)
+	}
+
+	err = Extract(&ExtractConfig{
+		StegoAudio: stegoFile,
+		StegoKey:   "evekey",
+		OutputPath: filepath.Join(dir, "eve.out"),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+// Package fec implements forward error correction for methods whose bits
+// must survive lossy re-encoding: a rate-1/2 convolutional code with
+// constraint length 7 (generators 171 and 133 octal, as used by Voyager and
+// 802.11) decoded by a soft-decision Viterbi decoder, and a block
+// interleaver that spreads bursts of errors over many code words.
+package fec
+
+import "math"
+
+const (
+	constraint = 7
+	states     = 1 << (constraint - 1)
+	generator0 = 0o171
+	generator1 = 0o133
+
+	// Tail is the number of zero bits Encode appends to bring the encoder
+	// back to state zero, which lets Decode end the path there.
+	Tail = constraint - 1
+)
+
+// EncodedLen returns the number of code bits Encode produces for n bits.
+func EncodedLen(n int) int {
+	return 2 * (n + Tail)
+}
+
+// Encode returns the two code bits of every input bit, followed by those of
+// Tail zero bits.
+func Encode(bits []bool) []bool {
+	out := make([]bool, 0, EncodedLen(len(bits)))
+	reg := 0
+	for i := 0; i < len(bits)+Tail; i++ {
+		in := 0
+		if i < len(bits) && bits[i] {
+			in = 1
+		}
+		reg = (reg<<1 | in) & (1<<constraint - 1)
+		a, b := outputs(reg)
+		out = append(out, a, b)
+	}
+	return out
+}
+
+// outputs returns the code bits for a register holding the newest bit in
+// its lowest position.
+func outputs(reg int) (bool, bool) {
+	return parity(reg&generator0) == 1, parity(reg&generator1) == 1
+}
+
+func parity(x int) int {
+	p := 0
+	for ; x != 0; x &= x - 1 {
+		p ^= 1
+	}
+	return p
+}
+
+// Decode returns the n most likely input bits given soft decisions for
+// EncodedLen(n) code bits. A positive value favours 1 and a negative one 0;
+// the magnitude is the confidence, and 0 means the bit was lost. Missing
+// code bits at the end count as lost.
+func Decode(soft []float64, n int) []bool {
+	steps := n + Tail
+	code := func(i int) float64 {
+		if i < len(soft) {
+			return soft[i]
+		}
+		return 0
+	}
+
+	metric := make([]float64, states)
+	for s := 1; s < states; s++ {
+		metric[s] = math.Inf(-1)
+	}
+	next := make([]float64, states)
+	// from[t][s] records the bit that left state s at step t; the previous
+	// state is s>>1 with it restored as the top bit.
+	from := make([][states]byte, steps)
+
+	for t := 0; t < steps; t++ {
+		s0, s1 := code(2*t), code(2*t+1)
+		for s := range next {
+			next[s] = math.Inf(-1)
+		}
+		for s := 0; s < states; s++ {
+			if math.IsInf(metric[s], -1) {
+				continue
+			}
+			for in := 0; in < 2; in++ {
+				if t >= n && in == 1 {
+					continue
+				}
+				reg := s<<1 | in
+				a, b := outputs(reg)
+				m := metric[s] + signed(a)*s0 + signed(b)*s1
+				ns := reg & (states - 1)
+				if m > next[ns] {
+					next[ns] = m
+					from[t][ns] = byte(s >> (constraint - 2))
+				}
+			}
+		}
+		metric, next = next, metric
+	}
+
+	bits := make([]bool, steps)
+	s := 0
+	for t := steps - 1; t >= 0; t-- {
+		bits[t] = s&1 == 1
+		s = s>>1 | int(from[t][s])<<(constraint-2)
+	}
+	return bits[:n]
+}
+
+func signed(bit bool) float64 {
+	if bit {
+		return 1
+	}
+	return -1
+}
+
+// Interleave writes x row by row into depth rows and reads it out column
+// by column, so that neighbours in x end up about len(x)/depth apart.
+func Interleave[T any](x []T, depth int) []T {
+	out := make([]T, 0, len(x))
+	for _, i := range interleaveOrder(len(x), depth) {
+		out = append(out, x[i])
+	}
+	return out
+}
+
+// Deinterleave undoes Interleave.
+func Deinterleave[T any](x []T, depth int) []T {
+	out := make([]T, len(x))
+	for j, i := range interleaveOrder(len(x), depth) {
+		out[i] = x[j]
+	}
+	return out
+}
+
+func interleaveOrder(n, depth int) []int {
+	if depth < 1 {
+		depth = 1
+	}
+	cols := (n + depth - 1) / depth
+	order := make([]int, 0, n)
+	for c := 0; c < cols; c++ {
+		for r := 0; r < depth; r++ {
+			if i := r*cols + c; i < n {
+				order = append(order, i)
+			}
+		}
+	}
+	return order
+}
@@ -0,0 +1,77 @@
+package fec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBits(n int) []bool {
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = (i*7+i/3)%5 < 2
+	}
+	return bits
+}
+
+func soft(code []bool) []float64 {
+	out := make([]float64, len(code))
+	for i, bit := range code {
+		out[i] = -1
+		if bit {
+			out[i] = 1
+		}
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 16, 112, 1000} {
+		bits := testBits(n)
+		code := Encode(bits)
+		require.Len(t, code, EncodedLen(n))
+		assert.Equal(t, bits, Decode(soft(code), n), "n=%d", n)
+	}
+}
+
+func TestCorrectsErrors(t *testing.T) {
+	bits := testBits(500)
+	received := soft(Encode(bits))
+	// One flipped code bit in every twelve.
+	for i := 5; i < len(received); i += 12 {
+		received[i] = -received[i]
+	}
+	assert.Equal(t, bits, Decode(received, len(bits)))
+}
+
+func TestSoftDecisions(t *testing.T) {
+	bits := testBits(200)
+	received := soft(Encode(bits))
+	// Every fifth code bit is flipped but barely; every seventh is lost.
+	for i := range received {
+		switch {
+		case i%5 == 0:
+			received[i] *= -0.1
+		case i%7 == 0:
+			received[i] = 0
+		}
+	}
+	assert.Equal(t, bits, Decode(received, len(bits)))
+
+	// The same flips at full confidence are too many to correct.
+	for i := range received {
+		if i%5 == 0 {
+			received[i] *= 10
+		}
+	}
+	assert.NotEqual(t, bits, Decode(received, len(bits)))
+}
+
+func TestInterleave(t *testing.T) {
+	x := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	y := Interleave(x, 3)
+	assert.Equal(t, []int{0, 4, 8, 1, 5, 9, 2, 6, 3, 7}, y)
+	assert.Equal(t, x, Deinterleave(y, 3))
+	assert.Equal(t, x, Deinterleave(Interleave(x, 1), 1))
+}
@@ -1,10 +1,14 @@
 package lame
 
 import (
-	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
-	"os/exec"
+	"time"
+
+	"audio-steganography-lsb/pkg/mp3enc"
+	"audio-steganography-lsb/pkg/mp3frame"
+	"audio-steganography-lsb/pkg/qim"
 )
 
 type CodecAwareEncoder struct {
@@ -23,19 +27,35 @@ func NewCodecAwareEncoder(sampleRate, channels, bitrate int) *CodecAwareEncoder
 
 func (e *CodecAwareEncoder) EncodeWithSteganography(samples []int16, secretBits []bool, outputPath string) error {
 
-	tempWavPath := outputPath + ".stego.wav"
-	if err := e.createWavFile(samples, tempWavPath); err != nil {
-		return fmt.Errorf("failed to create temporary WAV: %w", err)
+	data, err := e.EncodeMP3(samples)
+	if err != nil {
+		return err
 	}
-	defer os.Remove(tempWavPath)
 
-	if err := e.encodeWavToMP3(tempWavPath, outputPath); err != nil {
-		return fmt.Errorf("failed to encode to MP3: %w", err)
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write MP3: %w", err)
 	}
 
 	return nil
 }
 
+// EncodeMP3 encodes interleaved samples with the built-in Layer III encoder
+// at the configured bitrate, using joint stereo for stereo input. The
+// decoded result trails the input by mp3enc.Delay samples.
+func (e *CodecAwareEncoder) EncodeMP3(samples []int16) ([]byte, error) {
+	data, err := mp3enc.Encode(samples, mp3enc.Config{
+		SampleRate: e.sampleRate,
+		Channels:   e.channels,
+		Bitrate:    e.bitrate,
+		Mode:       mp3frame.ModeJointStereo,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode to MP3: %w", err)
+	}
+
+	return data, nil
+}
+
 func (e *CodecAwareEncoder) applyCodecAwareModifications(samples []int16, secretBits []bool) []int16 {
 	modifiedSamples := make([]int16, len(samples))
 	copy(modifiedSamples, samples)
@@ -43,7 +63,7 @@ func (e *CodecAwareEncoder) applyCodecAwareModifications(samples []int16, secret
 	bitIndex := 0
 	for i := 0; i < len(modifiedSamples) && bitIndex < len(secretBits); i++ {
 		if e.isHighFrequencyBand(i, len(modifiedSamples)) {
-			modifiedSamples[i] = e.ModifySampleForCodecAwareness(modifiedSamples[i], secretBits[bitIndex])
+			modifiedSamples[i] = e.ModifySampleForCodecAwareness(modifiedSamples[i], 0, secretBits[bitIndex])
 			bitIndex++
 		}
 	}
@@ -51,69 +71,39 @@ func (e *CodecAwareEncoder) applyCodecAwareModifications(samples []int16, secret
 	return modifiedSamples
 }
 
-func (e *CodecAwareEncoder) ModifySampleForCodecAwareness(sample int16, bit bool) int16 {
-
-	quantStep := e.calculateQuantizationStep(sample)
-
-
-	quantizedSample := (sample / quantStep) * quantStep
-
-	remainder := sample - quantizedSample
-
-	if bit {
-		if remainder < quantStep/2 {
-			sample = quantizedSample + (quantStep*3)/4
-		} else {
-			sample = quantizedSample + remainder 
-		}
-	} else {
-		if remainder >= quantStep/2 {
-			sample = quantizedSample + quantStep/4
-		} else {
-			sample = quantizedSample + remainder
-		}
-	}
-
-	if sample > 32767 {
-		sample = 32767
-	} else if sample < -32768 {
-		sample = -32768
-	}
-
-	return sample
-}
-
-func (e *CodecAwareEncoder) calculateQuantizationStep(sample int16) int16 {
-	magnitude := int16(0)
-	if sample < 0 {
-		magnitude = -sample
-	} else {
-		magnitude = sample
-	}
-
-
-	var baseStep int16
+// QuantizationStep returns the QIM step for the encoder's bitrate. It
+// depends on nothing else, in particular not on the sample, so extraction
+// always quantizes with the step embedding used. Lower bitrates add more
+// coding noise and get a coarser step.
+func (e *CodecAwareEncoder) QuantizationStep() int {
 	switch {
-	case e.bitrate >= 256: 
-		baseStep = 4
-	case e.bitrate >= 192: 
-		baseStep = 6
-	case e.bitrate >= 128: 
-		baseStep = 8
+	case e.bitrate >= 256:
+		return 8
+	case e.bitrate >= 192:
+		return 12
+	case e.bitrate >= 128:
+		return 16
 	default:
-		baseStep = 12
+		return 24
 	}
+}
 
-	switch {
-	case magnitude < 2000:
-		return baseStep / 2
-	case magnitude < 8000:
-		return baseStep
-	case magnitude < 20000:
-		return baseStep * 2
-	default:
-		return baseStep * 3
-	}
+func (e *CodecAwareEncoder) quantizer() qim.Quantizer {
+	return qim.Quantizer{Step: float64(e.QuantizationStep())}
+}
+
+// ModifySampleForCodecAwareness moves sample onto the QIM lattice for bit
+// under dither, a keyed offset in [-step/2, step/2) that extraction has to
+// repeat. A result outside the 16-bit range moves a whole step back in.
+func (e *CodecAwareEncoder) ModifySampleForCodecAwareness(sample int16, dither float64, bit bool) int16 {
+	q := e.quantizer()
+	y := math.Round(q.Embed(float64(sample), dither, bit))
+	if y > 32767 {
+		y -= q.Step
+	} else if y < -32768 {
+		y += q.Step
+	}
+	return int16(y)
 }
 
 func (e *CodecAwareEncoder) isHighFrequencyBand(index, totalSamples int) bool {
@@ -122,136 +112,100 @@ func (e *CodecAwareEncoder) isHighFrequencyBand(index, totalSamples int) bool {
 	return position >= 0.3 && position <= 0.7
 }
 
-func (e *CodecAwareEncoder) createWavFile(samples []int16, outputPath string) error {
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	header := make([]byte, 44)
-	copy(header, "RIFF")
-	binary.LittleEndian.PutUint32(header[4:], uint32(36+len(samples)*2))
-	copy(header[8:], "WAVE")
-	copy(header[12:], "fmt ")
-	binary.LittleEndian.PutUint32(header[16:], 16)
-	binary.LittleEndian.PutUint16(header[20:], 1) 
-	binary.LittleEndian.PutUint16(header[22:], uint16(e.channels))
-	binary.LittleEndian.PutUint32(header[24:], uint32(e.sampleRate))
-	binary.LittleEndian.PutUint32(header[28:], uint32(e.sampleRate*e.channels*2)) 
-	binary.LittleEndian.PutUint16(header[32:], uint16(e.channels*2)) 
-	binary.LittleEndian.PutUint16(header[34:], 16) 
-	copy(header[36:], "data")
-	binary.LittleEndian.PutUint32(header[40:], uint32(len(samples)*2))
-
-	if _, err := file.Write(header); err != nil {
-		return err
-	}
-
-	for _, sample := range samples {
-		if err := binary.Write(file, binary.LittleEndian, sample); err != nil {
-			return err
+func (e *CodecAwareEncoder) ExtractSteganographyData(samples []int16, maxBits int) ([]bool, error) {
+	secretBits := make([]bool, 0, maxBits)
+	
+	bitCount := 0
+	for i := 0; i < len(samples) && bitCount < maxBits; i++ {
+		if e.isHighFrequencyBand(i, len(samples)) {
+			bit := e.ExtractBitFromSample(samples[i], 0)
+			secretBits = append(secretBits, bit)
+			bitCount++
 		}
 	}
 
-	return nil
+	return secretBits, nil
 }
 
-func (e *CodecAwareEncoder) encodeWavToMP3(wavPath, mp3Path string) error {
-	if err := e.checkLameAvailability(); err != nil {
-		return e.createSimpleMP3(wavPath, mp3Path)
-	}
-
-	cmd := exec.Command("lame",
-		"-b", "320",     
-		"-q", "0",       
-		"-m", "m",        
-		wavPath, mp3Path)
-	
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("LAME encoding failed: %w", err)
-	}
-
-	return nil
+// ExtractBitFromSample reads the bit ModifySampleForCodecAwareness wrote
+// under the same dither.
+func (e *CodecAwareEncoder) ExtractBitFromSample(sample int16, dither float64) bool {
+	return e.quantizer().Bit(float64(sample), dither)
 }
 
-func (e *CodecAwareEncoder) checkLameAvailability() error {
-	cmd := exec.Command("lame", "--version")
-	return cmd.Run()
+// SoftBitFromSample returns the soft decision for the bit in sample, from
+// -1 for a certain 0 to 1 for a certain 1; see qim.Quantizer.Soft.
+func (e *CodecAwareEncoder) SoftBitFromSample(sample int16, dither float64) float64 {
+	return e.quantizer().Soft(float64(sample), dither)
 }
 
-func (e *CodecAwareEncoder) createSimpleMP3(wavPath, mp3Path string) error {
-	wavData, err := os.ReadFile(wavPath)
+// AnalyzeMP3Structure parses an MP3 file and describes its frames and tags.
+// The embedding capacity of a file comes from stego.Capacities instead.
+func AnalyzeMP3Structure(mp3Path string) (*MP3Analysis, error) {
+	data, err := os.ReadFile(mp3Path)
 	if err != nil {
-		return fmt.Errorf("failed to read WAV data: %w", err)
-	}
-
-	if len(wavData) < 44 {
-		return fmt.Errorf("invalid WAV file")
+		return nil, fmt.Errorf("failed to read MP3 file: %w", err)
 	}
-	audioData := wavData[44:]
 
-	mp3File, err := os.Create(mp3Path)
+	stream, err := mp3frame.Parse(data)
 	if err != nil {
-		return fmt.Errorf("failed to create MP3 file: %w", err)
+		return nil, fmt.Errorf("failed to parse MP3 file: %w", err)
 	}
-	defer mp3File.Close()
 
-	mp3Header := []byte{
-		0xFF, 0xFB, 0x90, 0x00, 
-		0x00, 0x00, 0x00, 0x00, 
-	}
-	if _, err := mp3File.Write(mp3Header); err != nil {
-		return fmt.Errorf("failed to write MP3 header: %w", err)
+	frames := stream.Frames
+	if stream.Tag != nil && len(frames) > 1 {
+		frames = frames[1:]
 	}
+	first := frames[0].Header
 
-	if _, err := mp3File.Write(audioData); err != nil {
-		return fmt.Errorf("failed to write audio data: %w", err)
+	analysis := &MP3Analysis{
+		FrameCount:       len(frames),
+		SampleRate:       first.SampleRate,
+		Channels:         first.Channels(),
+		Version:          first.Version,
+		ChannelMode:      first.Mode,
+		BitrateHistogram: make(map[int]int),
+		EncoderTag:       stream.Tag,
+		ID3v2Size:        stream.ID3v2Size,
+		ID3v1Size:        stream.ID3v1Size,
+		APESize:          stream.APESize,
 	}
 
-	return nil
-}
-
-func (e *CodecAwareEncoder) ExtractSteganographyData(samples []int16, maxBits int) ([]bool, error) {
-	secretBits := make([]bool, 0, maxBits)
-	
-	bitCount := 0
-	for i := 0; i < len(samples) && bitCount < maxBits; i++ {
-		if e.isHighFrequencyBand(i, len(samples)) {
-			bit := e.ExtractBitFromSample(samples[i])
-			secretBits = append(secretBits, bit)
-			bitCount++
-		}
+	audioBytes := 0
+	samples := 0
+	for _, f := range frames {
+		analysis.BitrateHistogram[f.Header.Bitrate]++
+		audioBytes += f.Length
+		samples += f.Header.SamplesPerFrame()
 	}
+	analysis.Duration = time.Duration(samples) * time.Second / time.Duration(first.SampleRate)
 
-	return secretBits, nil
-}
-
-func (e *CodecAwareEncoder) ExtractBitFromSample(sample int16) bool {
-
-	quantStep := e.calculateQuantizationStep(sample)
-
-	quantizedSample := (sample / quantStep) * quantStep
-
-	remainder := sample - quantizedSample
-
-	return remainder >= quantStep/2
-}
+	analysis.VBR = len(analysis.BitrateHistogram) > 1 || (stream.Tag != nil && stream.Tag.Kind != "Info")
+	if analysis.VBR {
+		analysis.Bitrate = int(math.Round(float64(audioBytes) * 8 / analysis.Duration.Seconds() / 1000))
+	} else {
+		analysis.Bitrate = first.Bitrate
+	}
 
-func (e *CodecAwareEncoder) AnalyzeMP3Structure(mp3Path string) (*MP3Analysis, error) {
-	return &MP3Analysis{
-		FrameCount:    100, 
-		Bitrate:       e.bitrate,
-		SampleRate:    e.sampleRate,
-		Channels:      e.channels,
-		EmbeddingCapacity: 1000, 
-	}, nil
+	return analysis, nil
 }
 
 type MP3Analysis struct {
-	FrameCount        int
-	Bitrate           int
-	SampleRate        int
-	Channels          int
-	EmbeddingCapacity int
-}
\ No newline at end of file
+	// FrameCount counts audio frames; a Xing/Info/VBRI tag frame is not
+	// included.
+	FrameCount int
+	// Bitrate is the constant bitrate, or the average bitrate of a VBR file,
+	// in kbps.
+	Bitrate          int
+	SampleRate       int
+	Channels         int
+	Version          mp3frame.Version
+	ChannelMode      mp3frame.ChannelMode
+	VBR              bool
+	BitrateHistogram map[int]int
+	Duration         time.Duration
+	EncoderTag       *mp3frame.EncoderTag
+	ID3v2Size        int
+	ID3v1Size        int
+	APESize          int
+}
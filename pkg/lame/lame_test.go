@@ -0,0 +1,86 @@
+package lame
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeMP3Structure(t *testing.T) {
+	analysis, err := AnalyzeMP3Structure("../../test/cover-1.mp3")
+	require.NoError(t, err)
+
+	assert.Equal(t, 1185, analysis.FrameCount)
+	assert.Equal(t, 44100, analysis.SampleRate)
+	assert.Equal(t, 2, analysis.Channels)
+	assert.Equal(t, mp3frame.Version1, analysis.Version)
+	assert.False(t, analysis.VBR)
+	assert.Equal(t, 192, analysis.Bitrate)
+	assert.Equal(t, map[int]int{192: 1185}, analysis.BitrateHistogram)
+	assert.InDelta(t, 30.955, analysis.Duration.Seconds(), 0.001)
+	require.NotNil(t, analysis.EncoderTag)
+	assert.Equal(t, "Info", analysis.EncoderTag.Kind)
+	assert.Equal(t, 138, analysis.ID3v2Size)
+}
+
+func TestAnalyzeEncodedStream(t *testing.T) {
+	samples := make([]int16, 44100*2)
+	for i := range samples {
+		samples[i] = int16(i%200 - 100)
+	}
+	data, err := NewCodecAwareEncoder(44100, 2, 128).EncodeMP3(samples)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "encoded.mp3")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	analysis, err := AnalyzeMP3Structure(path)
+	require.NoError(t, err)
+	assert.Nil(t, analysis.EncoderTag)
+	assert.Equal(t, 128, analysis.Bitrate)
+	assert.Equal(t, mp3frame.ModeJointStereo, analysis.ChannelMode)
+	assert.Greater(t, analysis.Duration, time.Second)
+}
+
+func TestAnalyzeMP3StructureErrors(t *testing.T) {
+	_, err := AnalyzeMP3Structure("nonexistent.mp3")
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "not.mp3")
+	require.NoError(t, os.WriteFile(path, []byte("not an mp3 file"), 0644))
+	_, err = AnalyzeMP3Structure(path)
+	assert.Error(t, err)
+}
+
+func TestCodecAwareBitRoundTrip(t *testing.T) {
+	encoder := NewCodecAwareEncoder(44100, 2, 192)
+	// 1999 and 7999 sat just under a step change of the old
+	// magnitude-dependent quantizer, where embedding could cross it.
+	for _, sample := range []int16{0, 1, -1, 1500, -1500, 1999, 7999, -7999, 25000, -25000, 32767, -32768} {
+		for _, dither := range []float64{0, 2.5, -5.9} {
+			for _, bit := range []bool{false, true} {
+				modified := encoder.ModifySampleForCodecAwareness(sample, dither, bit)
+				assert.Equal(t, bit, encoder.ExtractBitFromSample(modified, dither), "sample %d dither %v bit %t", sample, dither, bit)
+				assert.LessOrEqual(t, math.Abs(float64(modified)-float64(sample)), float64(encoder.QuantizationStep()))
+				soft := encoder.SoftBitFromSample(modified, dither)
+				assert.Greater(t, math.Abs(soft), 0.8)
+			}
+		}
+	}
+}
+
+func TestCodecAwareStepFollowsBitrate(t *testing.T) {
+	previous := 0
+	for _, bitrate := range []int{320, 192, 128, 96} {
+		step := NewCodecAwareEncoder(44100, 2, bitrate).QuantizationStep()
+		assert.Greater(t, step, previous, "bitrate %d", bitrate)
+		previous = step
+	}
+}
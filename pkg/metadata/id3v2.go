@@ -0,0 +1,312 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	id3HeaderSize      = 10
+	id3FrameHeaderSize = 10
+
+	flagUnsynchronisation = 0x80
+	flagExtendedHeader    = 0x40
+	flagFooter            = 0x10
+
+	// MaxTagSize is the largest tag size a syncsafe size field can hold.
+	MaxTagSize = 1<<28 - 1
+	// FrameOverhead is the number of bytes a frame adds on top of its body.
+	FrameOverhead = id3FrameHeaderSize
+)
+
+// Tag is a byte-level view of the ID3v2.3 or ID3v2.4 tag at the start of a
+// file. It never re-encodes frames, so editing one frame leaves every other
+// byte of the tag as it was. github.com/bogem/id3v2, which metadata.go uses
+// for text frames, cannot do that: it writes the frames in map order with
+// their flags cleared and drops the padding, the extended header and the
+// footer.
+type Tag struct {
+	Version byte
+	Flags   byte
+	// Size is the size of the whole tag, header and footer included.
+	Size   int
+	Frames []Frame
+	// Padding is the number of zero bytes after the last frame.
+	Padding int
+
+	framesStart int
+	framesEnd   int
+}
+
+// Frame is one ID3v2 frame. Body aliases the parsed data.
+type Frame struct {
+	ID    string
+	Flags [2]byte
+	// Offset is the position of the frame header in the file.
+	Offset int
+	Body   []byte
+}
+
+// ParseTag parses the ID3v2 tag at the start of data. It returns nil and no
+// error when there is no tag.
+func ParseTag(data []byte) (*Tag, error) {
+	if len(data) < id3HeaderSize || string(data[0:3]) != "ID3" {
+		return nil, nil
+	}
+
+	t := &Tag{Version: data[3], Flags: data[5]}
+	if t.Version != 3 && t.Version != 4 {
+		return nil, fmt.Errorf("unsupported ID3v2 version: 2.%d", t.Version)
+	}
+	if t.Flags&flagUnsynchronisation != 0 {
+		return nil, fmt.Errorf("unsynchronised ID3v2 tags are not supported")
+	}
+
+	size := syncsafe(data[6:10])
+	t.Size = id3HeaderSize + size
+	if t.Flags&flagFooter != 0 {
+		t.Size += id3HeaderSize
+	}
+	if t.Size > len(data) {
+		return nil, fmt.Errorf("ID3v2 tag size %d exceeds file size", t.Size)
+	}
+
+	t.framesStart = id3HeaderSize
+	t.framesEnd = id3HeaderSize + size
+	if t.Flags&flagExtendedHeader != 0 {
+		if t.framesStart+4 > t.framesEnd {
+			return nil, fmt.Errorf("truncated extended header")
+		}
+		extended := data[t.framesStart : t.framesStart+4]
+		if t.Version == 3 {
+			// ID3v2.3 does not count the size field itself.
+			t.framesStart += 4 + int(binary.BigEndian.Uint32(extended))
+		} else {
+			t.framesStart += syncsafe(extended)
+		}
+		if t.framesStart > t.framesEnd {
+			return nil, fmt.Errorf("extended header overruns tag")
+		}
+	}
+
+	pos := t.framesStart
+	for pos+id3FrameHeaderSize <= t.framesEnd && data[pos] != 0 {
+		header := data[pos : pos+id3FrameHeaderSize]
+		if !validFrameID(header[0:4]) {
+			return nil, fmt.Errorf("invalid frame ID at offset %d", pos)
+		}
+		var bodySize int
+		if t.Version == 4 {
+			bodySize = syncsafe(header[4:8])
+		} else {
+			bodySize = int(binary.BigEndian.Uint32(header[4:8]))
+		}
+		body := pos + id3FrameHeaderSize
+		if bodySize > t.framesEnd-body {
+			return nil, fmt.Errorf("frame %s overruns tag", header[0:4])
+		}
+
+		t.Frames = append(t.Frames, Frame{
+			ID:     string(header[0:4]),
+			Flags:  [2]byte{header[8], header[9]},
+			Offset: pos,
+			Body:   data[body : body+bodySize],
+		})
+		pos = body + bodySize
+	}
+	t.Padding = t.framesEnd - pos
+
+	return t, nil
+}
+
+// ReplaceFrames returns data with its ID3v2 tag rewritten: frames for which
+// drop returns true are removed and extra frames are inserted in front of
+// the remaining ones. Everything else, padding included, is copied as is.
+// A file without a tag gets a new ID3v2.3 tag.
+func ReplaceFrames(data []byte, drop func(Frame) bool, extra ...Frame) ([]byte, error) {
+	t, err := ParseTag(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		var frames []byte
+		for _, f := range extra {
+			frames = append(frames, encodeFrame(3, f)...)
+		}
+		if len(frames) == 0 {
+			return data, nil
+		}
+		if len(frames) > MaxTagSize-id3HeaderSize {
+			return nil, fmt.Errorf("ID3v2 tag would exceed %d bytes", MaxTagSize)
+		}
+		out := append(tagHeader(3, 0, len(frames)), frames...)
+		return append(out, data...), nil
+	}
+
+	return t.rewrite(data, extra, func(f Frame) ([]byte, bool) {
+		return nil, drop == nil || !drop(f)
+	})
+}
+
+// ReplaceFrameBody returns data with the body of frame f, as returned by
+// ParseTag, replaced. The frame keeps its place and flags.
+func ReplaceFrameBody(data []byte, f Frame, body []byte) ([]byte, error) {
+	t, err := ParseTag(data)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no ID3v2 tag found")
+	}
+
+	found := false
+	out, err := t.rewrite(data, nil, func(g Frame) ([]byte, bool) {
+		if g.Offset != f.Offset {
+			return nil, true
+		}
+		found = true
+		return body, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("frame %s at offset %d not found", f.ID, f.Offset)
+	}
+	return out, nil
+}
+
+// rewrite rebuilds the tag with extra frames in front and every existing
+// frame passed through edit, which may drop it or return a new body. Frames
+// for which edit returns a nil body are copied byte for byte.
+func (t *Tag) rewrite(data []byte, extra []Frame, edit func(Frame) (body []byte, keep bool)) ([]byte, error) {
+	body := append([]byte(nil), data[id3HeaderSize:t.framesStart]...)
+	for _, f := range extra {
+		body = append(body, encodeFrame(t.Version, f)...)
+	}
+	rest := t.framesStart
+	for _, f := range t.Frames {
+		end := f.Offset + id3FrameHeaderSize + len(f.Body)
+		replacement, keep := edit(f)
+		switch {
+		case !keep:
+		case replacement == nil:
+			body = append(body, data[f.Offset:end]...)
+		default:
+			f.Body = replacement
+			body = append(body, encodeFrame(t.Version, f)...)
+		}
+		rest = end
+	}
+	body = append(body, data[rest:t.framesEnd]...)
+
+	if id3HeaderSize+len(body) > MaxTagSize {
+		return nil, fmt.Errorf("ID3v2 tag would exceed %d bytes", MaxTagSize)
+	}
+
+	out := append(tagHeader(t.Version, t.Flags, len(body)), body...)
+	if t.Flags&flagFooter != 0 {
+		footer := tagHeader(t.Version, t.Flags, len(body))
+		copy(footer, "3DI")
+		out = append(out, footer...)
+	}
+	return append(out, data[t.Size:]...), nil
+}
+
+func encodeFrame(version byte, f Frame) []byte {
+	out := make([]byte, id3FrameHeaderSize, id3FrameHeaderSize+len(f.Body))
+	copy(out, f.ID)
+	if version == 4 {
+		putSyncsafe(out[4:8], len(f.Body))
+	} else {
+		binary.BigEndian.PutUint32(out[4:8], uint32(len(f.Body)))
+	}
+	out[8], out[9] = f.Flags[0], f.Flags[1]
+	return append(out, f.Body...)
+}
+
+func tagHeader(version, flags byte, size int) []byte {
+	header := []byte{'I', 'D', '3', version, 0, flags, 0, 0, 0, 0}
+	putSyncsafe(header[6:10], size)
+	return header
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func putSyncsafe(b []byte, v int) {
+	b[0] = byte(v>>21) & 0x7F
+	b[1] = byte(v>>14) & 0x7F
+	b[2] = byte(v>>7) & 0x7F
+	b[3] = byte(v) & 0x7F
+}
+
+func validFrameID(id []byte) bool {
+	for _, c := range id {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Picture is the body of an APIC frame split into the image data and
+// everything in front of it.
+type Picture struct {
+	MIMEType string
+	// Prefix holds the text encoding, MIME type, picture type and
+	// description exactly as stored.
+	Prefix []byte
+	Data   []byte
+}
+
+// ParsePicture splits the body of an APIC frame.
+func ParsePicture(body []byte) (*Picture, error) {
+	if len(body) < 1 {
+		return nil, fmt.Errorf("empty APIC frame")
+	}
+	encoding := body[0]
+
+	mimeEnd := bytes.IndexByte(body[1:], 0)
+	if mimeEnd < 0 {
+		return nil, fmt.Errorf("APIC frame has no MIME type terminator")
+	}
+	pos := 1 + mimeEnd + 1
+	mimeType := string(body[1 : 1+mimeEnd])
+
+	// Skip the picture type, then the description, which ends in a single
+	// zero byte for ISO-8859-1 and UTF-8 and in a zero pair otherwise.
+	pos++
+	if pos > len(body) {
+		return nil, fmt.Errorf("truncated APIC frame")
+	}
+	if encoding == 1 || encoding == 2 {
+		for ; pos+1 < len(body); pos += 2 {
+			if body[pos] == 0 && body[pos+1] == 0 {
+				break
+			}
+		}
+		pos += 2
+	} else {
+		end := bytes.IndexByte(body[pos:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("APIC frame has no description terminator")
+		}
+		pos += end + 1
+	}
+	if pos > len(body) {
+		return nil, fmt.Errorf("truncated APIC frame")
+	}
+
+	return &Picture{MIMEType: mimeType, Prefix: body[:pos], Data: body[pos:]}, nil
+}
+
+// Body joins the picture back into an APIC frame body.
+func (p *Picture) Body() []byte {
+	body := make([]byte, 0, len(p.Prefix)+len(p.Data))
+	body = append(body, p.Prefix...)
+	return append(body, p.Data...)
+}
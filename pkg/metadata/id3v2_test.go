@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rawFrame(id string, body string) []byte {
+	size := len(body)
+	return append([]byte{id[0], id[1], id[2], id[3], byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), 0, 0}, body...)
+}
+
+func rawTag(padding int, frames ...[]byte) []byte {
+	var body []byte
+	for _, f := range frames {
+		body = append(body, f...)
+	}
+	body = append(body, make([]byte, padding)...)
+	return append(tagHeader(3, 0, len(body)), body...)
+}
+
+func TestParseTag(t *testing.T) {
+	audio := []byte{0xFF, 0xFB, 0x90, 0x00}
+	data := append(rawTag(20, rawFrame("TIT2", "\x00Title"), rawFrame("APIC", "picture")), audio...)
+
+	tag, err := ParseTag(data)
+	require.NoError(t, err)
+	require.NotNil(t, tag)
+	assert.Equal(t, byte(3), tag.Version)
+	assert.Equal(t, len(data)-len(audio), tag.Size)
+	assert.Equal(t, 20, tag.Padding)
+	require.Len(t, tag.Frames, 2)
+	assert.Equal(t, "TIT2", tag.Frames[0].ID)
+	assert.Equal(t, "APIC", tag.Frames[1].ID)
+	assert.Equal(t, []byte("picture"), tag.Frames[1].Body)
+	assert.Equal(t, 10+16, tag.Frames[1].Offset)
+
+	tag, err = ParseTag(audio)
+	assert.NoError(t, err)
+	assert.Nil(t, tag)
+
+	bad := append([]byte(nil), data...)
+	bad[3] = 2
+	_, err = ParseTag(bad)
+	assert.Error(t, err)
+
+	bad = append([]byte(nil), data...)
+	bad[5] = flagUnsynchronisation
+	_, err = ParseTag(bad)
+	assert.Error(t, err)
+
+	bad = append([]byte(nil), data...)
+	bad[10+7] = 0x7F
+	_, err = ParseTag(bad)
+	assert.Error(t, err)
+}
+
+func TestReplaceFrames(t *testing.T) {
+	audio := []byte{0xFF, 0xFB, 0x90, 0x00}
+	title := rawFrame("TIT2", "\x00Title")
+	data := append(rawTag(20, title, rawFrame("PRIV", "old\x00data")), audio...)
+
+	out, err := ReplaceFrames(data, func(f Frame) bool { return f.ID == "PRIV" }, Frame{ID: "PRIV", Body: []byte("new\x00data")})
+	require.NoError(t, err)
+	assert.Equal(t, append(rawTag(20, rawFrame("PRIV", "new\x00data"), title), audio...), out)
+
+	tag, err := ParseTag(out)
+	require.NoError(t, err)
+	require.Len(t, tag.Frames, 2)
+	assert.Equal(t, 20, tag.Padding)
+
+	// Files without a tag get a new ID3v2.3 tag.
+	out, err = ReplaceFrames(audio, nil, Frame{ID: "PRIV", Body: []byte("x")})
+	require.NoError(t, err)
+	assert.Equal(t, append(rawTag(0, rawFrame("PRIV", "x")), audio...), out)
+
+	out, err = ReplaceFrames(audio, nil)
+	require.NoError(t, err)
+	assert.Equal(t, audio, out)
+}
+
+func TestReplaceFramesVersion4(t *testing.T) {
+	data := append(rawTag(0, rawFrame("TIT2", "\x00Title")), 0xFF, 0xFB, 0x90, 0x00)
+	data[3] = 4
+
+	body := make([]byte, 200)
+	out, err := ReplaceFrames(data, nil, Frame{ID: "PRIV", Body: body})
+	require.NoError(t, err)
+
+	// ID3v2.4 frame sizes are syncsafe: 200 is 0x01 0x48.
+	assert.Equal(t, []byte{0, 0, 1, 0x48}, out[14:18])
+	tag, err := ParseTag(out)
+	require.NoError(t, err)
+	require.Len(t, tag.Frames, 2)
+	assert.Equal(t, body, tag.Frames[0].Body)
+}
+
+func TestReplaceFrameBody(t *testing.T) {
+	title := rawFrame("TIT2", "\x00Title")
+	data := append(rawTag(4, title, rawFrame("APIC", "old"), rawFrame("TALB", "\x00Album")), 0xFF, 0xFB)
+
+	tag, err := ParseTag(data)
+	require.NoError(t, err)
+	out, err := ReplaceFrameBody(data, tag.Frames[1], []byte("new picture"))
+	require.NoError(t, err)
+	assert.Equal(t, append(rawTag(4, title, rawFrame("APIC", "new picture"), rawFrame("TALB", "\x00Album")), 0xFF, 0xFB), out)
+
+	_, err = ReplaceFrameBody(data, Frame{ID: "APIC", Offset: 3}, nil)
+	assert.Error(t, err)
+}
+
+func TestParsePicture(t *testing.T) {
+	picture, err := ParsePicture([]byte("\x00image/png\x00\x03Cover\x00\x89PNG"))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", picture.MIMEType)
+	assert.Equal(t, []byte("\x89PNG"), picture.Data)
+	assert.Equal(t, []byte("\x00image/png\x00\x03Cover\x00\x89PNG"), picture.Body())
+
+	// UTF-16 descriptions end in a zero pair.
+	picture, err = ParsePicture([]byte("\x01image/jpeg\x00\x03\xFF\xFEC\x00\x00\x00\xFF\xD8"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xFF, 0xD8}, picture.Data)
+
+	_, err = ParsePicture([]byte("\x00image/png"))
+	assert.Error(t, err)
+	_, err = ParsePicture(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package mp3enc
+
+import "math"
+
+var (
+	// analysisMatrix[k][i] = cos((2k+1)(i-16)π/64), the matrixing step of
+	// the polyphase analysis filterbank.
+	analysisMatrix [32][64]float64
+	// mdctCos[k][i] = cos(π/72 (2i+1+18)(2k+1)), matching the decoder's
+	// 36-point IMDCT.
+	mdctCos [18][36]float64
+	// mdctWindow is the sine window of a normal (block type 0) block.
+	mdctWindow [36]float64
+
+	aliasCs, aliasCa [8]float64
+)
+
+func init() {
+	for k := 0; k < 32; k++ {
+		for i := 0; i < 64; i++ {
+			analysisMatrix[k][i] = math.Cos(float64((2*k+1)*(i-16)) * math.Pi / 64)
+		}
+	}
+	for k := 0; k < 18; k++ {
+		for i := 0; i < 36; i++ {
+			mdctCos[k][i] = math.Cos(math.Pi / 72 * float64((2*i+1+18)*(2*k+1)))
+		}
+	}
+	for i := range mdctWindow {
+		mdctWindow[i] = math.Sin(math.Pi / 36 * (float64(i) + 0.5))
+	}
+	for i, c := range []float64{-0.6, -0.535, -0.33, -0.185, -0.095, -0.041, -0.0142, -0.0037} {
+		sq := math.Sqrt(1 + c*c)
+		aliasCs[i] = 1 / sq
+		aliasCa[i] = c / sq
+	}
+}
+
+// filterbank holds the per-channel state of the hybrid analysis filterbank:
+// the 512-sample polyphase input buffer and the previous granule's
+// subband samples, which the MDCT overlaps with the current ones.
+type filterbank struct {
+	x    [512]float64
+	prev [32][18]float64
+}
+
+// polyphase consumes 32 input samples and produces one sample for each of
+// the 32 subbands.
+func (f *filterbank) polyphase(in []float64, out *[32]float64) {
+	copy(f.x[32:], f.x[:480])
+	for i := 0; i < 32; i++ {
+		f.x[31-i] = in[i]
+	}
+
+	var y [64]float64
+	for i := 0; i < 64; i++ {
+		sum := 0.0
+		for j := 0; j < 8; j++ {
+			sum += analysisWindow[i+64*j] * f.x[i+64*j]
+		}
+		y[i] = sum
+	}
+
+	for k := 0; k < 32; k++ {
+		sum := 0.0
+		for i := 0; i < 64; i++ {
+			sum += analysisMatrix[k][i] * y[i]
+		}
+		out[k] = sum
+	}
+}
+
+// granule turns 576 input samples into 576 MDCT coefficients using long
+// blocks only, with the decoder's frequency inversion and alias reduction
+// undone.
+func (f *filterbank) granule(in []float64, xr *[576]float64) {
+	var cur [32][18]float64
+	var sb [32]float64
+	for t := 0; t < 18; t++ {
+		f.polyphase(in[t*32:(t+1)*32], &sb)
+		for k := 0; k < 32; k++ {
+			if k&1 == 1 && t&1 == 1 {
+				cur[k][t] = -sb[k]
+			} else {
+				cur[k][t] = sb[k]
+			}
+		}
+	}
+
+	var z [36]float64
+	for band := 0; band < 32; band++ {
+		for i := 0; i < 18; i++ {
+			z[i] = mdctWindow[i] * f.prev[band][i]
+			z[i+18] = mdctWindow[i+18] * cur[band][i]
+		}
+		for k := 0; k < 18; k++ {
+			sum := 0.0
+			for i := 0; i < 36; i++ {
+				sum += z[i] * mdctCos[k][i]
+			}
+			xr[band*18+k] = sum * mdctScale
+		}
+	}
+	f.prev = cur
+
+	for band := 1; band < 32; band++ {
+		for i := 0; i < 8; i++ {
+			lo := xr[band*18-1-i]
+			hi := xr[band*18+i]
+			xr[band*18-1-i] = lo*aliasCs[i] + hi*aliasCa[i]
+			xr[band*18+i] = hi*aliasCs[i] - lo*aliasCa[i]
+		}
+	}
+}
+
+// mdctScale makes the forward MDCT the inverse of the decoder's unscaled
+// IMDCT with overlap-add.
+const mdctScale = 1.0 / 9
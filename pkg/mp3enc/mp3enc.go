@@ -0,0 +1,284 @@
+// Package mp3enc is a small MPEG-1 Layer III encoder. It uses long blocks
+// only, no scalefactors and no bit reservoir, and picks a global gain per
+// granule to fit a constant bitrate. That keeps it simple and predictable
+// rather than transparent.
+package mp3enc
+
+import (
+	"fmt"
+	"math"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+type Config struct {
+	SampleRate int
+	// Channels is the channel count of the interleaved input samples.
+	Channels int
+	// Bitrate is the constant bitrate in kbps.
+	Bitrate int
+	// Mode is the channel mode written to the stream. Mono input is always
+	// encoded as ModeSingleChannel; stereo input encoded as
+	// ModeSingleChannel is downmixed. ModeJointStereo uses mid/side stereo.
+	Mode mp3frame.ChannelMode
+	// Quantize, if set, is called for every granule and coded channel each
+	// time the quantizer tries a global gain, and may change the signed
+	// values ix it is about to code. v holds the magnitudes they were
+	// rounded from, in steps of the quantizer. Granules count from the
+	// start of the stream and are quantized in order; the last call for a
+	// granule is the one that is coded. Silent granules are not passed.
+	// Channel 1 is the side channel in joint stereo.
+	Quantize func(granule, ch int, v *[576]float64, ix *[576]int)
+}
+
+const (
+	samplesPerGranule = 576
+	samplesPerFrame   = 2 * samplesPerGranule
+
+	// Delay is the number of samples by which the decoded output trails the
+	// input: 481 for the analysis and synthesis filterbanks plus 576 for the
+	// MDCT overlap.
+	Delay = 481 + samplesPerGranule
+)
+
+// Encode encodes interleaved 16-bit samples into a CBR MP3 stream. Decoded
+// output starts with Delay samples of silence, and enough frames are
+// written that every input sample comes out.
+func Encode(samples []int16, cfg Config) ([]byte, error) {
+	if cfg.Channels != 1 && cfg.Channels != 2 {
+		return nil, fmt.Errorf("unsupported channel count: %d", cfg.Channels)
+	}
+
+	mode := cfg.Mode
+	if cfg.Channels == 1 {
+		mode = mp3frame.ModeSingleChannel
+	}
+	if mode == mp3frame.ModeDualChannel {
+		return nil, fmt.Errorf("dual channel mode is not supported")
+	}
+
+	header := mp3frame.Header{
+		Version:    mp3frame.Version1,
+		Bitrate:    cfg.Bitrate,
+		SampleRate: cfg.SampleRate,
+		Mode:       mode,
+		Original:   true,
+	}
+	if mode == mp3frame.ModeJointStereo {
+		header.ModeExtension = 2
+	}
+	if _, err := header.Marshal(); err != nil {
+		return nil, err
+	}
+
+	nch := header.Channels()
+	input := splitChannels(samples, cfg.Channels, nch)
+
+	e := &encoder{
+		header:  header,
+		nch:     nch,
+		lowpass: lowpassLine(cfg.Bitrate/nch, cfg.SampleRate),
+	}
+	for ch := 0; ch < nch; ch++ {
+		e.quantizers[ch].sfBand = mp3frame.SfBandLong(cfg.SampleRate)
+		if cfg.Quantize != nil {
+			ch := ch
+			e.quantizers[ch].hook = func(granule int, v *[576]float64, ix *[576]int) {
+				cfg.Quantize(granule, ch, v, ix)
+			}
+		}
+	}
+
+	frames := (len(input[0]) + Delay + samplesPerFrame - 1) / samplesPerFrame
+	padded := make([][]float64, nch)
+	for ch := range padded {
+		padded[ch] = make([]float64, frames*samplesPerFrame)
+		copy(padded[ch], input[ch])
+	}
+
+	var out []byte
+	remainder := 0
+	slotRemainder := 144000 * cfg.Bitrate % cfg.SampleRate
+	for f := 0; f < frames; f++ {
+		remainder += slotRemainder
+		e.header.Padding = remainder >= cfg.SampleRate
+		if e.header.Padding {
+			remainder -= cfg.SampleRate
+		}
+
+		frame, err := e.encodeFrame(padded, f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, frame...)
+	}
+
+	return out, nil
+}
+
+// splitChannels deinterleaves and normalizes the input, downmixing stereo
+// when the output is mono.
+func splitChannels(samples []int16, inChannels, outChannels int) [][]float64 {
+	frames := len(samples) / inChannels
+	out := make([][]float64, outChannels)
+	for ch := range out {
+		out[ch] = make([]float64, frames)
+	}
+
+	for i := 0; i < frames; i++ {
+		switch {
+		case inChannels == outChannels:
+			for ch := 0; ch < outChannels; ch++ {
+				out[ch][i] = float64(samples[i*inChannels+ch]) / 32768
+			}
+		case outChannels == 1:
+			out[0][i] = (float64(samples[i*2]) + float64(samples[i*2+1])) / 65536
+		}
+	}
+	return out
+}
+
+// lowpassLine returns the first MDCT line that is cut off, scaled by the
+// bitrate per channel the way most encoders do.
+func lowpassLine(bitratePerChannel, sampleRate int) int {
+	var cutoff float64
+	switch {
+	case bitratePerChannel >= 128:
+		cutoff = 19500
+	case bitratePerChannel >= 96:
+		cutoff = 17000
+	case bitratePerChannel >= 64:
+		cutoff = 14000
+	case bitratePerChannel >= 48:
+		cutoff = 11000
+	default:
+		cutoff = 8000
+	}
+	line := int(cutoff / (float64(sampleRate) / 2) * samplesPerGranule)
+	if line > samplesPerGranule {
+		line = samplesPerGranule
+	}
+	return line
+}
+
+type encoder struct {
+	header     mp3frame.Header
+	nch        int
+	lowpass    int
+	filters    [2]filterbank
+	quantizers [2]quantizer
+}
+
+func (e *encoder) encodeFrame(padded [][]float64, f int) ([]byte, error) {
+	var xr [2][2][576]float64
+	for gr := 0; gr < 2; gr++ {
+		start := f*samplesPerFrame + gr*samplesPerGranule
+		for ch := 0; ch < e.nch; ch++ {
+			e.filters[ch].granule(padded[ch][start:start+samplesPerGranule], &xr[gr][ch])
+			for i := e.lowpass; i < samplesPerGranule; i++ {
+				xr[gr][ch][i] = 0
+			}
+		}
+		if e.header.Mode == mp3frame.ModeJointStereo {
+			for i := range xr[gr][0] {
+				l, r := xr[gr][0][i], xr[gr][1][i]
+				xr[gr][0][i] = (l + r) / math.Sqrt2
+				xr[gr][1][i] = (l - r) / math.Sqrt2
+			}
+		}
+	}
+
+	frameLength := e.header.FrameLength()
+	sideInfoLength := e.header.SideInfoLength()
+	available := (frameLength - 4 - sideInfoLength) * 8
+
+	var info [2][2]granuleInfo
+	main := &bitWriter{}
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < e.nch; ch++ {
+			left := (2-gr)*e.nch - ch
+			budget := (available - main.len()) / left
+			q := &e.quantizers[ch]
+			q.granule = 2*f + gr
+			info[gr][ch] = q.quantize(&xr[gr][ch], budget)
+			writeHuffman(main, &info[gr][ch], &q.ix)
+		}
+	}
+	if main.len() > available {
+		return nil, fmt.Errorf("frame %d: main data overflow (%d > %d bits)", f, main.len(), available)
+	}
+
+	headerBytes, err := e.header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 0, frameLength)
+	frame = append(frame, headerBytes...)
+	frame = append(frame, e.sideInfo(&info).bytes()...)
+	frame = append(frame, main.bytes()...)
+	for len(frame) < frameLength {
+		frame = append(frame, 0)
+	}
+	return frame, nil
+}
+
+func (e *encoder) sideInfo(info *[2][2]granuleInfo) *bitWriter {
+	w := &bitWriter{}
+	w.write(0, 9) // main_data_begin: no bit reservoir
+	if e.nch == 1 {
+		w.write(0, 5)
+	} else {
+		w.write(0, 3)
+	}
+	w.write(0, 4*e.nch) // scfsi
+
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < e.nch; ch++ {
+			gi := &info[gr][ch]
+			w.write(uint32(gi.part23Length), 12)
+			w.write(uint32(gi.bigValues), 9)
+			w.write(uint32(gi.globalGain), 8)
+			w.write(0, 4) // scalefac_compress
+			w.write(0, 1) // window_switching_flag
+			for _, t := range gi.tableSelect {
+				w.write(uint32(t), 5)
+			}
+			w.write(uint32(gi.region0Count), 4)
+			w.write(uint32(gi.region1Count), 3)
+			w.write(0, 1) // preflag
+			w.write(0, 1) // scalefac_scale
+			w.write(uint32(gi.count1TableSelect), 1)
+		}
+	}
+	return w
+}
+
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if (v>>uint(i))&1 == 1 {
+			w.data[w.n/8] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) writeBool(b bool) {
+	if b {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+}
+
+func (w *bitWriter) len() int { return w.n }
+
+func (w *bitWriter) bytes() []byte { return w.data }
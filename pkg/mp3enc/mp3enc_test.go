@@ -0,0 +1,194 @@
+package mp3enc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tone returns one second of interleaved 16-bit audio: a 440 Hz and a
+// 3 kHz sine, at half the level on the right channel.
+func tone(sampleRate, channels int) []int16 {
+	samples := make([]int16, sampleRate*channels)
+	for i := 0; i < sampleRate; i++ {
+		t := float64(i) / float64(sampleRate)
+		v := 8000*math.Sin(2*math.Pi*440*t) + 3000*math.Sin(2*math.Pi*3000*t)
+		samples[i*channels] = int16(v)
+		if channels == 2 {
+			samples[i*channels+1] = int16(v / 2)
+		}
+	}
+	return samples
+}
+
+func decode(t *testing.T, data []byte) []int16 {
+	t.Helper()
+	decoder, err := mp3.NewDecoder(bytes.NewReader(data))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(decoder)
+	require.NoError(t, err)
+
+	samples := make([]int16, len(raw)/2)
+	for i := range samples {
+		samples[i] = int16(uint16(raw[2*i]) | uint16(raw[2*i+1])<<8)
+	}
+	return samples
+}
+
+// snr compares channel ch of the input with the decoded stereo output,
+// accounting for the encoder delay.
+func snr(input []int16, channels, ch int, decoded []int16) float64 {
+	var signal, noise float64
+	frames := len(input) / channels
+	for i := 2 * samplesPerFrame; i < frames-2*samplesPerFrame; i++ {
+		a := float64(input[i*channels+ch])
+		b := float64(decoded[(i+Delay)*2+ch])
+		signal += a * a
+		noise += (a - b) * (a - b)
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func TestEncodeDecodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		channels   int
+		bitrate    int
+		mode       mp3frame.ChannelMode
+		minSNR     float64
+	}{
+		{"44.1kHz stereo 128kbps", 44100, 2, 128, mp3frame.ModeStereo, 30},
+		{"44.1kHz joint stereo 192kbps", 44100, 2, 192, mp3frame.ModeJointStereo, 30},
+		{"48kHz joint stereo 320kbps", 48000, 2, 320, mp3frame.ModeJointStereo, 40},
+		{"32kHz mono 32kbps", 32000, 1, 32, mp3frame.ModeStereo, 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tone(tt.sampleRate, tt.channels)
+			data, err := Encode(input, Config{SampleRate: tt.sampleRate, Channels: tt.channels, Bitrate: tt.bitrate, Mode: tt.mode})
+			require.NoError(t, err)
+
+			stream, err := mp3frame.Parse(data)
+			require.NoError(t, err)
+			assert.Zero(t, stream.SkippedBytes)
+			h := stream.Frames[0].Header
+			assert.Equal(t, tt.sampleRate, h.SampleRate)
+			assert.Equal(t, tt.bitrate, h.Bitrate)
+			assert.Equal(t, tt.channels, h.Channels())
+
+			// CBR: the average frame length matches the bitrate exactly, up
+			// to one byte of padding.
+			seconds := float64(len(stream.Frames)*samplesPerFrame) / float64(tt.sampleRate)
+			assert.InDelta(t, float64(tt.bitrate*1000)/8*seconds, float64(len(data)), float64(len(stream.Frames)))
+
+			decoder := &mp3frame.MainDataDecoder{}
+			for _, f := range stream.Frames {
+				_, err := decoder.Decode(stream, f)
+				require.NoError(t, err, "frame %d", f.Index)
+			}
+
+			decoded := decode(t, data)
+			assert.GreaterOrEqual(t, len(decoded)/2, len(input)/tt.channels+Delay)
+			for ch := 0; ch < tt.channels; ch++ {
+				assert.Greater(t, snr(input, tt.channels, ch, decoded), tt.minSNR, "channel %d", ch)
+			}
+		})
+	}
+}
+
+func TestEncodeDownmix(t *testing.T) {
+	input := tone(44100, 2)
+	data, err := Encode(input, Config{SampleRate: 44100, Channels: 2, Bitrate: 96, Mode: mp3frame.ModeSingleChannel})
+	require.NoError(t, err)
+
+	stream, err := mp3frame.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, mp3frame.ModeSingleChannel, stream.Frames[0].Header.Mode)
+
+	mono := make([]int16, len(input)/2)
+	for i := range mono {
+		mono[i] = int16((int(input[2*i]) + int(input[2*i+1])) / 2)
+	}
+	assert.Greater(t, snr(mono, 1, 0, decode(t, data)), 20.0)
+}
+
+func TestEncodeSilence(t *testing.T) {
+	data, err := Encode(make([]int16, 4000), Config{SampleRate: 44100, Channels: 1, Bitrate: 64})
+	require.NoError(t, err)
+
+	for _, s := range decode(t, data) {
+		assert.Zero(t, s)
+	}
+}
+
+func TestEncodeInvalidConfig(t *testing.T) {
+	samples := tone(8000, 1)
+	for _, cfg := range []Config{
+		{SampleRate: 22050, Channels: 1, Bitrate: 64},
+		{SampleRate: 44100, Channels: 1, Bitrate: 100},
+		{SampleRate: 44100, Channels: 3, Bitrate: 128},
+		{SampleRate: 44100, Channels: 2, Bitrate: 128, Mode: mp3frame.ModeDualChannel},
+	} {
+		_, err := Encode(samples, cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
+
+func TestQuantizeHookReachesDecoder(t *testing.T) {
+	// The hook makes every index of lines 30 to 200 of the mid channel
+	// odd where i%3 == 0 and even elsewhere, as a QIM embedder would.
+	coded := map[int][576]int{}
+	data, err := Encode(tone(44100, 2), Config{
+		SampleRate: 44100, Channels: 2, Bitrate: 128, Mode: mp3frame.ModeJointStereo,
+		Quantize: func(granule, ch int, v *[576]float64, ix *[576]int) {
+			if ch != 0 {
+				return
+			}
+			for i := 30; i < 200; i++ {
+				if (abs(ix[i])%2 == 1) != (i%3 == 0) {
+					if v[i] > float64(abs(ix[i])) || ix[i] == 0 {
+						ix[i] += sign(ix[i])
+					} else {
+						ix[i] -= sign(ix[i])
+					}
+				}
+			}
+			coded[granule] = *ix
+		},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, coded)
+
+	stream, err := mp3frame.Parse(data)
+	require.NoError(t, err)
+	checked := 0
+	mp3frame.Spectra(stream, func(granule int, xr, step [][576]float64) {
+		want, ok := coded[granule]
+		if !ok {
+			return
+		}
+		checked++
+		for i := 30; i < 200; i++ {
+			q := math.Round(math.Pow(math.Abs(xr[0][i])/step[0][i], 0.75))
+			require.Equal(t, abs(want[i]), int(q), "granule %d line %d", granule, i)
+			require.Equal(t, i%3 == 0, int(q)%2 == 1)
+		}
+	})
+	assert.Equal(t, len(coded), checked)
+}
+
+func sign(v int) int {
+	if v < 0 {
+		return -1
+	}
+	return 1
+}
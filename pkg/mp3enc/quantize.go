@@ -0,0 +1,312 @@
+package mp3enc
+
+import (
+	"math"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+// maxQuantized is the largest magnitude a big_values table with 13 linbits
+// can code.
+const maxQuantized = 15 + 1<<13 - 1
+
+// maxPart23 is the largest value part2_3_length can hold.
+const maxPart23 = 1<<12 - 1
+
+// granuleInfo is the side information of one granule of one channel.
+// Scalefactors are not used, so scalefac_compress, preflag and
+// scalefac_scale are always zero.
+type granuleInfo struct {
+	part23Length      int
+	bigValues         int
+	globalGain        int
+	tableSelect       [3]int
+	region0Count      int
+	region1Count      int
+	count1TableSelect int
+	count1End         int
+	region1, region2  int
+}
+
+// subdivision picks region0_count and region1_count from the number of
+// scalefactor bands covered by big_values, as in the ISO reference encoder.
+var subdivision = [23][2]int{
+	{0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 1}, {1, 1}, {1, 1},
+	{1, 2}, {2, 2}, {2, 3}, {2, 3}, {3, 4}, {3, 4}, {3, 4}, {4, 5},
+	{4, 5}, {4, 6}, {5, 6}, {5, 6}, {5, 7}, {6, 7}, {6, 7},
+}
+
+// pairTables groups the big_values tables without linbits by the largest
+// magnitude they code; within a group the cheapest table is chosen.
+var pairTables = [][]int{{1}, {2, 3}, {5, 6}, {7, 8, 9}, {10, 11, 12}, {13, 15}}
+
+// quantizer finds a global gain for one granule that fits a bit budget.
+type quantizer struct {
+	sfBand [23]int
+	// xr34 holds |xr|^(3/4), computed once per granule.
+	xr34 [576]float64
+	sign [576]bool
+	ix   [576]int
+
+	// hook is Config.Quantize for this channel, called with v, the
+	// unrounded magnitudes, and granule, the granule being quantized.
+	hook    func(granule int, v *[576]float64, ix *[576]int)
+	granule int
+	v       [576]float64
+}
+
+// quantize returns the side info and quantized values for xr using at most
+// budget bits, searching for the smallest global gain that fits.
+func (q *quantizer) quantize(xr *[576]float64, budget int) granuleInfo {
+	if budget > maxPart23 {
+		budget = maxPart23
+	}
+
+	silent := true
+	for i, v := range xr {
+		q.sign[i] = v < 0
+		q.xr34[i] = math.Pow(math.Abs(v), 0.75)
+		if q.xr34[i] > 0 {
+			silent = false
+		}
+	}
+	if silent {
+		q.ix = [576]int{}
+		return granuleInfo{globalGain: 210}
+	}
+
+	lo, hi := 0, 255
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, ok := q.tryGain(mid, budget); ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	// Bit counts are not strictly monotonic in the gain, so step up until
+	// the result really fits.
+	for gain := lo; gain < 255; gain++ {
+		if info, ok := q.tryGain(gain, budget); ok {
+			return info
+		}
+	}
+	info, _ := q.tryGain(255, budget)
+	return info
+}
+
+func (q *quantizer) tryGain(gain, budget int) (granuleInfo, bool) {
+	// ix = nint((|xr| / 2^((gain-210)/4))^(3/4) - 0.0946)
+	scale := math.Pow(2, -0.1875*float64(gain-210))
+	for i, v := range q.xr34 {
+		q.v[i] = v * scale
+		x := q.v[i] + 0.4054
+		if x > maxQuantized {
+			return granuleInfo{}, false
+		}
+		q.ix[i] = int(x)
+		if q.sign[i] {
+			q.ix[i] = -q.ix[i]
+		}
+	}
+	if q.hook != nil {
+		q.hook(q.granule, &q.v, &q.ix)
+		for _, v := range q.ix {
+			if abs(v) > maxQuantized {
+				return granuleInfo{}, false
+			}
+		}
+	}
+
+	info := q.layout()
+	info.globalGain = gain
+	return info, info.part23Length <= budget
+}
+
+// layout splits the quantized values into the big_values, count1 and zero
+// regions, picks the Huffman tables and counts the bits.
+func (q *quantizer) layout() granuleInfo {
+	var info granuleInfo
+
+	i := 576
+	for i > 1 && q.ix[i-1] == 0 && q.ix[i-2] == 0 {
+		i -= 2
+	}
+	info.count1End = i
+	for i > 3 && abs(q.ix[i-1]) <= 1 && abs(q.ix[i-2]) <= 1 && abs(q.ix[i-3]) <= 1 && abs(q.ix[i-4]) <= 1 {
+		i -= 4
+	}
+	info.bigValues = i / 2
+
+	bits := 0
+	bitsA, bitsB := 0, 0
+	for j := info.bigValues * 2; j < info.count1End; j += 4 {
+		vwxy, signs := quad(q.ix[j:])
+		_, lenA := mp3frame.HuffmanQuadCode(32, vwxy)
+		_, lenB := mp3frame.HuffmanQuadCode(33, vwxy)
+		bitsA += lenA + signs
+		bitsB += lenB + signs
+	}
+	if bitsB < bitsA {
+		info.count1TableSelect = 1
+		bits += bitsB
+	} else {
+		bits += bitsA
+	}
+
+	end := info.bigValues * 2
+	sfb := 0
+	for sfb < 21 && q.sfBand[sfb+1] < end {
+		sfb++
+	}
+	info.region0Count = subdivision[sfb][0]
+	for info.region0Count > 0 && q.sfBand[info.region0Count+1] > end {
+		info.region0Count--
+	}
+	info.region1Count = subdivision[sfb][1]
+	for info.region1Count > 0 && q.sfBand[info.region0Count+info.region1Count+2] > end {
+		info.region1Count--
+	}
+	info.region1 = min(q.sfBand[info.region0Count+1], end)
+	info.region2 = min(q.sfBand[info.region0Count+info.region1Count+2], end)
+
+	bounds := [4]int{0, info.region1, info.region2, end}
+	for r := 0; r < 3; r++ {
+		table, n := chooseTable(q.ix[bounds[r]:bounds[r+1]])
+		info.tableSelect[r] = table
+		bits += n
+	}
+
+	info.part23Length = bits
+	return info
+}
+
+// chooseTable returns the cheapest big_values table for a region and the
+// number of bits it needs.
+func chooseTable(ix []int) (int, int) {
+	max := 0
+	for _, v := range ix {
+		if abs(v) > max {
+			max = abs(v)
+		}
+	}
+	if max == 0 {
+		return 0, 0
+	}
+
+	var candidates []int
+	if max <= 15 {
+		for _, group := range pairTables {
+			if mp3frame.HuffmanMax(group[0]) >= max {
+				candidates = group
+				break
+			}
+		}
+	} else {
+		for _, first := range []int{16, 24} {
+			for t := first; t < first+8; t++ {
+				if max-15 < 1<<mp3frame.HuffmanLinbits(t) {
+					candidates = append(candidates, t)
+					break
+				}
+			}
+		}
+	}
+
+	best, bestBits := 0, math.MaxInt
+	for _, t := range candidates {
+		if n := pairBits(t, ix); n < bestBits {
+			best, bestBits = t, n
+		}
+	}
+	return best, bestBits
+}
+
+func pairBits(table int, ix []int) int {
+	linbits := mp3frame.HuffmanLinbits(table)
+	bits := 0
+	for i := 0; i+1 < len(ix); i += 2 {
+		x, y := abs(ix[i]), abs(ix[i+1])
+		if x >= 15 && linbits > 0 {
+			bits += linbits
+			x = 15
+		}
+		if y >= 15 && linbits > 0 {
+			bits += linbits
+			y = 15
+		}
+		_, n := mp3frame.HuffmanPairCode(table, x, y)
+		bits += n
+		if x != 0 {
+			bits++
+		}
+		if y != 0 {
+			bits++
+		}
+	}
+	return bits
+}
+
+// writeHuffman writes the big_values and count1 regions of a granule.
+func writeHuffman(w *bitWriter, info *granuleInfo, ix *[576]int) {
+	bounds := [4]int{0, info.region1, info.region2, info.bigValues * 2}
+	for r := 0; r < 3; r++ {
+		table := info.tableSelect[r]
+		if table == 0 {
+			continue
+		}
+		linbits := mp3frame.HuffmanLinbits(table)
+		for i := bounds[r]; i < bounds[r+1]; i += 2 {
+			x, y := abs(ix[i]), abs(ix[i+1])
+			cx, cy := x, y
+			if linbits > 0 {
+				cx, cy = min(x, 15), min(y, 15)
+			}
+			code, n := mp3frame.HuffmanPairCode(table, cx, cy)
+			w.write(code, n)
+			writeValueTail(w, ix[i], cx, linbits)
+			writeValueTail(w, ix[i+1], cy, linbits)
+		}
+	}
+
+	for i := info.bigValues * 2; i < info.count1End; i += 4 {
+		vwxy, _ := quad(ix[i:])
+		code, n := mp3frame.HuffmanQuadCode(32+info.count1TableSelect, vwxy)
+		w.write(code, n)
+		for j := 0; j < 4; j++ {
+			if ix[i+j] != 0 {
+				w.writeBool(ix[i+j] < 0)
+			}
+		}
+	}
+}
+
+func writeValueTail(w *bitWriter, v, coded, linbits int) {
+	if linbits > 0 && coded == 15 {
+		w.write(uint32(abs(v)-15), linbits)
+	}
+	if v != 0 {
+		w.writeBool(v < 0)
+	}
+}
+
+// quad packs four count1 values into the vwxy index and counts their sign
+// bits.
+func quad(ix []int) (vwxy, signs int) {
+	for j := 0; j < 4; j++ {
+		vwxy <<= 1
+		if ix[j] != 0 {
+			vwxy |= 1
+			signs++
+		}
+	}
+	return vwxy, signs
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,359 @@
+package mp3frame
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupported = errors.New("main data decoding is only supported for MPEG-1")
+
+type huffmanCodeTable struct {
+	dim   int
+	codes []uint32
+	lens  []uint8
+}
+
+var huffmanLinbits = [34]int{
+	16: 1, 17: 2, 18: 3, 19: 4, 20: 6, 21: 8, 22: 10, 23: 13,
+	24: 4, 25: 5, 26: 6, 27: 7, 28: 8, 29: 9, 30: 11, 31: 13,
+}
+
+// huffmanTree is a binary decoding tree. Each node holds the child index for
+// a 0 and a 1 bit; leaves are stored as -(value+1).
+type huffmanTree [][2]int32
+
+var huffmanTrees [34]huffmanTree
+
+func init() {
+	for t, table := range huffmanCodes {
+		if len(table.codes) == 0 {
+			continue
+		}
+		tree := huffmanTree{{0, 0}}
+		for value, code := range table.codes {
+			node := 0
+			n := int(table.lens[value])
+			for i := n - 1; i >= 0; i-- {
+				bit := (code >> i) & 1
+				if i == 0 {
+					tree[node][bit] = int32(-(value + 1))
+					break
+				}
+				if tree[node][bit] == 0 {
+					tree = append(tree, [2]int32{0, 0})
+					tree[node][bit] = int32(len(tree) - 1)
+				}
+				node = int(tree[node][bit])
+			}
+		}
+		huffmanTrees[t] = tree
+	}
+}
+
+// codeTableFor maps a table_select value to the table that holds its codes.
+func codeTableFor(tableSelect int) int {
+	switch {
+	case tableSelect >= 24 && tableSelect < 32:
+		return 24
+	case tableSelect >= 16 && tableSelect < 24:
+		return 16
+	default:
+		return tableSelect
+	}
+}
+
+// HuffmanLinbits returns the number of linbits of a big_values table.
+func HuffmanLinbits(tableSelect int) int {
+	return huffmanLinbits[tableSelect]
+}
+
+// HuffmanMax returns the largest magnitude a big_values table codes without
+// linbits, or -1 if the table does not exist.
+func HuffmanMax(tableSelect int) int {
+	if tableSelect <= 0 || tableSelect >= 32 {
+		return -1
+	}
+	t := huffmanCodes[codeTableFor(tableSelect)]
+	if t.dim == 0 {
+		return -1
+	}
+	return t.dim - 1
+}
+
+// HuffmanPairCode returns the codeword for the magnitudes x and y in a
+// big_values table. Magnitudes of 15 and above in a linbits table are coded
+// as 15; the escape value and the sign bits are written by the caller.
+func HuffmanPairCode(tableSelect, x, y int) (code uint32, length int) {
+	t := huffmanCodes[codeTableFor(tableSelect)]
+	i := x*t.dim + y
+	return t.codes[i], int(t.lens[i])
+}
+
+// HuffmanQuadCode returns the codeword for a count1 quadruple in table 32
+// or 33. vwxy holds the four magnitudes as bits, v being the most
+// significant.
+func HuffmanQuadCode(tableSelect, vwxy int) (code uint32, length int) {
+	t := huffmanCodes[tableSelect]
+	return t.codes[vwxy], int(t.lens[vwxy])
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v <<= 1
+		byteIndex := r.pos >> 3
+		if byteIndex < len(r.data) {
+			v |= int(r.data[byteIndex]>>(7-uint(r.pos&7))) & 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) decode(table int) (int, error) {
+	tree := huffmanTrees[codeTableFor(table)]
+	if tree == nil {
+		return 0, fmt.Errorf("invalid Huffman table %d", table)
+	}
+	node := 0
+	for depth := 0; depth < 32; depth++ {
+		if r.pos >= len(r.data)*8 {
+			return 0, fmt.Errorf("Huffman code runs past main data")
+		}
+		next := tree[node][r.read(1)]
+		if next < 0 {
+			return int(-next - 1), nil
+		}
+		if next == 0 {
+			return 0, fmt.Errorf("illegal Huffman code in table %d", table)
+		}
+		node = int(next)
+	}
+	return 0, fmt.Errorf("illegal Huffman code in table %d", table)
+}
+
+var sfBandLong = map[int][23]int{
+	44100: {0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 52, 62, 74, 90, 110, 134, 162, 196, 238, 288, 342, 418, 576},
+	48000: {0, 4, 8, 12, 16, 20, 24, 30, 36, 42, 50, 60, 72, 88, 106, 128, 156, 190, 230, 276, 330, 384, 576},
+	32000: {0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 54, 66, 82, 102, 126, 156, 194, 240, 296, 364, 448, 550, 576},
+}
+
+var sfBandShort = map[int][14]int{
+	44100: {0, 4, 8, 12, 16, 22, 30, 40, 52, 66, 84, 106, 136, 192},
+	48000: {0, 4, 8, 12, 16, 22, 28, 38, 50, 64, 80, 100, 126, 192},
+	32000: {0, 4, 8, 12, 16, 22, 30, 42, 58, 78, 104, 138, 180, 192},
+}
+
+func SfBandLong(sampleRate int) [23]int {
+	return sfBandLong[sampleRate]
+}
+
+func SfBandShort(sampleRate int) [14]int {
+	return sfBandShort[sampleRate]
+}
+
+var slen = [16][2]int{
+	{0, 0}, {0, 1}, {0, 2}, {0, 3}, {3, 0}, {1, 1}, {1, 2}, {1, 3},
+	{2, 1}, {2, 2}, {2, 3}, {3, 1}, {3, 2}, {3, 3}, {4, 2}, {4, 3},
+}
+
+// Granule is the decoded content of one granule of one channel: its
+// scalefactors and its 576 quantized spectral values.
+type Granule struct {
+	ScalefacLong  [22]int
+	ScalefacShort [13][3]int
+	Part2Bits     int
+	Values        [576]int
+	// Count1Start is the index of the first value decoded from the count1
+	// region; everything from ZeroStart on is implicitly zero.
+	Count1Start int
+	ZeroStart   int
+}
+
+type FrameData struct {
+	Granules [2][2]Granule
+}
+
+// MainDataDecoder decodes the main data of consecutive frames, keeping the
+// bit reservoir that lets a frame borrow bytes from its predecessors.
+type MainDataDecoder struct {
+	reservoir []byte
+}
+
+func (d *MainDataDecoder) Decode(s *Stream, f *Frame) (*FrameData, error) {
+	frameMainData := s.Data[f.MainDataOffset() : f.Offset+f.Length]
+
+	mdb := f.SideInfo.MainDataBegin
+	available := len(d.reservoir)
+	var buffer []byte
+	if mdb <= available {
+		buffer = make([]byte, 0, mdb+len(frameMainData))
+		buffer = append(buffer, d.reservoir[available-mdb:]...)
+		buffer = append(buffer, frameMainData...)
+	}
+
+	d.reservoir = append(d.reservoir, frameMainData...)
+	if len(d.reservoir) > 4096 {
+		d.reservoir = d.reservoir[len(d.reservoir)-4096:]
+	}
+
+	if f.Header.Version != Version1 {
+		return nil, ErrUnsupported
+	}
+	if buffer == nil {
+		return nil, fmt.Errorf("main_data_begin %d exceeds the %d bytes in the bit reservoir", mdb, available)
+	}
+
+	r := &bitReader{data: buffer}
+	fd := &FrameData{}
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < f.Header.Channels(); ch++ {
+			g := &f.SideInfo.Granules[gr][ch]
+			start := r.pos
+			end := start + g.Part23Length
+			if end > len(buffer)*8 {
+				return fd, fmt.Errorf("granule %d channel %d: part2_3_length %d runs past main data", gr, ch, g.Part23Length)
+			}
+
+			out := &fd.Granules[gr][ch]
+			readScalefactors(r, f.SideInfo, gr, ch, out, &fd.Granules[0][ch])
+			out.Part2Bits = r.pos - start
+			if r.pos > end {
+				return fd, fmt.Errorf("granule %d channel %d: scalefactors overrun part2_3_length", gr, ch)
+			}
+
+			if err := readSpectrum(r, g, f.Header.SampleRate, end, out); err != nil {
+				return fd, fmt.Errorf("granule %d channel %d: %w", gr, ch, err)
+			}
+			r.pos = end
+		}
+	}
+
+	return fd, nil
+}
+
+func readScalefactors(r *bitReader, si *SideInfo, gr, ch int, out, first *Granule) {
+	g := &si.Granules[gr][ch]
+	slen1, slen2 := slen[g.ScalefacCompress][0], slen[g.ScalefacCompress][1]
+
+	if g.WindowSwitching && g.BlockType == 2 {
+		startShort := 0
+		if g.MixedBlock {
+			for sfb := 0; sfb < 8; sfb++ {
+				out.ScalefacLong[sfb] = r.read(slen1)
+			}
+			startShort = 3
+		}
+		for sfb := startShort; sfb < 12; sfb++ {
+			n := slen1
+			if sfb >= 6 {
+				n = slen2
+			}
+			for win := 0; win < 3; win++ {
+				out.ScalefacShort[sfb][win] = r.read(n)
+			}
+		}
+		return
+	}
+
+	bands := [5]int{0, 6, 11, 16, 21}
+	for group := 0; group < 4; group++ {
+		n := slen1
+		if group >= 2 {
+			n = slen2
+		}
+		for sfb := bands[group]; sfb < bands[group+1]; sfb++ {
+			if gr == 1 && si.Scfsi[ch][group] {
+				out.ScalefacLong[sfb] = first.ScalefacLong[sfb]
+			} else {
+				out.ScalefacLong[sfb] = r.read(n)
+			}
+		}
+	}
+}
+
+func readSpectrum(r *bitReader, g *GranuleInfo, sampleRate, end int, out *Granule) error {
+	if g.BigValues > 288 {
+		return fmt.Errorf("big_values %d exceeds 288", g.BigValues)
+	}
+
+	bands := sfBandLong[sampleRate]
+	region1Start, region2Start := 576, 576
+	if g.WindowSwitching && g.BlockType == 2 {
+		region1Start = 36
+	} else {
+		if i := g.Region0Count + 1; i < len(bands) {
+			region1Start = bands[i]
+		}
+		if i := g.Region0Count + g.Region1Count + 2; i < len(bands) {
+			region2Start = bands[i]
+		}
+	}
+
+	for i := 0; i < g.BigValues*2; i += 2 {
+		table := g.TableSelect[2]
+		if i < region1Start {
+			table = g.TableSelect[0]
+		} else if i < region2Start {
+			table = g.TableSelect[1]
+		}
+		if table == 0 {
+			continue
+		}
+
+		value, err := r.decode(table)
+		if err != nil {
+			return err
+		}
+		dim := huffmanCodes[codeTableFor(table)].dim
+		x, y := value/dim, value%dim
+		linbits := huffmanLinbits[table]
+		if linbits > 0 && x == 15 {
+			x += r.read(linbits)
+		}
+		if x != 0 && r.read(1) == 1 {
+			x = -x
+		}
+		if linbits > 0 && y == 15 {
+			y += r.read(linbits)
+		}
+		if y != 0 && r.read(1) == 1 {
+			y = -y
+		}
+		out.Values[i] = x
+		out.Values[i+1] = y
+		if r.pos > end {
+			return fmt.Errorf("big_values region runs past part2_3_length")
+		}
+	}
+
+	out.Count1Start = g.BigValues * 2
+	i := out.Count1Start
+	for i+4 <= 576 && r.pos < end {
+		value, err := r.decode(32 + g.Count1TableSelect)
+		if err != nil {
+			return err
+		}
+		quad := [4]int{(value >> 3) & 1, (value >> 2) & 1, (value >> 1) & 1, value & 1}
+		for k := range quad {
+			if quad[k] != 0 && r.read(1) == 1 {
+				quad[k] = -quad[k]
+			}
+		}
+		if r.pos > end {
+			// The last quadruple straddles the end of the granule; decoders
+			// discard it.
+			break
+		}
+		copy(out.Values[i:i+4], quad[:])
+		i += 4
+	}
+	out.ZeroStart = i
+
+	return nil
+}
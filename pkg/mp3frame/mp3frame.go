@@ -0,0 +1,451 @@
+package mp3frame
+
+import (
+	"fmt"
+	"os"
+)
+
+type Version int
+
+const (
+	Version2_5 Version = 0
+	Version2   Version = 2
+	Version1   Version = 3
+)
+
+func (v Version) String() string {
+	switch v {
+	case Version1:
+		return "MPEG-1"
+	case Version2:
+		return "MPEG-2"
+	case Version2_5:
+		return "MPEG-2.5"
+	default:
+		return "reserved"
+	}
+}
+
+type ChannelMode int
+
+const (
+	ModeStereo        ChannelMode = 0
+	ModeJointStereo   ChannelMode = 1
+	ModeDualChannel   ChannelMode = 2
+	ModeSingleChannel ChannelMode = 3
+)
+
+func (m ChannelMode) String() string {
+	switch m {
+	case ModeStereo:
+		return "stereo"
+	case ModeJointStereo:
+		return "joint stereo"
+	case ModeDualChannel:
+		return "dual channel"
+	default:
+		return "mono"
+	}
+}
+
+var bitratesV1L3 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+var bitratesV2L3 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+var sampleRatesV1 = [3]int{44100, 48000, 32000}
+
+// Header is a decoded 4-byte MPEG audio frame header. Only Layer III is
+// accepted, since that is the only layer this tool embeds into.
+type Header struct {
+	Version       Version
+	Protected     bool
+	BitrateIndex  int
+	Bitrate       int
+	SampleRate    int
+	Padding       bool
+	Private       bool
+	Mode          ChannelMode
+	ModeExtension int
+	Copyright     bool
+	Original      bool
+	Emphasis      int
+}
+
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < 4 {
+		return Header{}, fmt.Errorf("short frame header")
+	}
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return Header{}, fmt.Errorf("missing frame sync")
+	}
+
+	version := Version((b[1] >> 3) & 0x03)
+	if version == 1 {
+		return Header{}, fmt.Errorf("reserved MPEG version")
+	}
+	if (b[1]>>1)&0x03 != 1 {
+		return Header{}, fmt.Errorf("not a Layer III frame")
+	}
+
+	bitrateIndex := int(b[2] >> 4)
+	if bitrateIndex == 0 || bitrateIndex == 15 {
+		return Header{}, fmt.Errorf("unsupported bitrate index: %d", bitrateIndex)
+	}
+	sampleRateIndex := int((b[2] >> 2) & 0x03)
+	if sampleRateIndex == 3 {
+		return Header{}, fmt.Errorf("reserved sample rate index")
+	}
+
+	h := Header{
+		Version:       version,
+		Protected:     b[1]&0x01 == 0,
+		BitrateIndex:  bitrateIndex,
+		Padding:       (b[2]>>1)&0x01 == 1,
+		Private:       b[2]&0x01 == 1,
+		Mode:          ChannelMode(b[3] >> 6),
+		ModeExtension: int((b[3] >> 4) & 0x03),
+		Copyright:     (b[3]>>3)&0x01 == 1,
+		Original:      (b[3]>>2)&0x01 == 1,
+		Emphasis:      int(b[3] & 0x03),
+	}
+
+	h.SampleRate = sampleRatesV1[sampleRateIndex]
+	h.Bitrate = bitratesV1L3[bitrateIndex]
+	switch version {
+	case Version2:
+		h.SampleRate /= 2
+		h.Bitrate = bitratesV2L3[bitrateIndex]
+	case Version2_5:
+		h.SampleRate /= 4
+		h.Bitrate = bitratesV2L3[bitrateIndex]
+	}
+
+	return h, nil
+}
+
+// Marshal encodes the header. The bitrate and sample rate indexes are looked
+// up from Bitrate and SampleRate; BitrateIndex is ignored.
+func (h Header) Marshal() ([]byte, error) {
+	bitrates := bitratesV1L3
+	divisor := 1
+	switch h.Version {
+	case Version1:
+	case Version2:
+		bitrates, divisor = bitratesV2L3, 2
+	case Version2_5:
+		bitrates, divisor = bitratesV2L3, 4
+	default:
+		return nil, fmt.Errorf("reserved MPEG version")
+	}
+
+	bitrateIndex := -1
+	for i := 1; i < 15; i++ {
+		if bitrates[i] == h.Bitrate {
+			bitrateIndex = i
+		}
+	}
+	if bitrateIndex < 0 {
+		return nil, fmt.Errorf("unsupported bitrate for %s: %d kbps", h.Version, h.Bitrate)
+	}
+
+	sampleRateIndex := -1
+	for i, rate := range sampleRatesV1 {
+		if rate/divisor == h.SampleRate {
+			sampleRateIndex = i
+		}
+	}
+	if sampleRateIndex < 0 {
+		return nil, fmt.Errorf("unsupported sample rate for %s: %d Hz", h.Version, h.SampleRate)
+	}
+
+	b := []byte{0xFF, 0xE0 | byte(h.Version)<<3 | 1<<1, byte(bitrateIndex<<4 | sampleRateIndex<<2), 0}
+	if !h.Protected {
+		b[1] |= 0x01
+	}
+	if h.Padding {
+		b[2] |= 0x02
+	}
+	if h.Private {
+		b[2] |= 0x01
+	}
+	b[3] = byte(h.Mode)<<6 | byte(h.ModeExtension&0x03)<<4 | byte(h.Emphasis&0x03)
+	if h.Copyright {
+		b[3] |= 0x08
+	}
+	if h.Original {
+		b[3] |= 0x04
+	}
+	return b, nil
+}
+
+func (h Header) Channels() int {
+	if h.Mode == ModeSingleChannel {
+		return 1
+	}
+	return 2
+}
+
+func (h Header) Granules() int {
+	if h.Version == Version1 {
+		return 2
+	}
+	return 1
+}
+
+func (h Header) SamplesPerFrame() int {
+	return h.Granules() * 576
+}
+
+func (h Header) FrameLength() int {
+	padding := 0
+	if h.Padding {
+		padding = 1
+	}
+	return h.SamplesPerFrame()/8*h.Bitrate*1000/h.SampleRate + padding
+}
+
+func (h Header) SideInfoLength() int {
+	if h.Version == Version1 {
+		if h.Channels() == 1 {
+			return 17
+		}
+		return 32
+	}
+	if h.Channels() == 1 {
+		return 9
+	}
+	return 17
+}
+
+// DataOffset is the offset of the side information from the frame start,
+// past the header and the optional CRC.
+func (h Header) DataOffset() int {
+	if h.Protected {
+		return 6
+	}
+	return 4
+}
+
+// Compatible reports whether two headers can belong to the same stream.
+// Bitrate and padding may change between frames, the rest may not.
+func (h Header) Compatible(other Header) bool {
+	return h.Version == other.Version && h.SampleRate == other.SampleRate && h.Channels() == other.Channels()
+}
+
+type GranuleInfo struct {
+	Part23Length      int
+	BigValues         int
+	GlobalGain        int
+	ScalefacCompress  int
+	WindowSwitching   bool
+	BlockType         int
+	MixedBlock        bool
+	TableSelect       [3]int
+	SubblockGain      [3]int
+	Region0Count      int
+	Region1Count      int
+	Preflag           bool
+	ScalefacScale     bool
+	Count1TableSelect int
+}
+
+type SideInfo struct {
+	MainDataBegin int
+	PrivateBits   int
+	Scfsi         [2][4]bool
+	Granules      [2][2]GranuleInfo
+}
+
+func ParseSideInfo(h Header, b []byte) (*SideInfo, error) {
+	if len(b) < h.SideInfoLength() {
+		return nil, fmt.Errorf("short side info: %d bytes", len(b))
+	}
+
+	r := &bitReader{data: b[:h.SideInfoLength()]}
+	si := &SideInfo{}
+	channels := h.Channels()
+
+	if h.Version == Version1 {
+		si.MainDataBegin = r.read(9)
+		if channels == 1 {
+			si.PrivateBits = r.read(5)
+		} else {
+			si.PrivateBits = r.read(3)
+		}
+		for ch := 0; ch < channels; ch++ {
+			for band := 0; band < 4; band++ {
+				si.Scfsi[ch][band] = r.read(1) == 1
+			}
+		}
+	} else {
+		si.MainDataBegin = r.read(8)
+		si.PrivateBits = r.read(channels)
+	}
+
+	for gr := 0; gr < h.Granules(); gr++ {
+		for ch := 0; ch < channels; ch++ {
+			g := &si.Granules[gr][ch]
+			g.Part23Length = r.read(12)
+			g.BigValues = r.read(9)
+			g.GlobalGain = r.read(8)
+			if h.Version == Version1 {
+				g.ScalefacCompress = r.read(4)
+			} else {
+				g.ScalefacCompress = r.read(9)
+			}
+			g.WindowSwitching = r.read(1) == 1
+			if g.WindowSwitching {
+				g.BlockType = r.read(2)
+				g.MixedBlock = r.read(1) == 1
+				for i := 0; i < 2; i++ {
+					g.TableSelect[i] = r.read(5)
+				}
+				for i := 0; i < 3; i++ {
+					g.SubblockGain[i] = r.read(3)
+				}
+				if g.BlockType == 2 && !g.MixedBlock {
+					g.Region0Count = 8
+				} else {
+					g.Region0Count = 7
+				}
+				g.Region1Count = 20 - g.Region0Count
+			} else {
+				for i := 0; i < 3; i++ {
+					g.TableSelect[i] = r.read(5)
+				}
+				g.Region0Count = r.read(4)
+				g.Region1Count = r.read(3)
+			}
+			if h.Version == Version1 {
+				g.Preflag = r.read(1) == 1
+			}
+			g.ScalefacScale = r.read(1) == 1
+			g.Count1TableSelect = r.read(1)
+		}
+	}
+
+	return si, nil
+}
+
+// Frame is one Layer III frame located in a file. The bytes after its side
+// information hold main data, but its granules may start in earlier frames
+// through the bit reservoir; MainDataDecoder resolves that.
+type Frame struct {
+	Index    int
+	Offset   int
+	Length   int
+	Header   Header
+	SideInfo *SideInfo
+}
+
+func (f *Frame) SideInfoOffset() int {
+	return f.Offset + f.Header.DataOffset()
+}
+
+func (f *Frame) MainDataOffset() int {
+	return f.SideInfoOffset() + f.Header.SideInfoLength()
+}
+
+type Stream struct {
+	Data      []byte
+	Frames    []*Frame
+	ID3v2Size int
+	ID3v1Size int
+	// APESize is the size of an APEv1/v2 tag at the end of the file,
+	// before any ID3v1 tag.
+	APESize    int
+	AudioStart int
+	AudioEnd   int
+	// Tag is the Xing/Info or VBRI header found in the first frame, if any.
+	// That frame carries no audio.
+	Tag *EncoderTag
+	// SkippedBytes counts bytes between frames that had to be skipped to
+	// regain sync, which is itself a sign of a damaged stream.
+	SkippedBytes int
+}
+
+func ParseFile(path string) (*Stream, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MP3 file: %w", err)
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Stream, error) {
+	s := &Stream{Data: data}
+	s.ID3v2Size = id3v2Size(data)
+	s.AudioStart = s.ID3v2Size
+	s.ID3v1Size = trailingID3v1Size(data)
+	s.APESize = apeSize(data, len(data)-s.ID3v1Size)
+	s.AudioEnd = len(data) - s.ID3v1Size - s.APESize
+	if s.AudioEnd < s.AudioStart {
+		s.AudioEnd = s.AudioStart
+	}
+
+	var first *Header
+	pos := s.AudioStart
+	for pos+4 <= s.AudioEnd {
+		h, err := ParseHeader(data[pos:])
+		if err != nil || (first != nil && !first.Compatible(h)) {
+			pos++
+			if len(s.Frames) > 0 {
+				s.SkippedBytes++
+			}
+			continue
+		}
+
+		length := h.FrameLength()
+		if pos+length > s.AudioEnd {
+			break
+		}
+
+		// A lone sync pattern is not enough to lock onto a stream; require
+		// the next header to follow where this frame says it ends.
+		if first == nil && pos+length+4 <= s.AudioEnd {
+			next, err := ParseHeader(data[pos+length:])
+			if err != nil || !h.Compatible(next) {
+				pos++
+				continue
+			}
+		}
+
+		si, err := ParseSideInfo(h, data[pos+h.DataOffset():pos+length])
+		if err != nil {
+			pos++
+			continue
+		}
+
+		if first == nil {
+			first = &h
+		}
+		s.Frames = append(s.Frames, &Frame{
+			Index:    len(s.Frames),
+			Offset:   pos,
+			Length:   length,
+			Header:   h,
+			SideInfo: si,
+		})
+		pos += length
+	}
+
+	if len(s.Frames) == 0 {
+		return nil, fmt.Errorf("no MPEG Layer III frames found")
+	}
+	s.Tag = parseEncoderTag(data, s.Frames[0])
+
+	return s, nil
+}
+
+func id3v2Size(data []byte) int {
+	if len(data) < 10 || string(data[0:3]) != "ID3" {
+		return 0
+	}
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	size += 10
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	if size > len(data) {
+		return len(data)
+	}
+	return size
+}
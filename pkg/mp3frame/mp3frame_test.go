@@ -0,0 +1,244 @@
+package mp3frame
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const coverPath = "../../test/cover-1.mp3"
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []byte
+		expectError bool
+		version     Version
+		bitrate     int
+		sampleRate  int
+		channels    int
+		length      int
+	}{
+		{
+			name:       "MPEG-1 128kbps 44.1kHz joint stereo",
+			header:     []byte{0xFF, 0xFB, 0x90, 0x40},
+			version:    Version1,
+			bitrate:    128,
+			sampleRate: 44100,
+			channels:   2,
+			length:     417,
+		},
+		{
+			name:       "MPEG-1 320kbps 48kHz mono padded",
+			header:     []byte{0xFF, 0xFB, 0xE6, 0xC0},
+			version:    Version1,
+			bitrate:    320,
+			sampleRate: 48000,
+			channels:   1,
+			length:     961,
+		},
+		{
+			name:       "MPEG-2 64kbps 22.05kHz stereo",
+			header:     []byte{0xFF, 0xF3, 0x80, 0x00},
+			version:    Version2,
+			bitrate:    64,
+			sampleRate: 22050,
+			channels:   2,
+			length:     208,
+		},
+		{
+			name:        "missing sync",
+			header:      []byte{0xFF, 0x1B, 0x90, 0x40},
+			expectError: true,
+		},
+		{
+			name:        "layer II",
+			header:      []byte{0xFF, 0xFD, 0x90, 0x40},
+			expectError: true,
+		},
+		{
+			name:        "free format bitrate",
+			header:      []byte{0xFF, 0xFB, 0x00, 0x40},
+			expectError: true,
+		},
+		{
+			name:        "reserved sample rate",
+			header:      []byte{0xFF, 0xFB, 0x9C, 0x40},
+			expectError: true,
+		},
+		{
+			name:        "short input",
+			header:      []byte{0xFF, 0xFB},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseHeader(tt.header)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.version, h.Version)
+			assert.Equal(t, tt.bitrate, h.Bitrate)
+			assert.Equal(t, tt.sampleRate, h.SampleRate)
+			assert.Equal(t, tt.channels, h.Channels())
+			assert.Equal(t, tt.length, h.FrameLength())
+
+			b, err := h.Marshal()
+			require.NoError(t, err)
+			assert.Equal(t, tt.header, b)
+		})
+	}
+}
+
+func TestMarshalHeaderErrors(t *testing.T) {
+	_, err := Header{Version: Version1, Bitrate: 144, SampleRate: 44100}.Marshal()
+	assert.Error(t, err)
+
+	_, err = Header{Version: Version1, Bitrate: 128, SampleRate: 22050}.Marshal()
+	assert.Error(t, err)
+
+	_, err = Header{Version: 1, Bitrate: 128, SampleRate: 44100}.Marshal()
+	assert.Error(t, err)
+}
+
+func TestHuffmanTablesRoundTrip(t *testing.T) {
+	for table, codes := range huffmanCodes {
+		if len(codes.codes) == 0 {
+			continue
+		}
+		for value, code := range codes.codes {
+			n := int(codes.lens[value])
+			data := make([]byte, 4)
+			for i := 0; i < n; i++ {
+				if (code>>(n-1-i))&1 == 1 {
+					data[i/8] |= 0x80 >> (i % 8)
+				}
+			}
+			r := &bitReader{data: data}
+			decoded, err := r.decode(table)
+			require.NoError(t, err, "table %d value %d", table, value)
+			assert.Equal(t, value, decoded, "table %d", table)
+			assert.Equal(t, n, r.pos, "table %d value %d", table, value)
+		}
+	}
+}
+
+func TestParseCover(t *testing.T) {
+	stream, err := ParseFile(coverPath)
+	require.NoError(t, err)
+
+	assert.Greater(t, stream.ID3v2Size, 0)
+	assert.Equal(t, stream.ID3v2Size, stream.Frames[0].Offset)
+	assert.Zero(t, stream.SkippedBytes)
+
+	h := stream.Frames[0].Header
+	assert.Equal(t, Version1, h.Version)
+	assert.Equal(t, 44100, h.SampleRate)
+
+	for i := 1; i < len(stream.Frames); i++ {
+		prev := stream.Frames[i-1]
+		assert.Equal(t, prev.Offset+prev.Length, stream.Frames[i].Offset)
+	}
+
+	require.NotNil(t, stream.Tag)
+	assert.Equal(t, "Info", stream.Tag.Kind)
+	assert.Equal(t, "Lavc60.31", stream.Tag.Encoder)
+	assert.Equal(t, len(stream.Frames)-1, stream.Tag.Frames)
+	assert.Zero(t, stream.ID3v1Size)
+	assert.Zero(t, stream.APESize)
+}
+
+func TestParseTrailingTags(t *testing.T) {
+	data, err := os.ReadFile(coverPath)
+	require.NoError(t, err)
+	clean, err := Parse(data)
+	require.NoError(t, err)
+
+	// APEv2 tag with header: header, one item and footer.
+	item := append([]byte{5, 0, 0, 0, 0, 0, 0, 0}, []byte("Title\x00hello")...)
+	ape := func(flags uint32) []byte {
+		b := make([]byte, 32)
+		copy(b, "APETAGEX")
+		binary.LittleEndian.PutUint32(b[8:], 2000)
+		binary.LittleEndian.PutUint32(b[12:], uint32(len(item)+32))
+		binary.LittleEndian.PutUint32(b[16:], 1)
+		binary.LittleEndian.PutUint32(b[20:], flags)
+		return b
+	}
+	apeTag := append(append(ape(1<<31|1<<29), item...), ape(1<<31)...)
+
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAGtitle")
+
+	tagged := append(append(append([]byte{}, data...), apeTag...), id3v1...)
+	stream, err := Parse(tagged)
+	require.NoError(t, err)
+	assert.Equal(t, 128, stream.ID3v1Size)
+	assert.Equal(t, len(apeTag), stream.APESize)
+	assert.Equal(t, len(data), stream.AudioEnd)
+	assert.Len(t, stream.Frames, len(clean.Frames))
+	assert.Zero(t, stream.SkippedBytes)
+}
+
+func TestParseXingAndVBRI(t *testing.T) {
+	h := Header{Version: Version1, Bitrate: 128, SampleRate: 44100, Mode: ModeJointStereo}
+	b, err := h.Marshal()
+	require.NoError(t, err)
+
+	frameWith := func(offset int, body []byte) []byte {
+		frame := make([]byte, h.FrameLength())
+		copy(frame, b)
+		copy(frame[offset:], body)
+		return frame
+	}
+
+	xing := []byte("Xing\x00\x00\x00\x03\x00\x00\x01\x00\x00\x01\x00\x00LAME3.100")
+	vbri := append([]byte("VBRI\x00\x01\x04\x40\x00\x4b"), 0, 0, 0x20, 0, 0, 0, 0, 0x64)
+
+	for _, tc := range []struct {
+		name   string
+		frame  []byte
+		expect EncoderTag
+	}{
+		{"xing", frameWith(36, xing), EncoderTag{Kind: "Xing", Frames: 256, Bytes: 65536, Encoder: "LAME3.100"}},
+		{"vbri", frameWith(36, vbri), EncoderTag{Kind: "VBRI", Frames: 100, Bytes: 8192}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := append(append([]byte{}, tc.frame...), frameWith(0, nil)...)
+			stream, err := Parse(data)
+			require.NoError(t, err)
+			require.NotNil(t, stream.Tag)
+			assert.Equal(t, tc.expect, *stream.Tag)
+		})
+	}
+}
+
+func TestDecodeCoverMainData(t *testing.T) {
+	stream, err := ParseFile(coverPath)
+	require.NoError(t, err)
+
+	decoder := &MainDataDecoder{}
+	nonZero := 0
+	for _, f := range stream.Frames {
+		fd, err := decoder.Decode(stream, f)
+		require.NoError(t, err, "frame %d", f.Index)
+		for _, v := range fd.Granules[0][0].Values {
+			if v != 0 {
+				nonZero++
+			}
+		}
+	}
+	assert.Greater(t, nonZero, 0)
+}
+
+func TestParseNoFrames(t *testing.T) {
+	_, err := Parse([]byte("this is not an mp3 file at all"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package mp3frame
+
+import "math"
+
+// pretab is added to the long-block scalefactors of a granule with preflag
+// set.
+var pretab = [22]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 3, 3, 3, 2, 0}
+
+// Dequantize returns the 576 MDCT coefficients of a decoded granule, scaled
+// so that a full-scale signal gives coefficients of about 1, and the step of
+// every line: a quantized value q comes out as |q|^(4/3) times the step, so
+// (|xr|/step)^(3/4) recovers it. The coefficients are what a decoder feeds
+// to its stereo processing, so a joint stereo frame using mid/side gives
+// mid and side. The lines of short blocks are in bitstream order: by
+// scalefactor band, then window.
+func Dequantize(h Header, g *GranuleInfo, d *Granule) (xr, step [576]float64) {
+	scale := 0.5
+	if g.ScalefacScale {
+		scale = 1
+	}
+	value := func(i int, exponent float64) {
+		step[i] = math.Exp2(exponent)
+		if v := d.Values[i]; v != 0 {
+			x := math.Pow(math.Abs(float64(v)), 4.0/3) * step[i]
+			if v < 0 {
+				x = -x
+			}
+			xr[i] = x
+		}
+	}
+
+	long := sfBandLong[h.SampleRate]
+	longEnd := 576
+	if g.WindowSwitching && g.BlockType == 2 {
+		longEnd = 0
+		if g.MixedBlock {
+			longEnd = long[8]
+		}
+	}
+	for sfb := 0; sfb < 22 && long[sfb] < longEnd; sfb++ {
+		scalefac := d.ScalefacLong[sfb]
+		if g.Preflag {
+			scalefac += pretab[sfb]
+		}
+		exponent := 0.25*float64(g.GlobalGain-210) - scale*float64(scalefac)
+		for i := long[sfb]; i < long[sfb+1]; i++ {
+			value(i, exponent)
+		}
+	}
+
+	if longEnd < 576 {
+		short := sfBandShort[h.SampleRate]
+		sfb := 0
+		if g.MixedBlock {
+			sfb = 3
+		}
+		for i := longEnd; sfb < 13; sfb++ {
+			width := short[sfb+1] - short[sfb]
+			for win := 0; win < 3; win++ {
+				exponent := 0.25*float64(g.GlobalGain-210-8*g.SubblockGain[win]) - scale*float64(d.ScalefacShort[sfb][win])
+				for j := 0; j < width; j, i = j+1, i+1 {
+					value(i, exponent)
+				}
+			}
+		}
+	}
+	return xr, step
+}
+
+// Spectra decodes every audio frame of s and calls fn with the dequantized
+// coefficients and steps of each granule, one spectrum per channel, the
+// hook through which methods reach the MDCT domain. Granules count
+// from the first audio frame; a Xing/Info or VBRI frame is skipped. A frame
+// whose main data cannot be decoded is passed on as silence, so the count
+// stays in step with the audio.
+func Spectra(s *Stream, fn func(granule int, xr, step [][576]float64)) {
+	frames := s.Frames
+	if s.Tag != nil && len(frames) > 0 {
+		frames = frames[1:]
+	}
+
+	decoder := &MainDataDecoder{}
+	for n, f := range frames {
+		channels := f.Header.Channels()
+		fd, err := decoder.Decode(s, f)
+		for gr := 0; gr < f.Header.Granules(); gr++ {
+			xr := make([][576]float64, channels)
+			step := make([][576]float64, channels)
+			if err == nil {
+				for ch := range xr {
+					xr[ch], step[ch] = Dequantize(f.Header, &f.SideInfo.Granules[gr][ch], &fd.Granules[gr][ch])
+				}
+			}
+			fn(n*f.Header.Granules()+gr, xr, step)
+		}
+	}
+}
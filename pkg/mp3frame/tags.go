@@ -0,0 +1,117 @@
+package mp3frame
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	id3v1Size     = 128
+	apeFooterSize = 32
+)
+
+// EncoderTag is the Xing/Info or VBRI header that many encoders write into
+// the first frame of a stream in place of audio.
+type EncoderTag struct {
+	// Kind is "Xing" (VBR), "Info" (CBR) or "VBRI".
+	Kind string
+	// Frames and Bytes are the stream totals recorded in the tag, or zero
+	// when the tag leaves them out.
+	Frames int
+	Bytes  int
+	// Encoder is the encoder string of a LAME extension, e.g. "LAME3.100",
+	// or empty when there is none.
+	Encoder string
+}
+
+// trailingID3v1Size returns 128 if the data ends with an ID3v1 tag.
+func trailingID3v1Size(data []byte) int {
+	if len(data) >= id3v1Size && string(data[len(data)-id3v1Size:len(data)-id3v1Size+3]) == "TAG" {
+		return id3v1Size
+	}
+	return 0
+}
+
+// apeSize returns the size of an APE tag, header included, whose footer ends
+// at end.
+func apeSize(data []byte, end int) int {
+	if end < apeFooterSize {
+		return 0
+	}
+	footer := data[end-apeFooterSize : end]
+	if string(footer[0:8]) != "APETAGEX" {
+		return 0
+	}
+
+	// The size field covers the items and the footer but not the header.
+	size := int(binary.LittleEndian.Uint32(footer[12:16]))
+	if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+		size += apeFooterSize
+	}
+	if size < apeFooterSize || size > end {
+		return 0
+	}
+	return size
+}
+
+// Tags returns the bytes in front of the first frame, i.e. the ID3v2 tag,
+// and the APE and ID3v1 tags that follow the audio, so that they can be
+// carried over to a re-encoded stream.
+func (s *Stream) Tags() (leading, trailing []byte) {
+	return s.Data[:s.ID3v2Size], s.Data[s.AudioEnd:]
+}
+
+// parseEncoderTag looks for a Xing/Info or VBRI header in a frame.
+func parseEncoderTag(data []byte, f *Frame) *EncoderTag {
+	frame := data[f.Offset : f.Offset+f.Length]
+
+	// VBRI always sits 32 bytes after the header.
+	if len(frame) >= 4+32+18 && string(frame[36:40]) == "VBRI" {
+		return &EncoderTag{
+			Kind:   "VBRI",
+			Bytes:  int(binary.BigEndian.Uint32(frame[46:50])),
+			Frames: int(binary.BigEndian.Uint32(frame[50:54])),
+		}
+	}
+
+	pos := f.Header.DataOffset() + f.Header.SideInfoLength()
+	if pos+8 > len(frame) {
+		return nil
+	}
+	kind := string(frame[pos : pos+4])
+	if kind != "Xing" && kind != "Info" {
+		return nil
+	}
+
+	tag := &EncoderTag{Kind: kind}
+	flags := binary.BigEndian.Uint32(frame[pos+4 : pos+8])
+	pos += 8
+	if flags&0x01 != 0 && pos+4 <= len(frame) {
+		tag.Frames = int(binary.BigEndian.Uint32(frame[pos : pos+4]))
+		pos += 4
+	}
+	if flags&0x02 != 0 && pos+4 <= len(frame) {
+		tag.Bytes = int(binary.BigEndian.Uint32(frame[pos : pos+4]))
+		pos += 4
+	}
+	if flags&0x04 != 0 {
+		pos += 100
+	}
+	if flags&0x08 != 0 {
+		pos += 4
+	}
+
+	if pos+9 <= len(frame) && isEncoderString(frame[pos:pos+4]) {
+		tag.Encoder = strings.TrimRight(string(frame[pos:pos+9]), "\x00 ")
+	}
+	return tag
+}
+
+func isEncoderString(b []byte) bool {
+	for _, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+// Package qim implements dither-modulation quantization index modulation
+// (Chen and Wornell). A value carries a bit by being quantized onto one of
+// two interleaved lattices of step Step: the lattice for 0 is offset by the
+// dither, the one for 1 by a further Step/2. The dither is shared secretly
+// by embedder and extractor, usually drawn per value from a keyed stream,
+// so the lattices cannot be found without it.
+package qim
+
+import "math"
+
+// Quantizer embeds and reads bits on lattices of step Step.
+type Quantizer struct {
+	Step float64
+}
+
+// offset returns the lattice offset for bit under dither.
+func (q Quantizer) offset(dither float64, bit bool) float64 {
+	if bit {
+		return dither + q.Step/2
+	}
+	return dither
+}
+
+// Embed returns the point of the lattice for bit closest to x. It moves x
+// by at most Step/2.
+func (q Quantizer) Embed(x, dither float64, bit bool) float64 {
+	d := q.offset(dither, bit)
+	return math.Round((x-d)/q.Step)*q.Step + d
+}
+
+// Soft returns how strongly y reads as a 1, from -1 on the lattice for 0
+// to 1 on the lattice for 1. It falls linearly in between, so noise of up
+// to Step/4 leaves the sign right and its magnitude is a confidence that
+// decoders such as package fec can weigh.
+func (q Quantizer) Soft(y, dither float64) float64 {
+	// The distance to the nearest point of the lattice for 0, in [0, Step/2].
+	e := math.Abs(y - q.Embed(y, dither, false))
+	return 4*e/q.Step - 1
+}
+
+// Bit returns the bit whose lattice y is closer to.
+func (q Quantizer) Bit(y, dither float64) bool {
+	return q.Soft(y, dither) > 0
+}
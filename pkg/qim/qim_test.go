@@ -0,0 +1,71 @@
+package qim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	q := Quantizer{Step: 8}
+	for _, x := range []float64{0, 1, -1, 3.9, -3.9, 1500.3, -25000} {
+		for _, dither := range []float64{0, 2.5, -3.7} {
+			for _, bit := range []bool{false, true} {
+				y := q.Embed(x, dither, bit)
+				assert.LessOrEqual(t, math.Abs(y-x), q.Step/2, "x %v", x)
+				assert.Equal(t, bit, q.Bit(y, dither), "x %v dither %v bit %t", x, dither, bit)
+
+				want := -1.0
+				if bit {
+					want = 1
+				}
+				assert.InDelta(t, want, q.Soft(y, dither), 1e-9)
+			}
+		}
+	}
+}
+
+func TestSoftFallsWithNoise(t *testing.T) {
+	q := Quantizer{Step: 16}
+	y := q.Embed(100, 3, true)
+	assert.InDelta(t, 0.5, q.Soft(y+2, 3), 1e-9)
+	assert.InDelta(t, 0.5, q.Soft(y-2, 3), 1e-9)
+	// Beyond Step/4 the value reads as the other bit.
+	assert.Less(t, q.Soft(y+5, 3), 0.0)
+}
+
+func TestDitherHidesLattice(t *testing.T) {
+	q := Quantizer{Step: 8}
+	y := q.Embed(1000, 2, false)
+	assert.False(t, q.Bit(y, 2))
+	assert.True(t, q.Bit(y, 2+q.Step/2))
+}
+
+func TestBitErrorRate(t *testing.T) {
+	// Gaussian noise of deviation σ flips a bit when it lands nearer the
+	// other lattice: |n| mod Step within Step/4 of Step/2.
+	rng := rand.New(rand.NewSource(1))
+	q := Quantizer{Step: 16}
+	for _, sigma := range []float64{1, 3, 6} {
+		errors := 0
+		const n = 20000
+		for i := 0; i < n; i++ {
+			bit := rng.Intn(2) == 1
+			dither := (rng.Float64() - 0.5) * q.Step
+			y := q.Embed(rng.NormFloat64()*1000, dither, bit) + rng.NormFloat64()*sigma
+			if q.Bit(y, dither) != bit {
+				errors++
+			}
+		}
+
+		var want float64
+		for k := -5; k <= 5; k++ {
+			lo := (float64(k) + 0.25) * q.Step / sigma / math.Sqrt2
+			hi := (float64(k) + 0.75) * q.Step / sigma / math.Sqrt2
+			want += (math.Erf(hi) - math.Erf(lo)) / 2
+		}
+		assert.InDelta(t, want, float64(errors)/n, 0.01, "sigma %v", sigma)
+	}
+}
@@ -0,0 +1,69 @@
+package shard
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// Addition and subtraction are both XOR.
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		// Multiply by the generator 3: x*2 + x.
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1B
+		}
+		x ^= x2
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("shard: division by zero in GF(256)")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// lagrangeWeights returns w such that f(x) = sum w[j]*f(xs[j]) for every
+// polynomial f of degree below len(xs). The points must be distinct.
+func lagrangeWeights(xs []byte, x byte) []byte {
+	w := make([]byte, len(xs))
+	for j, xj := range xs {
+		num, den := byte(1), byte(1)
+		for m, xm := range xs {
+			if m == j {
+				continue
+			}
+			num = gfMul(num, x^xm)
+			den = gfMul(den, xj^xm)
+		}
+		w[j] = gfDiv(num, den)
+	}
+	return w
+}
+
+// evalPoly evaluates a polynomial given by its coefficients, lowest first.
+func evalPoly(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return y
+}
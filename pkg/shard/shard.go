@@ -0,0 +1,159 @@
+// Package shard splits a payload that is too large for one cover across
+// several covers and puts it back together on extraction.
+package shard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// HeaderSize is the size of the header in front of every shard:
+// [4 bytes: magic "SHRD"] + [8 bytes: payload ID] + [2 bytes: index] +
+// [2 bytes: count].
+const HeaderSize = 16
+
+const magic = "SHRD"
+
+// MaxShards is the largest number of shards a payload can be split into.
+const MaxShards = 1<<16 - 1
+
+// Shard is one piece of a payload. PayloadID is the start of the SHA-256 of
+// the whole payload, so shards of different payloads are never mixed up and
+// the reassembled payload can be checked.
+type Shard struct {
+	PayloadID [8]byte
+	Index     int
+	Count     int
+	Data      []byte
+}
+
+func (s *Shard) Marshal() []byte {
+	b := make([]byte, HeaderSize, HeaderSize+len(s.Data))
+	copy(b[0:4], magic)
+	copy(b[4:12], s.PayloadID[:])
+	binary.LittleEndian.PutUint16(b[12:14], uint16(s.Index))
+	binary.LittleEndian.PutUint16(b[14:16], uint16(s.Count))
+	return append(b, s.Data...)
+}
+
+// IsShard reports whether data starts with a shard header. The container
+// written by embed starts with a small little-endian length, so it can never
+// be mistaken for one.
+func IsShard(data []byte) bool {
+	return len(data) >= HeaderSize && string(data[0:4]) == magic
+}
+
+func Parse(data []byte) (*Shard, error) {
+	if !IsShard(data) {
+		return nil, fmt.Errorf("not a shard")
+	}
+	s := &Shard{
+		Index: int(binary.LittleEndian.Uint16(data[12:14])),
+		Count: int(binary.LittleEndian.Uint16(data[14:16])),
+		Data:  data[HeaderSize:],
+	}
+	copy(s.PayloadID[:], data[4:12])
+	if s.Count == 0 || s.Index >= s.Count {
+		return nil, fmt.Errorf("invalid shard %d of %d", s.Index, s.Count)
+	}
+	return s, nil
+}
+
+func payloadID(payload []byte) [8]byte {
+	var id [8]byte
+	sum := sha256.Sum256(payload)
+	copy(id[:], sum[:8])
+	return id
+}
+
+// Split cuts payload into shards that fit the given capacities, which
+// include room for the shard header. Covers are filled in order and only as
+// many are used as the payload needs. The result lines up with capacities:
+// shards[i] goes into cover i, and is nil for covers too small to hold a
+// shard header plus one byte.
+func Split(payload []byte, capacities []int) ([]*Shard, error) {
+	sizes := make([]int, 0, len(capacities))
+	count := 0
+	remaining := len(payload)
+	for _, capacity := range capacities {
+		if remaining == 0 {
+			break
+		}
+		size := capacity - HeaderSize
+		if size > remaining {
+			size = remaining
+		}
+		if size > 0 {
+			remaining -= size
+			count++
+		}
+		sizes = append(sizes, size)
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("data too large: %d bytes do not fit in the covers", remaining)
+	}
+	if count > MaxShards {
+		return nil, fmt.Errorf("too many shards: %d", count)
+	}
+
+	id := payloadID(payload)
+	shards := make([]*Shard, len(sizes))
+	index, offset := 0, 0
+	for i, size := range sizes {
+		if size <= 0 {
+			continue
+		}
+		shards[i] = &Shard{
+			PayloadID: id,
+			Index:     index,
+			Count:     count,
+			Data:      payload[offset : offset+size],
+		}
+		index++
+		offset += size
+	}
+	return shards, nil
+}
+
+// Join reassembles a payload from its shards, given in any order. Every
+// shard must be present exactly once.
+func Join(shards []*Shard) ([]byte, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards")
+	}
+
+	sorted := append([]*Shard(nil), shards...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+
+	first := sorted[0]
+	for i, s := range sorted {
+		if s.PayloadID != first.PayloadID {
+			return nil, fmt.Errorf("shards belong to different payloads")
+		}
+		if s.Count != first.Count {
+			return nil, fmt.Errorf("shards disagree on the shard count")
+		}
+		if s.Index != i {
+			if s.Index < i {
+				return nil, fmt.Errorf("shard %d given twice", s.Index+1)
+			}
+			return nil, fmt.Errorf("missing shard %d of %d", i+1, first.Count)
+		}
+	}
+	if len(sorted) != first.Count {
+		return nil, fmt.Errorf("missing shard %d of %d", len(sorted)+1, first.Count)
+	}
+
+	var buf bytes.Buffer
+	for _, s := range sorted {
+		buf.Write(s.Data)
+	}
+	payload := buf.Bytes()
+	if payloadID(payload) != first.PayloadID {
+		return nil, fmt.Errorf("reassembled payload does not match its ID")
+	}
+	return payload, nil
+}
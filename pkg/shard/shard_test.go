@@ -0,0 +1,81 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i*11 + 3)
+	}
+	return payload
+}
+
+func TestSplitJoin(t *testing.T) {
+	payload := testPayload(100)
+
+	shards, err := Split(payload, []int{HeaderSize + 40, HeaderSize, HeaderSize + 50, HeaderSize + 50, 1000})
+	require.NoError(t, err)
+	require.Len(t, shards, 4)
+	assert.Nil(t, shards[1])
+	assert.Len(t, shards[0].Data, 40)
+	assert.Len(t, shards[2].Data, 50)
+	assert.Len(t, shards[3].Data, 10)
+	assert.Equal(t, 2, shards[3].Index)
+	assert.Equal(t, 3, shards[3].Count)
+
+	// Shards go through Marshal and Parse and come back in any order.
+	var parsed []*Shard
+	for _, i := range []int{3, 0, 2} {
+		data := shards[i].Marshal()
+		require.True(t, IsShard(data))
+		s, err := Parse(data)
+		require.NoError(t, err)
+		parsed = append(parsed, s)
+	}
+	joined, err := Join(parsed)
+	require.NoError(t, err)
+	assert.Equal(t, payload, joined)
+
+	_, err = Split(payload, []int{HeaderSize + 40, HeaderSize + 40})
+	assert.Error(t, err)
+}
+
+func TestJoinErrors(t *testing.T) {
+	shards, err := Split(testPayload(30), []int{HeaderSize + 10, HeaderSize + 10, HeaderSize + 10})
+	require.NoError(t, err)
+
+	_, err = Join(shards[:2])
+	assert.ErrorContains(t, err, "missing shard 3 of 3")
+
+	_, err = Join([]*Shard{shards[0], shards[0], shards[2]})
+	assert.ErrorContains(t, err, "given twice")
+
+	other, err := Split(testPayload(31), []int{HeaderSize + 11, HeaderSize + 10, HeaderSize + 10})
+	require.NoError(t, err)
+	_, err = Join([]*Shard{shards[0], other[1], shards[2]})
+	assert.ErrorContains(t, err, "different payloads")
+
+	tampered := *shards[1]
+	tampered.Data = []byte("0123456789")
+	_, err = Join([]*Shard{shards[0], &tampered, shards[2]})
+	assert.ErrorContains(t, err, "does not match")
+
+	_, err = Join(nil)
+	assert.Error(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+	assert.False(t, IsShard([]byte{20, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}))
+
+	data := (&Shard{Index: 2, Count: 2}).Marshal()
+	_, err := Parse(data)
+	assert.Error(t, err)
+
+	_, err = Parse(data[:8])
+	assert.Error(t, err)
+}
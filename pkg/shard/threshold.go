@@ -0,0 +1,300 @@
+package shard
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// ShareHeaderSize is the size of the header in front of every threshold
+// share: [4 bytes: magic "SHAR"] + [8 bytes: payload ID] + [share number] +
+// [threshold] + [share count] + [flags] + [4 bytes: payload length].
+const ShareHeaderSize = 20
+
+const shareMagic = "SHAR"
+
+const (
+	flagErasure = 1 << 0
+
+	// shareKeySize is the AES-256 key of erasure-coded shares.
+	shareKeySize = 32
+
+	// shareDigestSize is the SHA-256 of the payload that is shared along
+	// with it.
+	shareDigestSize = sha256.Size
+
+	// MaxShares keeps share numbers and the erasure-coding data points
+	// distinct, non-zero elements of GF(256).
+	MaxShares = 255
+)
+
+// Share is one of n shares of a payload, any k of which recover it.
+//
+// Plain shares are Shamir secret sharing applied to every payload byte, so
+// each share is as large as the payload and fewer than k reveal nothing.
+// Erasure-coded shares encrypt the payload under a random key, split the
+// ciphertext with an information dispersal code so that each share carries
+// only 1/k of it, and Shamir-share the key; fewer than k shares reveal
+// nothing short of breaking AES.
+//
+// PayloadID is random and only groups the shares of one payload. The
+// payload is checked on recovery against its SHA-256, which is shared
+// together with it and so is hidden from anyone holding fewer than k shares.
+type Share struct {
+	PayloadID     [8]byte
+	X             int
+	Threshold     int
+	Count         int
+	Erasure       bool
+	PayloadLength int
+	Data          []byte
+}
+
+func (s *Share) Marshal() []byte {
+	b := make([]byte, ShareHeaderSize, ShareHeaderSize+len(s.Data))
+	copy(b[0:4], shareMagic)
+	copy(b[4:12], s.PayloadID[:])
+	b[12] = byte(s.X)
+	b[13] = byte(s.Threshold)
+	b[14] = byte(s.Count)
+	if s.Erasure {
+		b[15] |= flagErasure
+	}
+	binary.LittleEndian.PutUint32(b[16:20], uint32(s.PayloadLength))
+	return append(b, s.Data...)
+}
+
+// IsShare reports whether data starts with a threshold share header.
+func IsShare(data []byte) bool {
+	return len(data) >= ShareHeaderSize && string(data[0:4]) == shareMagic
+}
+
+func ParseShare(data []byte) (*Share, error) {
+	if !IsShare(data) {
+		return nil, fmt.Errorf("not a threshold share")
+	}
+	s := &Share{
+		X:             int(data[12]),
+		Threshold:     int(data[13]),
+		Count:         int(data[14]),
+		Erasure:       data[15]&flagErasure != 0,
+		PayloadLength: int(binary.LittleEndian.Uint32(data[16:20])),
+		Data:          data[ShareHeaderSize:],
+	}
+	copy(s.PayloadID[:], data[4:12])
+
+	if s.Threshold < 1 || s.Threshold > s.Count || s.X < 1 || s.X > s.Count {
+		return nil, fmt.Errorf("invalid share %d of %d with threshold %d", s.X, s.Count, s.Threshold)
+	}
+	if len(s.Data) != shareDataSize(s.PayloadLength, s.Threshold, s.Erasure) {
+		return nil, fmt.Errorf("share %d has %d bytes of data, expected %d", s.X, len(s.Data), shareDataSize(s.PayloadLength, s.Threshold, s.Erasure))
+	}
+	return s, nil
+}
+
+// ShareSize returns the size of one marshalled share.
+func ShareSize(payloadLength, threshold int, erasure bool) int {
+	return ShareHeaderSize + shareDataSize(payloadLength, threshold, erasure)
+}
+
+func shareDataSize(payloadLength, threshold int, erasure bool) int {
+	messageLength := payloadLength + shareDigestSize
+	if !erasure {
+		return messageLength
+	}
+	return shareKeySize + (messageLength+threshold-1)/threshold
+}
+
+// dataPoints are the points at which the k ciphertext bytes of a group are
+// the values of the dispersal polynomial; share x holds its value at x.
+func dataPoints(threshold, count int) []byte {
+	xs := make([]byte, threshold)
+	for j := range xs {
+		xs[j] = byte(count + 1 + j)
+	}
+	return xs
+}
+
+// SplitThreshold splits payload into count shares, any threshold of which
+// recover it.
+func SplitThreshold(payload []byte, threshold, count int, erasure bool) ([]*Share, error) {
+	if threshold < 1 || threshold > count {
+		return nil, fmt.Errorf("threshold must be between 1 and the number of shares (%d), got %d", count, threshold)
+	}
+	limit := MaxShares
+	if erasure {
+		limit = MaxShares - threshold
+	}
+	if count > limit {
+		return nil, fmt.Errorf("too many shares: %d", count)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate payload ID: %w", err)
+	}
+
+	// The shared message is the payload followed by its SHA-256.
+	digest := sha256.Sum256(payload)
+	message := append(append(make([]byte, 0, len(payload)+shareDigestSize), payload...), digest[:]...)
+
+	shares := make([]*Share, count)
+	for i := range shares {
+		shares[i] = &Share{
+			PayloadID:     id,
+			X:             i + 1,
+			Threshold:     threshold,
+			Count:         count,
+			Erasure:       erasure,
+			PayloadLength: len(payload),
+			Data:          make([]byte, 0, shareDataSize(len(payload), threshold, erasure)),
+		}
+	}
+
+	if !erasure {
+		if err := shareSecret(message, shares); err != nil {
+			return nil, err
+		}
+		return shares, nil
+	}
+
+	key := make([]byte, shareKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if err := shareSecret(key, shares); err != nil {
+		return nil, err
+	}
+
+	// The ciphertext is padded to whole groups of threshold bytes.
+	plaintext := make([]byte, (len(message)+threshold-1)/threshold*threshold)
+	copy(plaintext, message)
+	ciphertext := make([]byte, len(plaintext))
+	shareCipher(key).XORKeyStream(ciphertext, plaintext)
+
+	weights := make([][]byte, count)
+	for i := range weights {
+		weights[i] = lagrangeWeights(dataPoints(threshold, count), byte(i+1))
+	}
+	for g := 0; g < len(ciphertext); g += threshold {
+		group := ciphertext[g : g+threshold]
+		for i, s := range shares {
+			var y byte
+			for j, w := range weights[i] {
+				y ^= gfMul(w, group[j])
+			}
+			s.Data = append(s.Data, y)
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recovers a payload from at least Threshold of its shares, given in
+// any order. Duplicates are ignored.
+func Combine(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares")
+	}
+
+	first := shares[0]
+	seen := make(map[int]bool)
+	var used []*Share
+	for _, s := range shares {
+		if s.PayloadID != first.PayloadID {
+			return nil, fmt.Errorf("shares belong to different payloads")
+		}
+		if s.Threshold != first.Threshold || s.Count != first.Count || s.Erasure != first.Erasure || s.PayloadLength != first.PayloadLength {
+			return nil, fmt.Errorf("shares disagree on their parameters")
+		}
+		if !seen[s.X] {
+			seen[s.X] = true
+			used = append(used, s)
+		}
+	}
+	if len(used) < first.Threshold {
+		return nil, fmt.Errorf("need %d of %d shares, found %d", first.Threshold, first.Count, len(used))
+	}
+	used = used[:first.Threshold]
+
+	xs := make([]byte, len(used))
+	for i, s := range used {
+		xs[i] = byte(s.X)
+	}
+
+	// The Shamir-shared secret is the message itself or the key.
+	messageLength := first.PayloadLength + shareDigestSize
+	secretLength := messageLength
+	if first.Erasure {
+		secretLength = shareKeySize
+	}
+	atZero := lagrangeWeights(xs, 0)
+	secret := make([]byte, secretLength)
+	for i := range secret {
+		for m, s := range used {
+			secret[i] ^= gfMul(atZero[m], s.Data[i])
+		}
+	}
+
+	message := secret
+	if first.Erasure {
+		points := dataPoints(first.Threshold, first.Count)
+		weights := make([][]byte, len(points))
+		for j, x := range points {
+			weights[j] = lagrangeWeights(xs, x)
+		}
+
+		pieceLength := len(used[0].Data) - shareKeySize
+		ciphertext := make([]byte, 0, pieceLength*first.Threshold)
+		for g := 0; g < pieceLength; g++ {
+			for j := range points {
+				var y byte
+				for m, s := range used {
+					y ^= gfMul(weights[j][m], s.Data[shareKeySize+g])
+				}
+				ciphertext = append(ciphertext, y)
+			}
+		}
+
+		message = make([]byte, messageLength)
+		shareCipher(secret).XORKeyStream(message, ciphertext[:messageLength])
+	}
+
+	payload := message[:first.PayloadLength]
+	if digest := sha256.Sum256(payload); !bytes.Equal(digest[:], message[first.PayloadLength:]) {
+		return nil, fmt.Errorf("recovered payload does not match its checksum")
+	}
+	return payload, nil
+}
+
+// shareSecret appends to every share its Shamir share of each byte of
+// secret: the value at the share number of a random polynomial whose
+// constant term is the byte.
+func shareSecret(secret []byte, shares []*Share) error {
+	coefficients := make([]byte, shares[0].Threshold)
+	for _, b := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return fmt.Errorf("failed to generate coefficients: %w", err)
+		}
+		coefficients[0] = b
+		for _, s := range shares {
+			s.Data = append(s.Data, evalPoly(coefficients, byte(s.X)))
+		}
+	}
+	return nil
+}
+
+// shareCipher encrypts erasure-coded payloads. Every key is random and used
+// once, so a zero IV is safe.
+func shareCipher(key []byte) cipher.Stream {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// A 32-byte key is always valid.
+		panic(err)
+	}
+	return cipher.NewCTR(block, make([]byte, aes.BlockSize))
+}
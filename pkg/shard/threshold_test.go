@@ -0,0 +1,109 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholdAnySubset(t *testing.T) {
+	for _, erasure := range []bool{false, true} {
+		payload := testPayload(101)
+		shares, err := SplitThreshold(payload, 3, 5, erasure)
+		require.NoError(t, err)
+		require.Len(t, shares, 5)
+		for _, s := range shares {
+			assert.Len(t, s.Marshal(), ShareSize(len(payload), 3, erasure))
+		}
+		if erasure {
+			assert.Len(t, shares[0].Data, shareKeySize+(101+shareDigestSize+2)/3)
+		}
+
+		// Every 3 of the 5 shares recover the payload, in any order and
+		// after going through Marshal and ParseShare.
+		for a := 0; a < 5; a++ {
+			for b := a + 1; b < 5; b++ {
+				for c := b + 1; c < 5; c++ {
+					var parsed []*Share
+					for _, i := range []int{c, a, b} {
+						data := shares[i].Marshal()
+						require.True(t, IsShare(data))
+						s, err := ParseShare(data)
+						require.NoError(t, err)
+						parsed = append(parsed, s)
+					}
+					combined, err := Combine(parsed)
+					require.NoError(t, err, "erasure=%v shares %d %d %d", erasure, a, b, c)
+					assert.Equal(t, payload, combined)
+				}
+			}
+		}
+
+		_, err = Combine([]*Share{shares[0], shares[4], shares[4]})
+		assert.ErrorContains(t, err, "need 3 of 5 shares, found 2")
+	}
+}
+
+func TestThresholdOfOne(t *testing.T) {
+	payload := testPayload(20)
+	shares, err := SplitThreshold(payload, 1, 2, false)
+	require.NoError(t, err)
+
+	// With a threshold of one every share is the payload itself, followed
+	// by its checksum.
+	assert.Equal(t, payload, shares[1].Data[:len(payload)])
+	combined, err := Combine(shares[1:])
+	require.NoError(t, err)
+	assert.Equal(t, payload, combined)
+}
+
+func TestThresholdErrors(t *testing.T) {
+	_, err := SplitThreshold(testPayload(10), 4, 3, false)
+	assert.Error(t, err)
+	_, err = SplitThreshold(testPayload(10), 0, 3, false)
+	assert.Error(t, err)
+	_, err = SplitThreshold(testPayload(10), 2, 254, true)
+	assert.ErrorContains(t, err, "too many shares")
+
+	shares, err := SplitThreshold(testPayload(10), 2, 3, true)
+	require.NoError(t, err)
+	other, err := SplitThreshold(testPayload(11), 2, 3, true)
+	require.NoError(t, err)
+	_, err = Combine([]*Share{shares[0], other[1]})
+	assert.ErrorContains(t, err, "different payloads")
+
+	// A corrupted share is caught by the checksum.
+	shares[1].Data[shareKeySize] ^= 1
+	_, err = Combine(shares[:2])
+	assert.ErrorContains(t, err, "does not match")
+
+	data := shares[0].Marshal()
+	_, err = ParseShare(data[:len(data)-1])
+	assert.ErrorContains(t, err, "expected")
+	assert.False(t, IsShare(shares[0].Data))
+	assert.False(t, IsShard(data))
+}
+
+func TestThresholdHidesPayload(t *testing.T) {
+	for _, erasure := range []bool{false, true} {
+		payload := testPayload(40)
+		first, err := SplitThreshold(payload, 2, 3, erasure)
+		require.NoError(t, err)
+		second, err := SplitThreshold(payload, 2, 3, erasure)
+		require.NoError(t, err)
+
+		// The payload ID is random, so a share does not confirm a guess
+		// at the payload, and splitting the same payload twice gives
+		// unrelated shares.
+		assert.NotEqual(t, first[0].PayloadID, second[0].PayloadID)
+		assert.NotEqual(t, payloadID(payload), first[0].PayloadID)
+		assert.NotEqual(t, first[0].Data, second[0].Data)
+
+		_, err = Combine([]*Share{first[0], second[1]})
+		assert.ErrorContains(t, err, "different payloads")
+		combined, err := Combine(second[1:])
+		require.NoError(t, err)
+		assert.Equal(t, payload, combined)
+	}
+}
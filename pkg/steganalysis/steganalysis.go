@@ -0,0 +1,182 @@
+package steganalysis
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+type AnomalyKind string
+
+const (
+	AnomalyDecodeError    AnomalyKind = "decode-error"
+	AnomalyBigValues      AnomalyKind = "big-values"
+	AnomalyTableSelect    AnomalyKind = "table-select"
+	AnomalyPart23Outlier  AnomalyKind = "part2_3-outlier"
+	AnomalyPart23Overflow AnomalyKind = "part2_3-overflow"
+)
+
+// part23OutlierZ is how many standard deviations a granule's part2_3_length
+// may sit from the stream mean before it is reported.
+const part23OutlierZ = 5.0
+
+// mp3StegoThreshold is the coefficient of variation of part2_3_length above
+// which a stream is flagged. MP3Stego hides bits in the parity of the block
+// length by re-running the inner quantization loop, which widens the spread
+// of block lengths compared to a normal encoder (Westfeld, 2002).
+const mp3StegoThreshold = 0.45
+
+type FrameAnomaly struct {
+	Frame  int
+	Offset int
+	Kind   AnomalyKind
+	Detail string
+}
+
+type Report struct {
+	Frames             int
+	DamagedFrames      int
+	DecodeErrors       int
+	BigValuesAnomalies int
+	Part23Outliers     int
+	Part23Mean         float64
+	Part23StdDev       float64
+	Part23ParityRatio  float64
+	MP3StegoScore      float64
+	MP3StegoSuspected  bool
+	SkippedBytes       int
+	Anomalies          []FrameAnomaly
+}
+
+func AnalyzeFile(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MP3 file: %w", err)
+	}
+	return Analyze(data)
+}
+
+func Analyze(data []byte) (*Report, error) {
+	stream, err := mp3frame.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3 stream: %w", err)
+	}
+
+	report := &Report{
+		Frames:       len(stream.Frames),
+		SkippedBytes: stream.SkippedBytes,
+	}
+	damaged := make(map[int]bool)
+	add := func(f *mp3frame.Frame, kind AnomalyKind, detail string) {
+		report.Anomalies = append(report.Anomalies, FrameAnomaly{
+			Frame:  f.Index,
+			Offset: f.Offset,
+			Kind:   kind,
+			Detail: detail,
+		})
+		damaged[f.Index] = true
+	}
+
+	decoder := &mp3frame.MainDataDecoder{}
+	var lengths []float64
+	for _, f := range stream.Frames {
+		channels := f.Header.Channels()
+		maxPart23 := (f.Length - f.Header.DataOffset() - f.Header.SideInfoLength() + f.SideInfo.MainDataBegin) * 8
+		totalPart23 := 0
+
+		for gr := 0; gr < f.Header.Granules(); gr++ {
+			for ch := 0; ch < channels; ch++ {
+				g := f.SideInfo.Granules[gr][ch]
+				lengths = append(lengths, float64(g.Part23Length))
+				totalPart23 += g.Part23Length
+
+				if g.BigValues > 288 {
+					report.BigValuesAnomalies++
+					add(f, AnomalyBigValues, fmt.Sprintf("granule %d channel %d: big_values %d exceeds 288", gr, ch, g.BigValues))
+				} else if g.Part23Length == 0 && g.BigValues > 0 {
+					report.BigValuesAnomalies++
+					add(f, AnomalyBigValues, fmt.Sprintf("granule %d channel %d: big_values %d with empty part2_3", gr, ch, g.BigValues))
+				}
+
+				tables := 3
+				if g.WindowSwitching {
+					tables = 2
+				}
+				for i := 0; i < tables; i++ {
+					if t := g.TableSelect[i]; t == 4 || t == 14 {
+						add(f, AnomalyTableSelect, fmt.Sprintf("granule %d channel %d: reserved Huffman table %d", gr, ch, t))
+					}
+				}
+			}
+		}
+
+		if totalPart23 > maxPart23 {
+			add(f, AnomalyPart23Overflow, fmt.Sprintf("part2_3_length total %d exceeds %d available bits", totalPart23, maxPart23))
+		}
+
+		if _, err := decoder.Decode(stream, f); err != nil && err != mp3frame.ErrUnsupported {
+			report.DecodeErrors++
+			add(f, AnomalyDecodeError, err.Error())
+		}
+	}
+
+	report.Part23Mean, report.Part23StdDev = meanStdDev(lengths)
+	if report.Part23StdDev > 0 {
+		i := 0
+		for _, f := range stream.Frames {
+			for gr := 0; gr < f.Header.Granules(); gr++ {
+				for ch := 0; ch < f.Header.Channels(); ch++ {
+					z := (lengths[i] - report.Part23Mean) / report.Part23StdDev
+					if math.Abs(z) > part23OutlierZ {
+						report.Part23Outliers++
+						add(f, AnomalyPart23Outlier, fmt.Sprintf("granule %d channel %d: part2_3_length %.0f (z=%.1f)", gr, ch, lengths[i], z))
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	odd := 0
+	for _, l := range lengths {
+		if int(l)%2 == 1 {
+			odd++
+		}
+	}
+	if len(lengths) > 0 {
+		report.Part23ParityRatio = float64(odd) / float64(len(lengths))
+	}
+	if report.Part23Mean > 0 {
+		report.MP3StegoScore = report.Part23StdDev / report.Part23Mean
+	}
+	report.MP3StegoSuspected = report.DecodeErrors == 0 && report.MP3StegoScore > mp3StegoThreshold
+
+	report.DamagedFrames = len(damaged)
+	return report, nil
+}
+
+func (r *Report) DamagedRatio() float64 {
+	if r.Frames == 0 {
+		return 0
+	}
+	return float64(r.DamagedFrames) / float64(r.Frames)
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
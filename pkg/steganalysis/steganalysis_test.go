@@ -0,0 +1,89 @@
+package steganalysis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"audio-steganography-lsb/pkg/embed"
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const coverPath = "../../test/cover-1.mp3"
+
+func TestAnalyzeCleanCover(t *testing.T) {
+	report, err := AnalyzeFile(coverPath)
+	require.NoError(t, err)
+
+	assert.Greater(t, report.Frames, 0)
+	assert.Zero(t, report.DecodeErrors)
+	assert.Zero(t, report.BigValuesAnomalies)
+	assert.Zero(t, report.DamagedFrames)
+	assert.False(t, report.MP3StegoSuspected)
+	assert.InDelta(t, 0.5, report.Part23ParityRatio, 0.1)
+}
+
+func TestAnalyzeBitstreamStego(t *testing.T) {
+	tempDir := t.TempDir()
+	secretFile := filepath.Join(tempDir, "secret.txt")
+	stegoFile := filepath.Join(tempDir, "stego.mp3")
+	require.NoError(t, os.WriteFile(secretFile, []byte("a secret message for the analyzer"), 0644))
+
+	err := embed.Embed(&embed.EmbedConfig{
+		CoverAudio:    coverPath,
+		SecretMessage: secretFile,
+		StegoKey:      "testkey",
+		NLsb:          2,
+		UseRandomSeed: true,
+		OutputPath:    stegoFile,
+	})
+	require.NoError(t, err)
+
+	report, err := AnalyzeFile(stegoFile)
+	require.NoError(t, err)
+
+	assert.Greater(t, report.DamagedFrames, 0)
+	assert.Greater(t, report.DecodeErrors, 0)
+	assert.Greater(t, report.DamagedRatio(), 0.0)
+	for _, a := range report.Anomalies {
+		assert.NotEmpty(t, a.Detail)
+	}
+}
+
+func TestAnalyzeCorruptedFrame(t *testing.T) {
+	data, err := os.ReadFile(coverPath)
+	require.NoError(t, err)
+
+	clean, err := Analyze(data)
+	require.NoError(t, err)
+
+	stream, err := mp3frame.Parse(data)
+	require.NoError(t, err)
+
+	// In MPEG-1 stereo side info, big_values of the first granule occupies
+	// bits 32-40; force it to 511.
+	corrupted := make([]byte, len(data))
+	copy(corrupted, data)
+	sideInfo := stream.Frames[10].SideInfoOffset()
+	corrupted[sideInfo+4] = 0xFF
+	corrupted[sideInfo+5] |= 0x80
+
+	report, err := Analyze(corrupted)
+	require.NoError(t, err)
+	assert.Equal(t, clean.Frames, report.Frames)
+	assert.Greater(t, report.BigValuesAnomalies, 0)
+	require.NotEmpty(t, report.Anomalies)
+	assert.Equal(t, 10, report.Anomalies[0].Frame)
+	assert.Equal(t, AnomalyBigValues, report.Anomalies[0].Kind)
+}
+
+func TestAnalyzeNotMP3(t *testing.T) {
+	_, err := Analyze([]byte("definitely not an mp3"))
+	assert.Error(t, err)
+
+	_, err = AnalyzeFile("nonexistent.mp3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,473 @@
+package stego
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+
+	"audio-steganography-lsb/pkg/metadata"
+	"audio-steganography-lsb/pkg/utils"
+)
+
+const APICID byte = 8
+
+// APIC hides the payload in the pixel LSBs of PNG album art stored in an
+// APIC frame. The red, green and blue bytes of every pixel are carriers;
+// alpha is left alone. The picture is re-encoded losslessly and written back
+// in place, so the audio is not touched.
+//
+// Only non-interlaced 8-bit RGB and RGBA pictures are supported: they decode
+// to 8 bits per channel exactly, and are written back with their own color
+// type and every chunk but the image data kept, so the picture does not
+// change format or grow. JPEG pictures are not supported, since hiding data
+// in them would need access to the DCT coefficients.
+type APIC struct{}
+
+func init() {
+	Register(APIC{})
+}
+
+func (APIC) Name() string { return "apic" }
+
+func (APIC) ID() byte { return APICID }
+
+func (APIC) Capacity(cover []byte, params *Params) (int, error) {
+	_, _, img, err := findPicture(cover)
+	if err != nil {
+		return 0, err
+	}
+
+	h := &Header{NLsb: params.NLsb, UseRandomSeed: params.UseRandomSeed, Deniable: params.Deniable}
+	order, err := pictureLayout(len(pictureCarriers(img)), h, params.StegoKey)
+	if err != nil {
+		return 0, err
+	}
+	return len(order) * params.NLsb / 8, nil
+}
+
+func (a APIC) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	return a.EmbedMany(cover, [][]byte{payload}, []string{params.StegoKey}, params)
+}
+
+func (APIC) EmbedMany(cover []byte, payloads [][]byte, keys []string, params *Params) ([]byte, error) {
+	frame, picture, img, err := findPicture(cover)
+	if err != nil {
+		return nil, err
+	}
+	carriers := pictureCarriers(img)
+
+	noise, err := fillNoise(params, keys, len(carriers))
+	if err != nil {
+		return nil, err
+	}
+	if noise != nil {
+		fillPositions(img.Pix, carriers, params.NLsb, noise)
+	}
+
+	headers := make([]*Header, len(payloads))
+	regions := make([][2]int, len(payloads))
+	plan := func(order []int, first int) (int, error) {
+		from := first
+		for _, i := range order {
+			header := &Header{
+				MethodID:      APICID,
+				NLsb:          params.NLsb,
+				UseRandomSeed: params.UseRandomSeed,
+				PayloadLength: len(payloads[i]),
+				Deniable:      hidesRegions(params, keys),
+			}
+
+			count := utils.RegionBlocks(len(carriers), first, func(start, end int) bool {
+				return pictureFits(header, end-start)
+			})
+			if count == 0 {
+				start, end := utils.Region(len(carriers), first, utils.Regions-first)
+				capacity := 0
+				if end-start >= headerBits(header.Deniable) {
+					capacity = (end - start - headerBits(header.Deniable)) * params.NLsb
+				}
+				return 0, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payloads[i])*8, capacity)
+			}
+			headers[i] = header
+			regions[i][0], regions[i][1] = utils.Region(len(carriers), first, count)
+			first += count
+		}
+		return first - from, nil
+	}
+	if err := placeRegions(len(payloads), hidesRegions(params, keys), plan); err != nil {
+		return nil, err
+	}
+
+	for i, payload := range payloads {
+		start, end := regions[i][0], regions[i][1]
+		if err := writePicture(img, carriers[start:end], headers[i], payload, keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if picture.Data, err = encodePNG(picture.Data, img); err != nil {
+		return nil, err
+	}
+
+	return metadata.ReplaceFrameBody(cover, frame, picture.Body())
+}
+
+// writePicture writes the header and payload into the pixel bytes of one
+// region.
+func writePicture(img *image.NRGBA, carriers []int, header *Header, payload []byte, stegoKey string) error {
+	order, err := pictureLayout(len(carriers), header, stegoKey)
+	if err != nil {
+		return err
+	}
+
+	headerBytes, err := header.Marshal(stegoKey)
+	if err != nil {
+		return err
+	}
+	if header.Deniable {
+		payload = whiten(payload, stegoKey, header.Nonce, HeaderSize)
+	}
+	bits := BytesToBits(payload)
+	fmt.Printf("Embedding %d bits into a %dx%d picture (capacity %d bits)\n", len(bits), img.Rect.Dx(), img.Rect.Dy(), len(order)*header.NLsb)
+
+	for i, bit := range BytesToBits(headerBytes) {
+		setBits(img.Pix, carriers[i], 0x01, bit)
+	}
+	for i := 0; i*header.NLsb < len(bits); i++ {
+		pos := carriers[len(headerBytes)*8+order[i]]
+		for j := 0; j < header.NLsb && i*header.NLsb+j < len(bits); j++ {
+			setBits(img.Pix, pos, 1<<j, bits[i*header.NLsb+j])
+		}
+	}
+	return nil
+}
+
+func (APIC) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	_, _, img, err := findPicture(stego)
+	if err != nil {
+		return nil, err
+	}
+
+	header, carriers, err := findPictureRegion(img, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	order, err := pictureLayout(len(carriers), header, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := header.PayloadLength * 8
+	bits := make([]bool, 0, needed+header.NLsb)
+	for i := 0; len(bits) < needed; i++ {
+		b := img.Pix[carriers[headerBits(header.Deniable)+order[i]]]
+		for j := 0; j < header.NLsb; j++ {
+			bits = append(bits, (b>>j)&1 == 1)
+		}
+	}
+	if header.Deniable {
+		return whiten(BitsToBytes(bits[:needed]), stegoKey, header.Nonce, HeaderSize), nil
+	}
+	return BitsToBytes(bits[:needed]), nil
+}
+
+func (APIC) Detect(stego []byte, stegoKey string) bool {
+	_, _, img, err := findPicture(stego)
+	if err != nil {
+		return false
+	}
+	_, _, err = findPictureRegion(img, stegoKey)
+	return err == nil
+}
+
+// findPicture returns the first APIC frame holding a PNG picture, the frame
+// body split up, and the decoded image.
+func findPicture(data []byte) (metadata.Frame, *metadata.Picture, *image.NRGBA, error) {
+	tag, err := metadata.ParseTag(data)
+	if err != nil {
+		return metadata.Frame{}, nil, nil, fmt.Errorf("failed to parse ID3v2 tag: %w", err)
+	}
+	if tag == nil {
+		return metadata.Frame{}, nil, nil, fmt.Errorf("no ID3v2 tag found")
+	}
+
+	jpeg := false
+	var unsupported error
+	for _, f := range tag.Frames {
+		if f.ID != "APIC" {
+			continue
+		}
+		picture, err := metadata.ParsePicture(f.Body)
+		if err != nil {
+			return metadata.Frame{}, nil, nil, err
+		}
+		if !bytes.HasPrefix(picture.Data, pngSignature) {
+			jpeg = jpeg || bytes.HasPrefix(picture.Data, []byte{0xFF, 0xD8})
+			continue
+		}
+		if _, err := pngColorType(picture.Data); err != nil {
+			unsupported = err
+			continue
+		}
+
+		img, err := png.Decode(bytes.NewReader(picture.Data))
+		if err != nil {
+			return metadata.Frame{}, nil, nil, fmt.Errorf("failed to decode picture: %w", err)
+		}
+		return f, picture, toNRGBA(img), nil
+	}
+
+	if unsupported != nil {
+		return metadata.Frame{}, nil, nil, unsupported
+	}
+	if jpeg {
+		return metadata.Frame{}, nil, nil, fmt.Errorf("album art is JPEG; only PNG pictures are supported")
+	}
+	return metadata.Frame{}, nil, nil, fmt.Errorf("no PNG album art found")
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+const (
+	pngColorRGB  = 2
+	pngColorRGBA = 6
+)
+
+// pngColorType returns the color type of a PNG from its IHDR chunk, or an
+// error if the picture is not a non-interlaced 8-bit RGB or RGBA one.
+func pngColorType(data []byte) (byte, error) {
+	if len(data) < 33 || string(data[12:16]) != "IHDR" {
+		return 0, fmt.Errorf("invalid PNG picture")
+	}
+	depth, colorType, interlace := data[24], data[25], data[28]
+	if depth != 8 || (colorType != pngColorRGB && colorType != pngColorRGBA) || interlace != 0 {
+		return 0, fmt.Errorf("album art is a PNG of color type %d at %d bits, interlace %d; only non-interlaced 8-bit RGB or RGBA pictures are supported", colorType, depth, interlace)
+	}
+	return colorType, nil
+}
+
+// encodePNG returns original with its image data replaced by img. IHDR and
+// every other chunk are copied as they were, and the new data is split into
+// IDAT chunks of the size the original used.
+func encodePNG(original []byte, img *image.NRGBA) ([]byte, error) {
+	colorType, err := pngColorType(original)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pngImageData(img, colorType)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), pngSignature...)
+	written := false
+	for pos := len(pngSignature); pos+12 <= len(original); {
+		length := int(binary.BigEndian.Uint32(original[pos:]))
+		end := pos + 12 + length
+		if end > len(original) {
+			return nil, fmt.Errorf("invalid PNG chunk at offset %d", pos)
+		}
+		if string(original[pos+4:pos+8]) != "IDAT" {
+			out = append(out, original[pos:end]...)
+		} else if !written {
+			// The first chunk's size is the one the original encoder
+			// split its data at.
+			size := length
+			if size == 0 {
+				size = len(data)
+			}
+			for i := 0; i < len(data); i += size {
+				out = appendPNGChunk(out, "IDAT", data[i:min(i+size, len(data))])
+			}
+			written = true
+		}
+		pos = end
+	}
+	if !written {
+		return nil, fmt.Errorf("PNG picture has no image data")
+	}
+	return out, nil
+}
+
+func appendPNGChunk(out []byte, kind string, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	start := len(out)
+	out = append(out, kind...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// pngImageData returns the compressed image data of img in the given color
+// type. Every row gets the filter whose output has the smallest sum of
+// absolute values, the heuristic of the PNG specification and of most
+// encoders.
+func pngImageData(img *image.NRGBA, colorType byte) ([]byte, error) {
+	bpp := 4
+	if colorType == pngColorRGB {
+		bpp = 3
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	previous := make([]byte, width*bpp)
+	row := make([]byte, width*bpp)
+	filtered := make([][]byte, 5)
+	for f := range filtered {
+		filtered[f] = make([]byte, 1+width*bpp)
+		filtered[f][0] = byte(f)
+	}
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	for y := 0; y < height; y++ {
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			copy(row[x*bpp:x*bpp+bpp], pix[x*4:x*4+bpp])
+		}
+
+		best, bestSum := 0, -1
+		for f := range filtered {
+			sum := 0
+			for i, v := range row {
+				var a, c byte
+				if i >= bpp {
+					a, c = row[i-bpp], previous[i-bpp]
+				}
+				b := previous[i]
+				var out byte
+				switch f {
+				case 0:
+					out = v
+				case 1:
+					out = v - a
+				case 2:
+					out = v - b
+				case 3:
+					out = v - byte((int(a)+int(b))/2)
+				case 4:
+					out = v - paeth(a, b, c)
+				}
+				filtered[f][1+i] = out
+				if d := int(int8(out)); d < 0 {
+					sum -= d
+				} else {
+					sum += d
+				}
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = f, sum
+			}
+		}
+		if _, err := w.Write(filtered[best]); err != nil {
+			return nil, fmt.Errorf("failed to encode picture: %w", err)
+		}
+		previous, row = row, previous
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode picture: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(bounds)
+	draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// pictureCarriers lists the offsets in img.Pix of the red, green and blue
+// bytes of every pixel.
+func pictureCarriers(img *image.NRGBA) []int {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	carriers := make([]int, 0, width*height*3)
+	for y := 0; y < height; y++ {
+		row := y * img.Stride
+		for x := 0; x < width; x++ {
+			carriers = append(carriers, row+x*4, row+x*4+1, row+x*4+2)
+		}
+	}
+	return carriers
+}
+
+// pictureLayout orders the payload carriers of a region of n carriers.
+func pictureLayout(n int, h *Header, stegoKey string) ([]int, error) {
+	if n < headerBits(h.Deniable) {
+		return nil, fmt.Errorf("picture too small for parameter header")
+	}
+
+	// As with the sample methods, asking for nLsb 8 makes every carrier
+	// after the header available.
+	order, err := utils.GeneratePositions(stegoKey, h.UseRandomSeed, n-headerBits(h.Deniable), 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+	return order, nil
+}
+
+func pictureFits(h *Header, n int) bool {
+	return n >= headerBits(h.Deniable) && (n-headerBits(h.Deniable))*h.NLsb >= h.PayloadLength*8
+}
+
+// findPictureRegion returns the header written with stegoKey and the
+// carriers of its region.
+func findPictureRegion(img *image.NRGBA, stegoKey string) (*Header, []int, error) {
+	carriers := pictureCarriers(img)
+	read := func(start int) (*Header, error) {
+		return readPictureHeader(img, carriers[start:], stegoKey)
+	}
+	fits := func(h *Header, start, end int) bool {
+		return pictureFits(h, end-start)
+	}
+	header, start, end, err := findRegion(len(carriers), read, fits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, carriers[start:end], nil
+}
+
+func readPictureHeader(img *image.NRGBA, carriers []int, stegoKey string) (*Header, error) {
+	if len(carriers) < HeaderBits {
+		return nil, fmt.Errorf("picture too small for parameter header")
+	}
+
+	bits := make([]bool, min(len(carriers), headerBits(true)))
+	for i := range bits {
+		bits[i] = img.Pix[carriers[i]]&0x01 == 1
+	}
+
+	header, err := ParseHeader(BitsToBytes(bits), stegoKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter header: %w", err)
+	}
+	if header.MethodID != APICID {
+		return nil, fmt.Errorf("header belongs to method %d", header.MethodID)
+	}
+	return header, nil
+}
@@ -0,0 +1,182 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"audio-steganography-lsb/pkg/metadata"
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withPicture adds an APIC frame holding the given image file to the cover.
+func withPicture(t *testing.T, path, mimeType string) []byte {
+	t.Helper()
+	image, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return withPictureData(t, image, mimeType)
+}
+
+func withPictureData(t *testing.T, image []byte, mimeType string) []byte {
+	t.Helper()
+	body := append([]byte("\x00"+mimeType+"\x00\x03Front cover\x00"), image...)
+	cover, err := metadata.ReplaceFrames(readCover(t), nil, metadata.Frame{ID: "APIC", Body: body})
+	require.NoError(t, err)
+	return cover
+}
+
+func TestAPICRoundTrip(t *testing.T) {
+	cover := withPicture(t, "../../test/test_png.png", "image/png")
+	coverStream, err := mp3frame.Parse(cover)
+	require.NoError(t, err)
+	_, _, coverImg, err := findPicture(cover)
+	require.NoError(t, err)
+
+	for _, params := range []*Params{
+		{StegoKey: "apickey", NLsb: 1},
+		{StegoKey: "apickey", NLsb: 3, UseRandomSeed: true},
+	} {
+		capacity, err := APIC{}.Capacity(cover, params)
+		require.NoError(t, err)
+		assert.Equal(t, (222*227*3-HeaderBits)*params.NLsb/8, capacity)
+
+		payload := make([]byte, capacity)
+		for i := range payload {
+			payload[i] = byte(i*13 + 5)
+		}
+		stego, err := APIC{}.Embed(cover, payload, params)
+		require.NoError(t, err)
+
+		stream, err := mp3frame.Parse(stego)
+		require.NoError(t, err)
+		assert.Equal(t, cover[coverStream.ID3v2Size:], stego[stream.ID3v2Size:])
+
+		_, picture, img, err := findPicture(stego)
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", picture.MIMEType)
+		_, err = png.Decode(bytes.NewReader(picture.Data))
+		require.NoError(t, err)
+		mask := byte(1)<<params.NLsb - 1
+		for i := range img.Pix {
+			require.Equal(t, coverImg.Pix[i]&^mask, img.Pix[i]&^mask)
+		}
+
+		detected, err := Detect(stego, params.StegoKey)
+		require.NoError(t, err)
+		assert.Equal(t, APICID, detected.ID())
+		assert.False(t, APIC{}.Detect(stego, "wrongkey"))
+
+		extracted, err := APIC{}.Extract(stego, params.StegoKey)
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+
+		_, err = APIC{}.Embed(cover, make([]byte, capacity+1), params)
+		assert.Error(t, err)
+	}
+}
+
+func TestAPICRequiresPNG(t *testing.T) {
+	params := &Params{StegoKey: "key", NLsb: 1}
+
+	_, err := APIC{}.Capacity(withPicture(t, "../../test/test_image.jpg", "image/jpeg"), params)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "JPEG")
+
+	_, err = APIC{}.Capacity(readCover(t), params)
+	assert.Error(t, err)
+
+	_, err = APIC{}.Capacity(readCover(t)[138:], params)
+	assert.Error(t, err)
+}
+
+// pngChunks lists the type of every chunk of a PNG and the data of every
+// chunk but IDAT.
+func pngChunks(t *testing.T, data []byte) (kinds []string, other [][]byte) {
+	t.Helper()
+	require.True(t, bytes.HasPrefix(data, pngSignature))
+	for pos := len(pngSignature); pos < len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if len(kinds) == 0 || kind != "IDAT" || kinds[len(kinds)-1] != "IDAT" {
+			kinds = append(kinds, kind)
+		}
+		if kind != "IDAT" {
+			other = append(other, data[pos:pos+12+length])
+		}
+		pos += 12 + length
+	}
+	return kinds, other
+}
+
+func TestAPICKeepsPNGFormat(t *testing.T) {
+	rgba, err := os.ReadFile("../../test/test_png.png")
+	require.NoError(t, err)
+
+	// The same picture made opaque, which encodes as RGB, with a text
+	// chunk.
+	decoded, err := png.Decode(bytes.NewReader(rgba))
+	require.NoError(t, err)
+	img := toNRGBA(decoded)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	rgb := buf.Bytes()
+	text := appendPNGChunk(nil, "tEXt", []byte("Comment\x00front cover"))
+	rgb = append(append(append([]byte(nil), rgb[:33]...), text...), rgb[33:]...)
+
+	for name, data := range map[string][]byte{"rgba": rgba, "rgb": rgb} {
+		t.Run(name, func(t *testing.T) {
+			cover := withPictureData(t, data, "image/png")
+			payload := []byte("the picture keeps its format")
+			stego, err := APIC{}.Embed(cover, payload, &Params{StegoKey: "apickey", NLsb: 1})
+			require.NoError(t, err)
+
+			_, picture, _, err := findPicture(stego)
+			require.NoError(t, err)
+			// IHDR and every other chunk come through as they were, and
+			// the image data does not grow much.
+			wantKinds, wantOther := pngChunks(t, data)
+			kinds, other := pngChunks(t, picture.Data)
+			assert.Equal(t, wantKinds, kinds)
+			assert.Equal(t, wantOther, other)
+			assert.Less(t, len(picture.Data), len(data)*11/10)
+
+			extracted, err := APIC{}.Extract(stego, "apickey")
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+		})
+	}
+}
+
+func TestAPICRejectsUnsupportedPNG(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 64)
+	paletted := image.NewPaletted(rect, color.Palette{color.Black, color.White})
+	wide := image.NewNRGBA64(rect)
+	for i := range wide.Pix {
+		wide.Pix[i] = byte(i)
+	}
+
+	for name, img := range map[string]image.Image{
+		"gray":     image.NewGray(rect),
+		"paletted": paletted,
+		"16-bit":   wide,
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, img))
+		cover := withPictureData(t, buf.Bytes(), "image/png")
+
+		_, err := APIC{}.Capacity(cover, &Params{StegoKey: "key", NLsb: 1})
+		assert.ErrorContains(t, err, "only non-interlaced 8-bit RGB or RGBA pictures are supported", name)
+		_, err = APIC{}.Embed(cover, []byte("x"), &Params{StegoKey: "key", NLsb: 1})
+		assert.Error(t, err, name)
+	}
+}
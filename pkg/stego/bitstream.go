@@ -0,0 +1,444 @@
+package stego
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+	"audio-steganography-lsb/pkg/utils"
+)
+
+const BitstreamID byte = 1
+
+// Bitstream embeds directly into the LSBs of MP3 frame bytes, avoiding any
+// decode/re-encode cycle.
+type Bitstream struct{}
+
+func init() {
+	Register(Bitstream{})
+}
+
+func (Bitstream) Name() string { return "bitstream" }
+
+func (Bitstream) ID() byte { return BitstreamID }
+
+func (Bitstream) Capacity(cover []byte, params *Params) (int, error) {
+	if params.Resync {
+		return resyncCapacity(cover, params.NLsb)
+	}
+	positions := findEmbeddablePositions(cover)
+	if len(positions) < headerBits(params.Deniable) {
+		return 0, fmt.Errorf("not enough embeddable positions for parameter header")
+	}
+
+	dataPositions, err := utils.GeneratePositions(params.StegoKey, params.UseRandomSeed, len(positions)-headerBits(params.Deniable), params.NLsb)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	return len(dataPositions) * params.NLsb / 8, nil
+}
+
+func (b Bitstream) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	return b.EmbedMany(cover, [][]byte{payload}, []string{params.StegoKey}, params)
+}
+
+func (Bitstream) EmbedMany(cover []byte, payloads [][]byte, keys []string, params *Params) ([]byte, error) {
+	positions := findEmbeddablePositions(cover)
+	if len(positions) < HeaderBits {
+		return nil, fmt.Errorf("not enough embeddable positions for parameter header")
+	}
+
+	stego := make([]byte, len(cover))
+	copy(stego, cover)
+
+	noise, err := fillNoise(params, keys, len(positions))
+	if err != nil {
+		return nil, err
+	}
+	if noise != nil {
+		fillPositions(stego, positions, params.NLsb, noise)
+	}
+
+	if params.Resync {
+		if len(payloads) > 1 || params.Deniable || params.MatrixEmbedding || params.UseRandomSeed {
+			return nil, fmt.Errorf("resync blocks cannot be combined with several payloads, deniable mode, matrix embedding or random positions")
+		}
+		write := replaceLSBs
+		if params.Matching {
+			if write, err = lsbMatcher(len(positions)); err != nil {
+				return nil, err
+			}
+		}
+		header := &Header{
+			MethodID:      BitstreamID,
+			NLsb:          params.NLsb,
+			PayloadLength: len(payloads[0]),
+			Matching:      params.Matching,
+		}
+		if err := writeResync(stego, header, payloads[0], keys[0], write); err != nil {
+			return nil, err
+		}
+		return matchFill(stego, cover, positions, params, noise != nil)
+	}
+
+	headers := make([]*Header, len(payloads))
+	regions := make([][2]int, len(payloads))
+	plan := func(order []int, first int) (int, error) {
+		from := first
+		remaining := 0
+		for _, payload := range payloads {
+			remaining += len(payload)
+		}
+		for _, i := range order {
+			payload := payloads[i]
+			header := &Header{
+				MethodID:      BitstreamID,
+				NLsb:          params.NLsb,
+				UseRandomSeed: params.UseRandomSeed,
+				PayloadLength: len(payload),
+				Deniable:      hidesRegions(params, keys),
+				Matching:      params.Matching,
+			}
+
+			if params.MatrixEmbedding {
+				// The code is sized for this payload's share of what is
+				// left, so later payloads still find room.
+				start, end := utils.Region(len(positions), first, utils.Regions-first)
+				share := bitstreamCapacity(header, end-start)
+				if remaining > 0 {
+					share = share * len(payload) / remaining
+				}
+				header.Param = matrixK(len(payload)*8, share)
+			}
+			remaining -= len(payload)
+
+			count := utils.RegionBlocks(len(positions), first, func(start, end int) bool {
+				return bitstreamFits(header, end-start)
+			})
+			if count == 0 {
+				start, end := utils.Region(len(positions), first, utils.Regions-first)
+				return 0, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, bitstreamCapacity(header, end-start))
+			}
+			headers[i] = header
+			regions[i][0], regions[i][1] = utils.Region(len(positions), first, count)
+			first += count
+		}
+		return first - from, nil
+	}
+	if err := placeRegions(len(payloads), hidesRegions(params, keys), plan); err != nil {
+		return nil, err
+	}
+
+	for i, payload := range payloads {
+		start, end := regions[i][0], regions[i][1]
+		if err := writeBitstream(stego, positions[start:end], headers[i], payload, keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return matchFill(stego, cover, positions, params, noise != nil)
+}
+
+// matchFill redoes every position of a filled cover as a single LSB
+// matching step from the cover byte to the low bits the fill and payloads
+// left, so that with Matching the fill leaves no replacement traces either.
+// Matching the fill and then the payload would move some bytes twice.
+func matchFill(stego, cover []byte, positions []int, params *Params, filled bool) ([]byte, error) {
+	if !filled || !params.Matching {
+		return stego, nil
+	}
+	write, err := lsbMatcher(len(positions))
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]bool, params.NLsb)
+	for _, pos := range positions {
+		for i := range bits {
+			bits[i] = stego[pos]>>i&1 == 1
+		}
+		stego[pos] = cover[pos]
+		write(stego, pos, bits)
+	}
+	return stego, nil
+}
+
+// writeBitstream writes the header and payload into the LSBs of the
+// embeddable positions of one region.
+func writeBitstream(stego []byte, positions []int, header *Header, payload []byte, stegoKey string) error {
+	headerBytes, err := header.Marshal(stegoKey)
+	if err != nil {
+		return err
+	}
+	if header.Deniable {
+		payload = whiten(payload, stegoKey, header.Nonce, HeaderSize)
+	}
+	bits := BytesToBits(payload)
+	dataPositions, err := utils.GeneratePositions(stegoKey, header.UseRandomSeed, len(positions)-len(headerBytes)*8, header.NLsb)
+	if err != nil {
+		return fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	write := replaceLSBs
+	if header.Matching {
+		if write, err = lsbMatcher(len(headerBytes)*8 + len(dataPositions)); err != nil {
+			return err
+		}
+	}
+
+	for i, bit := range BytesToBits(headerBytes) {
+		write(stego, positions[i], []bool{bit})
+	}
+
+	fmt.Printf("Embedding %d bits into %d positions using %d LSBs (capacity %d bits)\n", len(bits), len(dataPositions), header.NLsb, len(dataPositions)*header.NLsb)
+
+	carriers := positions[len(headerBytes)*8:]
+	if k := header.Param; k > 0 {
+		// Writing the code word slot by slot only changes the slots
+		// matrixEmbed flipped.
+		var changes int
+		cover := readSlots(stego, carriers, dataPositions, header.NLsb, matrixSlots(len(bits), k))
+		bits, changes = matrixEmbed(cover, bits, k)
+		fmt.Printf("Matrix embedding with k=%d: %d slots changed\n", k, changes)
+	}
+	for i, posIndex := range dataPositions {
+		start := i * header.NLsb
+		if start >= len(bits) {
+			break
+		}
+		end := start + header.NLsb
+		if end > len(bits) {
+			end = len(bits)
+		}
+		write(stego, carriers[posIndex], bits[start:end])
+	}
+	return nil
+}
+
+// lsbWriter sets the low bits of data[pos] to bits, LSB first.
+type lsbWriter func(data []byte, pos int, bits []bool)
+
+// replaceLSBs is plain LSB replacement: clear the bits, then set them.
+func replaceLSBs(data []byte, pos int, bits []bool) {
+	for i, bit := range bits {
+		setBits(data, pos, 1<<i, bit)
+	}
+}
+
+// lsbMatcher returns an lsbWriter doing LSB matching for up to n writes.
+// Replacement only ever turns an even byte into the next odd one and back,
+// which is what chi-square and RS analysis pick up; matching moves to the
+// nearest byte with the wanted low bits in either direction instead, and
+// breaks ties at random.
+func lsbMatcher(n int) (lsbWriter, error) {
+	coins := make([]byte, (n+7)/8)
+	if _, err := rand.Read(coins); err != nil {
+		return nil, fmt.Errorf("failed to generate random signs: %w", err)
+	}
+	next := 0
+	return func(data []byte, pos int, bits []bool) {
+		up := coins[next/8]>>(next%8)&1 == 1
+		next++
+		data[pos] = matchLSBs(data[pos], bits, up)
+	}, nil
+}
+
+// matchLSBs returns the byte closest to b whose low bits are bits, going up
+// on a tie if up is set. The high nibble is never changed, so 0x00 and 0xFF
+// do not wrap around and no frame sync can appear; a single bit always
+// moves b by exactly one.
+func matchLSBs(b byte, bits []bool, up bool) byte {
+	mask := byte(1)<<len(bits) - 1
+	var value byte
+	for i, bit := range bits {
+		if bit {
+			value |= 1 << i
+		}
+	}
+	if b&mask == value {
+		return b
+	}
+
+	low := int(b & 0x0F)
+	best, bestDistance := -1, 0
+	for candidate := 0; candidate < 16; candidate++ {
+		if byte(candidate)&mask != value {
+			continue
+		}
+		distance := candidate - low
+		if distance < 0 {
+			distance = -distance
+		}
+		if best < 0 || distance < bestDistance || (distance == bestDistance && (candidate > low) == up) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return b&0xF0 | byte(best)
+}
+
+// bitstreamCapacity returns how many payload bits n embeddable positions
+// hold after the header, mirroring the count GeneratePositions hands out.
+func bitstreamCapacity(h *Header, n int) int {
+	if n < headerBits(h.Deniable) {
+		return 0
+	}
+	return (n - headerBits(h.Deniable)) * h.NLsb / 8 * h.NLsb
+}
+
+func bitstreamFits(h *Header, n int) bool {
+	return n >= headerBits(h.Deniable) && bitstreamCapacity(h, n) >= matrixSlots(h.PayloadLength*8, h.Param)
+}
+
+// readSlots returns the first n payload slots, bit i of every data position
+// in order, as plain embedding fills them.
+func readSlots(stego []byte, carriers, dataPositions []int, nLsb, n int) []bool {
+	slots := make([]bool, 0, n+nLsb)
+	for _, posIndex := range dataPositions {
+		if len(slots) >= n {
+			break
+		}
+		for i := 0; i < nLsb; i++ {
+			slots = append(slots, (stego[carriers[posIndex]]>>i)&1 == 1)
+		}
+	}
+	return slots[:n]
+}
+
+// Extract reads the header at the start of a region and falls back to
+// scanning for resync blocks when there is none.
+func (Bitstream) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	positions := findEmbeddablePositions(stego)
+	header, positions, err := findBitstreamRegion(stego, positions, stegoKey)
+	if err != nil {
+		if payload, resyncErr := extractResync(stego, stegoKey); resyncErr == nil || errors.As(resyncErr, new(*PartialError)) {
+			return payload, resyncErr
+		}
+		return nil, err
+	}
+
+	dataPositions, err := utils.GeneratePositions(stegoKey, header.UseRandomSeed, len(positions)-headerBits(header.Deniable), header.NLsb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	needed := header.PayloadLength * 8
+	bits := readSlots(stego, positions[headerBits(header.Deniable):], dataPositions, header.NLsb, matrixSlots(needed, header.Param))
+	if header.Param > 0 {
+		bits = matrixExtract(bits, header.Param, needed)
+	}
+
+	if header.Deniable {
+		return whiten(BitsToBytes(bits), stegoKey, header.Nonce, HeaderSize), nil
+	}
+	return BitsToBytes(bits), nil
+}
+
+// fillPositions replaces the low nLsb bits of every embeddable position
+// with noise before the payloads are written, so positions no payload uses
+// look like those that carry one.
+func fillPositions(stego []byte, positions []int, nLsb int, noise []byte) {
+	mask := byte(1)<<nLsb - 1
+	for i, pos := range positions {
+		stego[pos] = stego[pos]&^mask | noise[i]&mask
+	}
+}
+
+func (Bitstream) Detect(stego []byte, stegoKey string) bool {
+	if _, _, err := findBitstreamRegion(stego, findEmbeddablePositions(stego), stegoKey); err == nil {
+		return true
+	}
+	_, _, err := readResync(stego, stegoKey)
+	return err == nil
+}
+
+// findBitstreamRegion returns the header written with stegoKey and the
+// embeddable positions of its region.
+func findBitstreamRegion(stego []byte, positions []int, stegoKey string) (*Header, []int, error) {
+	if len(positions) < HeaderBits {
+		return nil, nil, fmt.Errorf("not enough embeddable positions")
+	}
+
+	read := func(start int) (*Header, error) {
+		return readBitstreamHeader(stego, positions[start:], stegoKey)
+	}
+	fits := func(h *Header, start, end int) bool {
+		return bitstreamFits(h, end-start)
+	}
+	header, start, end, err := findRegion(len(positions), read, fits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, positions[start:end], nil
+}
+
+func readBitstreamHeader(stego []byte, positions []int, stegoKey string) (*Header, error) {
+	if len(positions) < HeaderBits {
+		return nil, fmt.Errorf("not enough embeddable positions")
+	}
+
+	bits := make([]bool, min(len(positions), headerBits(true)))
+	for i := range bits {
+		bits[i] = stego[positions[i]]&0x01 == 1
+	}
+
+	header, err := ParseHeader(BitsToBytes(bits), stegoKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter header: %w", err)
+	}
+	if header.MethodID != BitstreamID {
+		return nil, fmt.Errorf("header belongs to method %d", header.MethodID)
+	}
+	return header, nil
+}
+
+func setBits(data []byte, pos int, mask byte, bit bool) {
+	data[pos] &^= mask
+	if bit {
+		data[pos] |= mask
+	}
+}
+
+// findEmbeddablePositions lists the byte offsets that may carry payload bits.
+// Only audio frames are used: ID3v2, ID3v1 and APE tags, the Xing/Info or
+// VBRI frame and anything between frames are never touched, and data that
+// does not parse as MP3 has no positions at all.
+//
+// Embedding only ever touches the low nibble of a byte, so a byte is skipped
+// whenever a frame sync could appear at or just before it regardless of its
+// low nibble. That keeps the list identical on the cover and the stego file.
+func findEmbeddablePositions(mp3Data []byte) []int {
+	stream, err := mp3frame.Parse(mp3Data)
+	if err != nil {
+		return nil
+	}
+
+	frames := stream.Frames
+	if stream.Tag != nil {
+		frames = frames[1:]
+	}
+
+	var positions []int
+	for _, f := range frames {
+		for i := f.Offset; i < f.Offset+f.Length; i++ {
+			nearSync := false
+			for j := i - 3; j <= i; j++ {
+				if potentialSync(mp3Data, j) {
+					nearSync = true
+					break
+				}
+			}
+			if nearSync {
+				continue
+			}
+
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
+
+func potentialSync(data []byte, i int) bool {
+	return i >= 0 && i+1 < len(data) && data[i]&0xF0 == 0xF0 && data[i+1]&0xE0 == 0xE0
+}
@@ -0,0 +1,399 @@
+package stego
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/fec"
+)
+
+const DSSSID byte = 11
+
+// DSSS is direct-sequence spread spectrum in the FFT magnitudes of segments
+// of dsssSegment sample frames. Each segment carries dsssBands code bits,
+// one per band of the range dsssLowHz to dsssHighHz. A keyed ±1 PN sequence
+// over the bins of a band is correlated with their log magnitudes; the
+// correlation is pushed to at least +dsssStrength for a 1 and
+// -dsssStrength for a 0 by scaling every bin up or down by the same small
+// factor along the PN sequence. Bands that already correlate strongly
+// enough are left alone, which cancels most of the interference from the
+// music itself.
+//
+// The change to a bin is proportional to its level and capped at
+// dsssMaxShift, so the added noise follows the spectrum of the music and
+// stays well below it, a crude stand-in for a masking threshold. Header
+// and payload are protected by the convolutional code of package fec and
+// interleaved, and a correlation detector feeds soft decisions to its
+// Viterbi decoder, so the payload survives re-encoding to 128 kbps MP3.
+type DSSS struct{}
+
+const (
+	dsssSegment  = 4096
+	dsssBands    = 8
+	dsssLowHz    = 300
+	dsssHighHz   = 6000
+	dsssStrength = 0.15
+	dsssMaxShift = 0.4
+	// dsssRamp frames at both ends of a segment fade the change in and
+	// out, so segment boundaries do not click.
+	dsssRamp = 256
+	// dsssRowLength is the interleaver row length: code bits next to each
+	// other end up in different segments, and a burst of bad segments
+	// becomes errors dsssRowLength code bits apart.
+	dsssRowLength = 16
+
+	// Extract looks for the header every dsssSearchStep frames up to
+	// dsssSearch frames either side of the first loud frame.
+	dsssSearch     = dsssSegment
+	dsssSearchStep = dsssSegment / 16
+)
+
+func init() {
+	Register(DSSS{})
+}
+
+func (DSSS) Name() string { return "dsss" }
+
+func (DSSS) ID() byte { return DSSSID }
+
+// dsssCodeSegments returns how many segments the code bits of n message
+// bits take.
+func dsssCodeSegments(n int) int {
+	return (fec.EncodedLen(n) + dsssBands - 1) / dsssBands
+}
+
+// dsssCapacity returns how many payload bytes fit in the segments of mono
+// from frame start on.
+func dsssCapacity(mono []float64, start int) int {
+	segments := (len(mono)-start)/dsssSegment - dsssCodeSegments(HeaderBits)
+	capacity := (segments*dsssBands/2 - fec.Tail) / 8
+	if capacity < 0 {
+		return 0
+	}
+	return capacity
+}
+
+func (DSSS) Capacity(cover []byte, params *Params) (int, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return 0, err
+	}
+	if err := dsssCheckRate(pcm); err != nil {
+		return 0, err
+	}
+	mono := mixdown(pcm)
+	start := firstLoud(mono)
+	if (len(mono)-start)/dsssSegment < dsssCodeSegments(HeaderBits) {
+		return 0, fmt.Errorf("not enough audio for parameter header")
+	}
+	return dsssCapacity(mono, start), nil
+}
+
+func dsssCheckRate(pcm *audio.PCM) error {
+	if pcm.SampleRate < 2*dsssHighHz {
+		return fmt.Errorf("dsss method needs a sample rate of at least %d Hz", 2*dsssHighHz)
+	}
+	return nil
+}
+
+func (DSSS) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return nil, err
+	}
+	if pcm.Channels > maxChannels {
+		return nil, fmt.Errorf("unsupported channel count: %d", pcm.Channels)
+	}
+	if err := dsssCheckRate(pcm); err != nil {
+		return nil, err
+	}
+
+	mono := mixdown(pcm)
+	start := firstLoud(mono)
+	if (len(mono)-start)/dsssSegment < dsssCodeSegments(HeaderBits) {
+		return nil, fmt.Errorf("not enough audio for parameter header")
+	}
+	if capacity := dsssCapacity(mono, start); len(payload) > capacity {
+		return nil, fmt.Errorf("data too large: need %d bytes, capacity is %d bytes", len(payload), capacity)
+	}
+
+	header := &Header{
+		MethodID:      DSSSID,
+		NLsb:          params.NLsb,
+		UseRandomSeed: params.UseRandomSeed,
+		PayloadLength: len(payload),
+		Channels:      pcm.Channels,
+	}
+
+	// The header and the payload are coded separately, so the header can
+	// be decoded before the payload length is known. Each starts on a
+	// fresh segment.
+	headerBytes, err := header.Marshal(params.StegoKey)
+	if err != nil {
+		return nil, err
+	}
+	code := dsssCode(BytesToBits(headerBytes))
+	code = append(code, make([]bool, dsssCodeSegments(HeaderBits)*dsssBands-len(code))...)
+	code = append(code, dsssCode(BytesToBits(payload))...)
+
+	out := pcm.Clone()
+	bands := dsssBandBins(pcm.SampleRate)
+	for s := 0; s*dsssBands < len(code); s++ {
+		end := (s + 1) * dsssBands
+		if end > len(code) {
+			end = len(code)
+		}
+		spreadSegment(out, start+s*dsssSegment, bands, dsssPN(params.StegoKey, s, bands), code[s*dsssBands:end])
+	}
+
+	bitrate := params.Bitrate
+	if bitrate == 0 {
+		bitrate = coverBitrate(cover)
+	}
+	data, err := encodeOutput(cover, out, params.OutputFormat, bitrate)
+	if err != nil {
+		return nil, err
+	}
+	if params.CopyTags {
+		data = withCoverTags(cover, data)
+	}
+	return data, nil
+}
+
+// dsssCode encodes and interleaves message bits.
+func dsssCode(bits []bool) []bool {
+	code := fec.Encode(bits)
+	return fec.Interleave(code, (len(code)+dsssRowLength-1)/dsssRowLength)
+}
+
+// dsssDecode undoes dsssCode on soft decisions for n message bits.
+func dsssDecode(soft []float64, n int) []bool {
+	soft = soft[:fec.EncodedLen(n)]
+	return fec.Decode(fec.Deinterleave(soft, (len(soft)+dsssRowLength-1)/dsssRowLength), n)
+}
+
+// dsssBandBins returns the first bin of every band and, last, the bin after
+// the final band.
+func dsssBandBins(sampleRate int) []int {
+	low := dsssLowHz * dsssSegment / sampleRate
+	high := dsssHighHz * dsssSegment / sampleRate
+	bins := make([]int, dsssBands+1)
+	for b := range bins {
+		bins[b] = low + b*(high-low)/dsssBands
+	}
+	return bins
+}
+
+// dsssPN returns the ±1 PN chips for the bins of segment s, indexed from
+// the first bin of the first band.
+func dsssPN(stegoKey string, s int, bands []int) []float64 {
+	n := bands[dsssBands] - bands[0]
+	stream := keystream(fmt.Sprintf("dsss:%d:", s), stegoKey, (n+7)/8)
+	chips := make([]float64, n)
+	for i := range chips {
+		chips[i] = -1
+		if stream[i/8]>>(i%8)&1 == 1 {
+			chips[i] = 1
+		}
+	}
+	return chips
+}
+
+// dsssCorrelations returns the correlation of every band of a spectrum's
+// log magnitudes with the PN chips. The band's mean log magnitude is taken
+// out first: the chips of a band rarely sum to zero, and the mean is far
+// larger than anything embedding adds.
+func dsssCorrelations(spectrum []complex128, bands []int, chips []float64) []float64 {
+	y := make([]float64, dsssBands)
+	for b := range y {
+		n := float64(bands[b+1] - bands[b])
+		var mean float64
+		for k := bands[b]; k < bands[b+1]; k++ {
+			mean += math.Log(cmplx.Abs(spectrum[k])+1) / n
+		}
+		for k := bands[b]; k < bands[b+1]; k++ {
+			y[b] += chips[k-bands[0]] * (math.Log(cmplx.Abs(spectrum[k])+1) - mean) / n
+		}
+	}
+	return y
+}
+
+// dsssGain returns how much the correlation of band b moves per unit of
+// log-magnitude shift along its chips: 1 minus the square of the chips'
+// mean, since the shift also moves the band mean.
+func dsssGain(bands []int, chips []float64, b int) float64 {
+	var mean float64
+	for k := bands[b]; k < bands[b+1]; k++ {
+		mean += chips[k-bands[0]]
+	}
+	mean /= float64(bands[b+1] - bands[b])
+	return 1 - mean*mean
+}
+
+// spreadSegment writes code bits into the bands of the segment at frame
+// start of every channel.
+func spreadSegment(pcm *audio.PCM, start int, bands []int, chips []float64, code []bool) {
+	spectra := make([][]complex128, pcm.Channels)
+	mixed := make([]complex128, dsssSegment)
+	for ch := range spectra {
+		x := make([]complex128, dsssSegment)
+		for j := range x {
+			x[j] = complex(float64(pcm.Samples[(start+j)*pcm.Channels+ch]), 0)
+		}
+		fft(x, false)
+		spectra[ch] = x
+		for k := range x {
+			mixed[k] += x[k] / complex(float64(pcm.Channels), 0)
+		}
+	}
+
+	// Scaling every channel by the same gains scales the mixdown the
+	// detector sees by them too.
+	gains := make([]float64, dsssSegment/2+1)
+	for k := range gains {
+		gains[k] = 1
+	}
+	y := dsssCorrelations(mixed, bands, chips)
+	for b, bit := range code {
+		target := -dsssStrength
+		if bit {
+			target = dsssStrength
+		}
+		if y[b]*target >= dsssStrength*dsssStrength {
+			continue
+		}
+		shift := (target - y[b]) / dsssGain(bands, chips, b)
+		shift = math.Max(-dsssMaxShift, math.Min(dsssMaxShift, shift))
+		for k := bands[b]; k < bands[b+1]; k++ {
+			gains[k] = math.Exp(shift * chips[k-bands[0]])
+		}
+	}
+
+	for ch, x := range spectra {
+		for k := 1; k < dsssSegment/2; k++ {
+			x[k] *= complex(gains[k]-1, 0)
+			x[dsssSegment-k] = cmplx.Conj(x[k])
+		}
+		x[0], x[dsssSegment/2] = 0, 0
+		fft(x, true)
+		for j := range x {
+			fade := 1.0
+			if j < dsssRamp {
+				fade = float64(j) / dsssRamp
+			} else if dsssSegment-j < dsssRamp {
+				fade = float64(dsssSegment-j) / dsssRamp
+			}
+			i := (start+j)*pcm.Channels + ch
+			pcm.Samples[i] = clampSample(int(math.Round(float64(pcm.Samples[i]) + fade*real(x[j])/dsssSegment)))
+		}
+	}
+}
+
+func (DSSS) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return nil, err
+	}
+	r, err := findDSSS(pcm, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.header.Channels != 0 && r.header.Channels != pcm.Channels {
+		return nil, fmt.Errorf("header records %d channels but the audio has %d", r.header.Channels, pcm.Channels)
+	}
+
+	n := r.header.PayloadLength * 8
+	first := dsssCodeSegments(HeaderBits)
+	if r.offset+(first+dsssCodeSegments(n))*dsssSegment > len(r.mono) {
+		return nil, fmt.Errorf("payload length %d exceeds the audio", r.header.PayloadLength)
+	}
+	soft := r.soft(first, dsssCodeSegments(n))
+	return BitsToBytes(dsssDecode(soft, n)), nil
+}
+
+func (DSSS) Detect(stego []byte, stegoKey string) bool {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return false
+	}
+	_, err = findDSSS(pcm, stegoKey)
+	return err == nil
+}
+
+// dsssReader reads soft decisions from a mono mixdown, starting offset
+// frames in.
+type dsssReader struct {
+	mono     []float64
+	bands    []int
+	stegoKey string
+	offset   int
+	header   *Header
+	// spectra caches the spectrum of the segment at every frame tried, as
+	// the search revisits them under different offsets.
+	spectra map[int][]complex128
+}
+
+// soft returns the band correlations of count segments from segment first
+// on, scaled so that dsssStrength is 1.
+func (r *dsssReader) soft(first, count int) []float64 {
+	soft := make([]float64, 0, count*dsssBands)
+	for s := first; s < first+count; s++ {
+		start := r.offset + s*dsssSegment
+		spectrum, ok := r.spectra[start]
+		if !ok {
+			spectrum = make([]complex128, dsssSegment)
+			for j := range spectrum {
+				spectrum[j] = complex(r.mono[start+j], 0)
+			}
+			fft(spectrum, false)
+			r.spectra[start] = spectrum
+		}
+		for _, y := range dsssCorrelations(spectrum, r.bands, dsssPN(r.stegoKey, s, r.bands)) {
+			// A silent or badly damaged band must not outvote the rest.
+			soft = append(soft, math.Max(-2, math.Min(2, y/dsssStrength)))
+		}
+	}
+	return soft
+}
+
+// findDSSS looks for the header at the first loud frame and then ever
+// further either side of it.
+func findDSSS(pcm *audio.PCM, stegoKey string) (*dsssReader, error) {
+	if err := dsssCheckRate(pcm); err != nil {
+		return nil, err
+	}
+	r := &dsssReader{
+		mono:     mixdown(pcm),
+		bands:    dsssBandBins(pcm.SampleRate),
+		stegoKey: stegoKey,
+		spectra:  make(map[int][]complex128),
+	}
+	start := firstLoud(r.mono)
+	offsets := []int{start}
+	for d := dsssSearchStep; d <= dsssSearch; d += dsssSearchStep {
+		offsets = append(offsets, start+d, start-d)
+	}
+
+	segments := dsssCodeSegments(HeaderBits)
+	err := fmt.Errorf("not enough audio for parameter header")
+	for _, offset := range offsets {
+		if offset < 0 || offset+segments*dsssSegment > len(r.mono) {
+			continue
+		}
+		r.offset = offset
+		header, parseErr := ParseHeader(BitsToBytes(dsssDecode(r.soft(0, segments), HeaderBits)), stegoKey)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		if header.MethodID != DSSSID {
+			err = fmt.Errorf("header belongs to method %d", header.MethodID)
+			continue
+		}
+		r.header = header
+		return r, nil
+	}
+	return nil, fmt.Errorf("invalid parameter header: %w", err)
+}
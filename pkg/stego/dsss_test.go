@@ -0,0 +1,54 @@
+package stego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSSSRoundTrip(t *testing.T) {
+	cover := wavCover(t)
+	payload := []byte("spread")
+	m, err := Lookup("dsss")
+	require.NoError(t, err)
+
+	capacity, err := m.Capacity(cover, &Params{StegoKey: "pnkey", NLsb: 1})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, capacity, len(payload))
+
+	// 128 kbps is what the payload has to survive in distribution.
+	for _, params := range []*Params{
+		{StegoKey: "pnkey", NLsb: 1, OutputFormat: "wav"},
+		{StegoKey: "pnkey", NLsb: 1, OutputFormat: "mp3", Bitrate: 128},
+	} {
+		t.Run(params.OutputFormat, func(t *testing.T) {
+			stego, err := m.Embed(cover, payload, params)
+			require.NoError(t, err)
+
+			assert.True(t, m.Detect(stego, "pnkey"))
+			assert.False(t, m.Detect(stego, "wrongkey"))
+
+			extracted, err := m.Extract(stego, "pnkey")
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+		})
+	}
+
+	_, err = m.Embed(cover, make([]byte, capacity+1), &Params{StegoKey: "pnkey", NLsb: 1})
+	assert.Error(t, err)
+}
+
+func TestDSSSCorrelationIgnoresLevel(t *testing.T) {
+	bands := dsssBandBins(44100)
+	chips := dsssPN("pnkey", 0, bands)
+	spectrum := make([]complex128, dsssSegment)
+	for k := range spectrum {
+		spectrum[k] = 1000
+	}
+	// A flat spectrum correlates with nothing, however loud it is and
+	// however unbalanced the chips of a band are.
+	for _, y := range dsssCorrelations(spectrum, bands, chips) {
+		assert.InDelta(t, 0, y, 1e-9)
+	}
+}
@@ -0,0 +1,312 @@
+package stego
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/fec"
+	"audio-steganography-lsb/pkg/utils"
+)
+
+const EchoID byte = 9
+
+// Echo hides one bit per segment of echoSegment sample frames in a faint
+// echo of the audio: a delay of echoDelay0 frames for 0 and echoDelay1 for
+// 1, mixed in at echoDecay of the signal's level. The two echoes are
+// cross-faded over echoRamp frames at every segment boundary so no click
+// marks it. A bit is read from the real cepstrum of its segment, which
+// peaks at the delay of the echo.
+//
+// The echo is part of the music rather than its low bits, so it survives
+// re-encoding to MP3. Silence cannot carry it, so embedding starts at the
+// first loud sample. Quiet passages misread about one bit in a hundred and
+// re-encoding misreads more, so, as with DSSS, header and payload are
+// protected by the convolutional code of package fec and decoded from the
+// cepstrum difference as soft decisions. Capacity is about twenty bits per
+// second.
+type Echo struct{}
+
+const (
+	echoSegment = 1024
+	echoRamp    = 128
+	echoDelay0  = 100
+	echoDelay1  = 150
+	echoDecay   = 0.4
+	// echoRowLength is the interleaver row length of the header code; the
+	// payload code is spread by the keyed segment order instead.
+	echoRowLength = 16
+
+	// Extract looks for the header every echoSearchStep frames up to
+	// echoSearch frames either side of the first loud sample, since
+	// re-encoding delays and smears the audio.
+	echoSearch     = 2 * echoSegment
+	echoSearchStep = echoSegment / 16
+
+	// maxEchoSegments bounds the payload segments, whose count is recorded
+	// in the 16-bit Param: re-encoding changes the length of the file, so
+	// extraction cannot derive the count from it.
+	maxEchoSegments = 1<<16 - 1
+)
+
+// echoHeaderSegments is the number of segments the header code takes.
+var echoHeaderSegments = fec.EncodedLen(HeaderBits)
+
+func init() {
+	Register(Echo{})
+}
+
+func (Echo) Name() string { return "echo" }
+
+func (Echo) ID() byte { return EchoID }
+
+func (Echo) Capacity(cover []byte, params *Params) (int, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return 0, err
+	}
+	mono := mixdown(pcm)
+	segments, err := echoPayloadSegments(mono, firstLoud(mono))
+	if err != nil {
+		return 0, err
+	}
+	return echoCapacityBits(segments) / 8, nil
+}
+
+// echoPayloadSegments returns how many segments of mono are left for the
+// payload after a header starting at frame start.
+func echoPayloadSegments(mono []float64, start int) (int, error) {
+	segments := (len(mono)-start)/echoSegment - echoHeaderSegments
+	if segments < 0 {
+		return 0, fmt.Errorf("not enough audio for parameter header")
+	}
+	if segments > maxEchoSegments {
+		segments = maxEchoSegments
+	}
+	return segments, nil
+}
+
+// echoCapacityBits returns how many payload bits the code bits of segments
+// segments hold.
+func echoCapacityBits(segments int) int {
+	if capacity := segments/2 - fec.Tail; capacity > 0 {
+		return capacity
+	}
+	return 0
+}
+
+func (Echo) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return nil, err
+	}
+	if pcm.Channels > maxChannels {
+		return nil, fmt.Errorf("unsupported channel count: %d", pcm.Channels)
+	}
+
+	mono := mixdown(pcm)
+	start := firstLoud(mono)
+	segments, err := echoPayloadSegments(mono, start)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload)*8 > echoCapacityBits(segments) {
+		return nil, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, echoCapacityBits(segments))
+	}
+
+	header := &Header{
+		MethodID:      EchoID,
+		NLsb:          params.NLsb,
+		UseRandomSeed: params.UseRandomSeed,
+		PayloadLength: len(payload),
+		Param:         segments,
+		Channels:      pcm.Channels,
+	}
+	order, err := utils.GeneratePositions(params.StegoKey, params.UseRandomSeed, segments, 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	// Segments without a code bit carry a 0 echo, so the whole file sounds
+	// the same. The header is coded separately, so it can be decoded
+	// before the payload length is known.
+	headerBytes, err := header.Marshal(params.StegoKey)
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]bool, echoHeaderSegments+segments)
+	copy(bits, echoHeaderCode(BytesToBits(headerBytes)))
+	for i, bit := range fec.Encode(BytesToBits(payload)) {
+		bits[echoHeaderSegments+order[i]] = bit
+	}
+
+	fmt.Printf("Embedding %d bits as echoes in %d segments of %d samples (%d Hz, %d channels)\n",
+		len(payload)*8, len(bits), echoSegment, pcm.SampleRate, pcm.Channels)
+
+	bitrate := params.Bitrate
+	if bitrate == 0 {
+		bitrate = coverBitrate(cover)
+	}
+	out, err := encodeOutput(cover, addEchoes(pcm, start, bits), params.OutputFormat, bitrate)
+	if err != nil {
+		return nil, err
+	}
+	if params.CopyTags {
+		out = withCoverTags(cover, out)
+	}
+	return out, nil
+}
+
+// echoHeaderCode encodes and interleaves the header bits.
+func echoHeaderCode(bits []bool) []bool {
+	code := fec.Encode(bits)
+	return fec.Interleave(code, (len(code)+echoRowLength-1)/echoRowLength)
+}
+
+// echoHeaderDecode undoes echoHeaderCode on soft decisions.
+func echoHeaderDecode(soft []float64) []bool {
+	return fec.Decode(fec.Deinterleave(soft, (len(soft)+echoRowLength-1)/echoRowLength), HeaderBits)
+}
+
+// addEchoes returns pcm with segment i from frame start on echoed at the
+// delay for bits[i].
+func addEchoes(pcm *audio.PCM, start int, bits []bool) *audio.PCM {
+	out := pcm.Clone()
+	channels := pcm.Channels
+	for n := 0; n < len(bits)*echoSegment; n++ {
+		mix := echoMix(bits, n)
+		frame := start + n
+		for ch := 0; ch < channels; ch++ {
+			var echo float64
+			if frame >= echoDelay0 {
+				echo += (1 - mix) * float64(pcm.Samples[(frame-echoDelay0)*channels+ch])
+			}
+			if frame >= echoDelay1 {
+				echo += mix * float64(pcm.Samples[(frame-echoDelay1)*channels+ch])
+			}
+			i := frame*channels + ch
+			out.Samples[i] = clampSample(int(math.Round(float64(pcm.Samples[i]) + echoDecay*echo)))
+		}
+	}
+	return out
+}
+
+// echoMix returns the share of the 1 echo at frame n: 0 or 1 inside a
+// segment, ramping from the previous segment's value over the first
+// echoRamp frames.
+func echoMix(bits []bool, n int) float64 {
+	value := func(bit bool) float64 {
+		if bit {
+			return 1
+		}
+		return 0
+	}
+	segment, pos := n/echoSegment, n%echoSegment
+	target := value(bits[segment])
+	if segment == 0 || pos >= echoRamp {
+		return target
+	}
+	previous := value(bits[segment-1])
+	return previous + (target-previous)*float64(pos)/echoRamp
+}
+
+func (Echo) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return nil, err
+	}
+	r, err := findEcho(pcm, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	if r.header.Channels != 0 && r.header.Channels != pcm.Channels {
+		return nil, fmt.Errorf("header records %d channels but the audio has %d", r.header.Channels, pcm.Channels)
+	}
+
+	segments := r.header.Param
+	needed := r.header.PayloadLength * 8
+	if needed > echoCapacityBits(segments) || r.offset+(echoHeaderSegments+segments)*echoSegment > len(r.mono) {
+		return nil, fmt.Errorf("payload length %d exceeds the audio", r.header.PayloadLength)
+	}
+	order, err := utils.GeneratePositions(stegoKey, r.header.UseRandomSeed, segments, 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	soft := make([]float64, fec.EncodedLen(needed))
+	for i := range soft {
+		soft[i] = r.soft(echoHeaderSegments + order[i])
+	}
+	return BitsToBytes(fec.Decode(soft, needed)), nil
+}
+
+func (Echo) Detect(stego []byte, stegoKey string) bool {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return false
+	}
+	_, err = findEcho(pcm, stegoKey)
+	return err == nil
+}
+
+// echoReader reads soft decisions from a mono mixdown, starting offset
+// frames in.
+type echoReader struct {
+	mono   []float64
+	window []float64
+	offset int
+	header *Header
+}
+
+// findEcho looks for the header at the first loud frame and then ever
+// further either side of it.
+func findEcho(pcm *audio.PCM, stegoKey string) (*echoReader, error) {
+	r := &echoReader{mono: mixdown(pcm), window: hann(echoSegment)}
+	start := firstLoud(r.mono)
+	offsets := []int{start}
+	for d := echoSearchStep; d <= echoSearch; d += echoSearchStep {
+		offsets = append(offsets, start+d, start-d)
+	}
+
+	err := fmt.Errorf("not enough audio for parameter header")
+	for _, offset := range offsets {
+		if offset < 0 || offset+echoHeaderSegments*echoSegment > len(r.mono) {
+			continue
+		}
+		r.offset = offset
+
+		soft := make([]float64, echoHeaderSegments)
+		for i := range soft {
+			soft[i] = r.soft(i)
+		}
+		header, parseErr := ParseHeader(BitsToBytes(echoHeaderDecode(soft)), stegoKey)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		if header.MethodID != EchoID {
+			err = fmt.Errorf("header belongs to method %d", header.MethodID)
+			continue
+		}
+		r.header = header
+		return r, nil
+	}
+	return nil, fmt.Errorf("invalid parameter header: %w", err)
+}
+
+// soft reads segment i: the real cepstrum of an echo peaks at its delay,
+// so the difference of the cepstrum at the two delays is positive for a 1.
+func (r *echoReader) soft(i int) float64 {
+	start := r.offset + i*echoSegment
+	x := make([]complex128, echoSegment)
+	for j := range x {
+		x[j] = complex(r.mono[start+j]*r.window[j], 0)
+	}
+	fft(x, false)
+	for j, v := range x {
+		x[j] = complex(math.Log(cmplx.Abs(v)+1e-9), 0)
+	}
+	fft(x, true)
+	return real(x[echoDelay1]) - real(x[echoDelay0])
+}
@@ -0,0 +1,118 @@
+package stego
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"audio-steganography-lsb/pkg/audio"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoCover returns the whole test track as WAV: the coded header alone
+// takes more than five seconds.
+func echoCover(t *testing.T) []byte {
+	t.Helper()
+	pcm, err := audio.DecodeMP3(readCover(t))
+	require.NoError(t, err)
+	return audio.EncodeWAV(pcm)
+}
+
+func TestEchoRoundTrip(t *testing.T) {
+	cover := echoCover(t)
+	m, err := Lookup("echo")
+	require.NoError(t, err)
+
+	capacity, err := m.Capacity(cover, &Params{StegoKey: "echokey", NLsb: 1})
+	require.NoError(t, err)
+
+	random := make([]byte, 40)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+	payloads := map[string][]byte{
+		"text":   []byte("secret"),
+		"zeros":  make([]byte, 6),
+		"random": random,
+	}
+	require.GreaterOrEqual(t, capacity, len(random))
+
+	for _, params := range []*Params{
+		{StegoKey: "echokey", NLsb: 1, OutputFormat: "wav"},
+		{StegoKey: "echokey", NLsb: 1, UseRandomSeed: true, OutputFormat: "mp3", Bitrate: 192},
+		{StegoKey: "echokey", NLsb: 1, OutputFormat: "mp3", Bitrate: 128},
+	} {
+		for name, payload := range payloads {
+			t.Run(fmt.Sprintf("%s %d %s", params.OutputFormat, params.Bitrate, name), func(t *testing.T) {
+				stego, err := m.Embed(cover, payload, params)
+				require.NoError(t, err)
+
+				assert.True(t, m.Detect(stego, "echokey"))
+				assert.False(t, m.Detect(stego, "wrongkey"))
+
+				extracted, err := m.Extract(stego, "echokey")
+				require.NoError(t, err)
+				assert.Equal(t, payload, extracted)
+			})
+		}
+	}
+
+	_, err = m.Embed(cover, make([]byte, capacity+1), &Params{StegoKey: "echokey", NLsb: 1})
+	assert.Error(t, err)
+}
+
+// An MP3 cover is written back as MP3 by default.
+func TestEchoMP3Cover(t *testing.T) {
+	payload := []byte("secret")
+	stego, err := Echo{}.Embed(readCover(t), payload, &Params{StegoKey: "echokey", NLsb: 1})
+	require.NoError(t, err)
+	require.False(t, audio.IsWAV(stego))
+
+	extracted, err := Echo{}.Extract(stego, "echokey")
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+}
+
+func TestEchoLeadingSilence(t *testing.T) {
+	pcm, err := audio.DecodeWAV(echoCover(t))
+	require.NoError(t, err)
+	silence := make([]int16, pcm.SampleRate/2*pcm.Channels)
+	pcm.Samples = append(silence, pcm.Samples[:len(pcm.Samples)-len(silence)]...)
+	cover := audio.EncodeWAV(pcm)
+
+	stego, err := Echo{}.Embed(cover, []byte("late"), &Params{StegoKey: "silence", NLsb: 1, OutputFormat: "mp3", Bitrate: 192})
+	require.NoError(t, err)
+
+	decoded, err := audio.DecodeMP3(stego)
+	require.NoError(t, err)
+	// The silence carries no echo.
+	for _, s := range decoded.Samples[:len(silence)] {
+		require.InDelta(t, 0, s, 64)
+	}
+
+	extracted, err := Echo{}.Extract(stego, "silence")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("late"), extracted)
+}
+
+func TestFFTRoundTrip(t *testing.T) {
+	x := make([]complex128, 64)
+	for i := range x {
+		x[i] = complex(float64(i%7)-3, 0)
+	}
+	y := append([]complex128(nil), x...)
+	fft(y, false)
+	// Bin 0 is the sum of the input.
+	var sum complex128
+	for _, v := range x {
+		sum += v
+	}
+	assert.InDelta(t, real(sum), real(y[0]), 1e-9)
+
+	fft(y, true)
+	for i := range y {
+		assert.InDelta(t, real(x[i]), real(y[i])/64, 1e-9)
+		assert.InDelta(t, 0, imag(y[i])/64, 1e-9)
+	}
+}
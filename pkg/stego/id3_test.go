@@ -0,0 +1,117 @@
+package stego
+
+import (
+	"testing"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/metadata"
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestID3RoundTrip(t *testing.T) {
+	for name, cover := range map[string][]byte{
+		"existing tag": withTags(t, readCover(t)),
+		"no tag":       readCover(t)[138:],
+	} {
+		t.Run(name, func(t *testing.T) {
+			coverStream, err := mp3frame.Parse(cover)
+			require.NoError(t, err)
+
+			payload := []byte("payload hidden in the tag")
+			params := &Params{StegoKey: "id3key", NLsb: 1}
+			stego, err := ID3{}.Embed(cover, payload, params)
+			require.NoError(t, err)
+
+			// The audio and trailing tags come through byte for byte.
+			stream, err := mp3frame.Parse(stego)
+			require.NoError(t, err)
+			assert.Equal(t, cover[coverStream.ID3v2Size:], stego[stream.ID3v2Size:])
+
+			assert.True(t, ID3{}.Detect(stego, "id3key"))
+			assert.False(t, ID3{}.Detect(stego, "wrongkey"))
+			assert.False(t, ID3{}.Detect(cover, "id3key"))
+
+			detected, err := Detect(stego, "id3key")
+			require.NoError(t, err)
+			assert.Equal(t, ID3ID, detected.ID())
+
+			extracted, err := ID3{}.Extract(stego, "id3key")
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+
+			// Neither the payload nor the header is visible in the tag.
+			tag, err := metadata.ParseTag(stego)
+			require.NoError(t, err)
+			assert.NotContains(t, string(stego[:tag.Size]), "payload hidden")
+		})
+	}
+}
+
+func TestID3ReplacesEarlierPayload(t *testing.T) {
+	cover := withTags(t, readCover(t))
+	first, err := ID3{}.Embed(cover, []byte("first"), &Params{StegoKey: "key", NLsb: 1})
+	require.NoError(t, err)
+	other, err := ID3{}.Embed(first, []byte("other key"), &Params{StegoKey: "other", NLsb: 1})
+	require.NoError(t, err)
+	second, err := ID3{}.Embed(other, []byte("second"), &Params{StegoKey: "key", NLsb: 1})
+	require.NoError(t, err)
+
+	tag, err := metadata.ParseTag(second)
+	require.NoError(t, err)
+	privs := 0
+	for _, f := range tag.Frames {
+		if f.ID == "PRIV" {
+			privs++
+		}
+	}
+	assert.Equal(t, 2, privs)
+
+	extracted, err := ID3{}.Extract(second, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), extracted)
+	extracted, err = ID3{}.Extract(second, "other")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("other key"), extracted)
+}
+
+func TestID3EmbedManySingleFrame(t *testing.T) {
+	cover := withTags(t, readCover(t))
+	privs := func(data []byte) int {
+		tag, err := metadata.ParseTag(data)
+		require.NoError(t, err)
+		n := 0
+		for _, f := range tag.Frames {
+			if f.ID == "PRIV" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// However many recipients there are, the tag gains one PRIV frame.
+	keys := []string{"alice", "bob", "carol"}
+	for n := 1; n <= len(keys); n++ {
+		payloads := make([][]byte, n)
+		for i := range payloads {
+			payloads[i] = []byte("payload for " + keys[i])
+		}
+		stego, err := EmbedMany(ID3{}, cover, payloads, keys[:n], &Params{NLsb: 1})
+		require.NoError(t, err)
+		assert.Equal(t, privs(cover)+1, privs(stego), "%d keys", n)
+
+		for i, key := range keys[:n] {
+			extracted, err := ID3{}.Extract(stego, key)
+			require.NoError(t, err)
+			assert.Equal(t, payloads[i], extracted)
+		}
+	}
+}
+
+func TestID3RejectsNonMP3(t *testing.T) {
+	wav := audio.EncodeWAV(&audio.PCM{Samples: make([]int16, 1000), SampleRate: 8000, Channels: 1})
+	_, err := ID3{}.Capacity(wav, &Params{StegoKey: "key", NLsb: 1})
+	assert.Error(t, err)
+}
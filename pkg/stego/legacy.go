@@ -0,0 +1,117 @@
+package stego
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"audio-steganography-lsb/pkg/utils"
+)
+
+// Files written before the method registry carry an 8-byte header instead
+// of the 14-byte one and embed into the LSBs of every MP3 byte that is not
+// part of a frame sync, without parsing the frames:
+//
+//	[2 bytes: magic 0xAB 0xCD] + [nLsb] + [random seed flag] + [4 bytes: sum of the key bytes]
+//
+// The header sits in the LSB of the first 64 of those bytes and the embed
+// container follows in the rest. They can still be extracted, but no longer
+// written.
+const (
+	legacyHeaderBits = 64
+	legacySkipStart  = 512
+)
+
+// ExtractLegacy returns the embed container of a file written in the
+// pre-registry bitstream format.
+func ExtractLegacy(stego []byte, stegoKey string) ([]byte, error) {
+	positions := legacyPositions(stego)
+	if len(positions) < legacyHeaderBits {
+		return nil, fmt.Errorf("not enough embeddable positions")
+	}
+
+	header := make([]bool, legacyHeaderBits)
+	for i := range header {
+		header[i] = stego[positions[i]]&0x01 == 1
+	}
+	nLsb, useRandomSeed, err := parseLegacyHeader(BitsToBytes(header), stegoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	carriers := positions[legacyHeaderBits:]
+	dataPositions, err := utils.GeneratePositions(stegoKey, useRandomSeed, len(carriers), nLsb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+	read := func(n int) ([]byte, error) {
+		if n*8 > len(dataPositions)*nLsb {
+			return nil, fmt.Errorf("container length %d exceeds capacity", n)
+		}
+		return BitsToBytes(readSlots(stego, carriers, dataPositions, nLsb, n*8)), nil
+	}
+
+	// The container is [metadata length][metadata][message length][message];
+	// read the lengths first so only the container itself is read.
+	data, err := read(4)
+	if err != nil {
+		return nil, err
+	}
+	messageStart := 4 + int(binary.LittleEndian.Uint32(data)) + 4
+	if data, err = read(messageStart); err != nil {
+		return nil, err
+	}
+	return read(messageStart + int(binary.LittleEndian.Uint32(data[messageStart-4:])))
+}
+
+func parseLegacyHeader(b []byte, stegoKey string) (nLsb int, useRandomSeed bool, err error) {
+	if b[0] != headerMagic0 || b[1] != headerMagic1 {
+		return 0, false, fmt.Errorf("invalid magic bytes")
+	}
+	nLsb = int(b[2])
+	if nLsb < 1 || nLsb > 4 {
+		return 0, false, fmt.Errorf("invalid nLsb value: %d", nLsb)
+	}
+
+	var keySum uint32
+	for _, c := range []byte(stegoKey) {
+		keySum += uint32(c)
+	}
+	if binary.LittleEndian.Uint32(b[4:8]) != keySum {
+		return 0, false, fmt.Errorf("key checksum mismatch")
+	}
+	return nLsb, b[3] == 1, nil
+}
+
+// legacyPositions lists the bytes the pre-registry format embedded into:
+// every byte from offset 512 on that is not within a frame sync, or, for
+// files with fewer than 10000 of those, every byte from offset 2000 on that
+// is not 0xFF and not next to one.
+func legacyPositions(data []byte) []int {
+	syncs := make(map[int]bool)
+	for i := 0; i < len(data)-1; i++ {
+		if data[i] == 0xFF && data[i+1]&0xE0 == 0xE0 {
+			for j := 0; j < 4 && i+j < len(data); j++ {
+				syncs[i+j] = true
+			}
+		}
+	}
+
+	var positions []int
+	for i := legacySkipStart; i < len(data); i++ {
+		if !syncs[i] {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) >= 10000 || len(data) <= 2000 {
+		return positions
+	}
+
+	positions = nil
+	for i := 2000; i < len(data); i++ {
+		if data[i] == 0xFF || data[i-1] == 0xFF && data[i]&0xE0 == 0xE0 || i+1 < len(data) && data[i+1] == 0xFF {
+			continue
+		}
+		positions = append(positions, i)
+	}
+	return positions
+}
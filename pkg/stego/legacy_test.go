@@ -0,0 +1,71 @@
+package stego
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"audio-steganography-lsb/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyEmbed writes container the way embed did before the method
+// registry.
+func legacyEmbed(t *testing.T, cover, container []byte, stegoKey string, useRandomSeed bool, nLsb int) []byte {
+	t.Helper()
+	stego := append([]byte(nil), cover...)
+	positions := legacyPositions(stego)
+
+	header := []byte{headerMagic0, headerMagic1, byte(nLsb), 0, 0, 0, 0, 0}
+	if useRandomSeed {
+		header[3] = 1
+	}
+	var keySum uint32
+	for _, c := range []byte(stegoKey) {
+		keySum += uint32(c)
+	}
+	binary.LittleEndian.PutUint32(header[4:], keySum)
+	for i, bit := range BytesToBits(header) {
+		setBits(stego, positions[i], 0x01, bit)
+	}
+
+	carriers := positions[legacyHeaderBits:]
+	dataPositions, err := utils.GeneratePositions(stegoKey, useRandomSeed, len(carriers), nLsb)
+	require.NoError(t, err)
+	bits := BytesToBits(container)
+	for i := 0; i*nLsb < len(bits); i++ {
+		for j := 0; j < nLsb && i*nLsb+j < len(bits); j++ {
+			setBits(stego, carriers[dataPositions[i]], 1<<j, bits[i*nLsb+j])
+		}
+	}
+	return stego
+}
+
+func TestExtractLegacy(t *testing.T) {
+	cover := readCover(t)
+	metadata := []byte("\x0asecret.txt\x04.txt")
+	message := []byte("written before the method registry")
+	container := binary.LittleEndian.AppendUint32(nil, uint32(len(metadata)))
+	container = append(container, metadata...)
+	container = binary.LittleEndian.AppendUint32(container, uint32(len(message)))
+	container = append(container, message...)
+
+	for _, tc := range []struct {
+		nLsb          int
+		useRandomSeed bool
+	}{{1, false}, {2, false}, {3, true}} {
+		stego := legacyEmbed(t, cover, container, "oldkey", tc.useRandomSeed, tc.nLsb)
+		extracted, err := ExtractLegacy(stego, "oldkey")
+		require.NoError(t, err, "%+v", tc)
+		assert.Equal(t, container, extracted, "%+v", tc)
+
+		_, err = ExtractLegacy(stego, "newkey")
+		assert.ErrorContains(t, err, "key checksum mismatch")
+		_, err = Detect(stego, "oldkey")
+		assert.Error(t, err, "no method reads the old header")
+	}
+
+	_, err := ExtractLegacy(cover, "oldkey")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package stego
+
+// Matrix embedding with binary Hamming codes, as in F5. A block of 2^k-1
+// cover slots carries k message bits in its syndrome: the XOR of the
+// 1-based indices of the slots that are set. Any syndrome can be reached by
+// flipping at most one slot, so k bits cost at most one change instead of
+// about k/2. Larger k changes fewer slots per bit but needs more slots, so
+// it suits small payloads in large covers.
+
+// maxMatrixK bounds the code size; a block of 2^16-1 slots is already far
+// larger than any useful payload-to-capacity ratio calls for.
+const maxMatrixK = 16
+
+// matrixSlots returns how many cover slots bits message bits need with code
+// parameter k. k of 0 means plain embedding, one slot per bit.
+func matrixSlots(bits, k int) int {
+	if k == 0 {
+		return bits
+	}
+	return (bits + k - 1) / k * (1<<k - 1)
+}
+
+// matrixK picks the largest k for which bits fit in slots, or 0 if nothing
+// beats plain embedding. k of 1 is plain embedding, so it is never chosen.
+func matrixK(bits, slots int) int {
+	for k := maxMatrixK; k >= 2; k-- {
+		if matrixSlots(bits, k) <= slots {
+			return k
+		}
+	}
+	return 0
+}
+
+// matrixEmbed returns a copy of cover whose block syndromes spell out bits,
+// padded with zeros to whole blocks, and the number of slots it changed.
+func matrixEmbed(cover, bits []bool, k int) ([]bool, int) {
+	n := 1<<k - 1
+	slots := append([]bool(nil), cover[:matrixSlots(len(bits), k)]...)
+	changes := 0
+	for b := 0; b*k < len(bits); b++ {
+		block := slots[b*n : (b+1)*n]
+		want := 0
+		for j := 0; j < k && b*k+j < len(bits); j++ {
+			if bits[b*k+j] {
+				want |= 1 << j
+			}
+		}
+		if flip := syndrome(block) ^ want; flip != 0 {
+			block[flip-1] = !block[flip-1]
+			changes++
+		}
+	}
+	return slots, changes
+}
+
+// matrixExtract reads bits message bits back from the block syndromes.
+func matrixExtract(slots []bool, k, bits int) []bool {
+	n := 1<<k - 1
+	out := make([]bool, 0, bits+k)
+	for b := 0; len(out) < bits; b++ {
+		s := syndrome(slots[b*n : (b+1)*n])
+		for j := 0; j < k; j++ {
+			out = append(out, s>>j&1 == 1)
+		}
+	}
+	return out[:bits]
+}
+
+func syndrome(block []bool) int {
+	s := 0
+	for i, set := range block {
+		if set {
+			s ^= i + 1
+		}
+	}
+	return s
+}
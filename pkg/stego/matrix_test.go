@@ -0,0 +1,76 @@
+package stego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrixRoundTrip(t *testing.T) {
+	for k := 2; k <= 8; k++ {
+		bits := BytesToBits([]byte("matrix embedding payload"))
+		bits = bits[:len(bits)-3] // not a whole number of blocks
+		cover := make([]bool, matrixSlots(len(bits), k)+5)
+		for i := range cover {
+			cover[i] = (i*7+k)%3 == 0
+		}
+
+		slots, changes := matrixEmbed(cover, bits, k)
+		require.Len(t, slots, matrixSlots(len(bits), k))
+		assert.Equal(t, bits, matrixExtract(slots, k, len(bits)), "k=%d", k)
+
+		// At most one change per block, and exactly as many as reported.
+		differ := 0
+		for i := range slots {
+			if slots[i] != cover[i] {
+				differ++
+			}
+		}
+		assert.Equal(t, changes, differ)
+		assert.LessOrEqual(t, changes, (len(bits)+k-1)/k)
+	}
+}
+
+func TestMatrixK(t *testing.T) {
+	assert.Equal(t, 0, matrixK(1000, 1000))
+	assert.Equal(t, 0, matrixK(1000, 1400))
+	// 500 blocks of 3 slots.
+	assert.Equal(t, 2, matrixK(1000, 1500))
+	// 10 bits in blocks of 2^10-1 = 1023 slots.
+	assert.Equal(t, 10, matrixK(10, 1023))
+	assert.Equal(t, maxMatrixK, matrixK(8, 1<<20))
+}
+
+func TestBitstreamMatrixEmbedding(t *testing.T) {
+	cover := readCover(t)
+	payload := []byte("a short note in a long song")
+
+	plain, err := Bitstream{}.Embed(cover, payload, &Params{StegoKey: "matrixkey", NLsb: 1})
+	require.NoError(t, err)
+
+	params := &Params{StegoKey: "matrixkey", NLsb: 1, MatrixEmbedding: true}
+	stego, err := Bitstream{}.Embed(cover, payload, params)
+	require.NoError(t, err)
+
+	header, _, err := findBitstreamRegion(stego, findEmbeddablePositions(stego), "matrixkey")
+	require.NoError(t, err)
+	assert.Greater(t, header.Param, 2)
+
+	extracted, err := Bitstream{}.Extract(stego, "matrixkey")
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+
+	changed := func(data []byte) int {
+		n := 0
+		for i := range cover {
+			if data[i] != cover[i] {
+				n++
+			}
+		}
+		return n
+	}
+	// Both change the header; matrix embedding changes far fewer payload
+	// positions on top of it.
+	assert.Less(t, changed(stego)-HeaderBits, (changed(plain)-HeaderBits)/2)
+}
@@ -0,0 +1,266 @@
+package stego
+
+import (
+	"fmt"
+	"math"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/mp3enc"
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+const MDCTID byte = 12
+
+// MDCT embeds by quantization index modulation on the MDCT coefficients of
+// the built-in MP3 encoder. The encoder's own quantizer is the lattice:
+// every line from mdctLowHz to mdctHighHz of the mid channel with a
+// non-zero quantization index carries one bit in its parity, moved to the
+// neighbouring index of the right parity when it has the wrong one. The
+// error this adds is at most one step of the quantizer, the same order as
+// the coding noise already there, and the bit budget of the frame accounts
+// for it. Lines that quantize to zero are left alone, so no energy appears
+// where the music has none.
+//
+// Extraction dequantizes the coefficients through mp3frame.Spectra and
+// recovers each index from its coefficient and step. Embedding never
+// changes which indexes are zero, so it finds the same carriers. Bits are
+// XORed with a keyed stream, which makes the parities look like coding
+// noise and hides the header from other keys.
+//
+// The output is always MP3 and the bits live in its quantized values, so
+// decoding and re-encoding the file loses them.
+type MDCT struct{}
+
+const (
+	mdctLowHz  = 1000
+	mdctHighHz = 8000
+)
+
+func init() {
+	Register(MDCT{})
+}
+
+func (MDCT) Name() string { return "mdct" }
+
+func (MDCT) ID() byte { return MDCTID }
+
+// mdctLines returns the first carrying line and the line after the last.
+// Lines are sampleRate/1152 Hz apart.
+func mdctLines(sampleRate int) (int, int) {
+	return mdctLowHz * 1152 / sampleRate, mdctHighHz * 1152 / sampleRate
+}
+
+// mdctEmbedder is the quantizer hook. The encoder calls it for a granule
+// once per global gain it tries, the last call being final, so carriers of
+// a granule only count once the next granule starts.
+type mdctEmbedder struct {
+	low, high int
+	// bits are the whitened header and payload bits; nil only counts
+	// carriers.
+	bits    []bool
+	granule int
+	// done counts the carriers of finished granules, current those of the
+	// granule being quantized.
+	done, current int
+}
+
+func (e *mdctEmbedder) quantize(granule, ch int, v *[576]float64, ix *[576]int) {
+	if ch != 0 {
+		return
+	}
+	if granule != e.granule {
+		e.done += e.current
+		e.current = 0
+		e.granule = granule
+	}
+
+	e.current = 0
+	for i := e.low; i < e.high; i++ {
+		if ix[i] == 0 {
+			continue
+		}
+		if n := e.done + e.current; n < len(e.bits) {
+			ix[i] = mdctIndex(ix[i], v[i], e.bits[n])
+		}
+		e.current++
+	}
+}
+
+// carriers returns the carriers of every granule quantized so far.
+func (e *mdctEmbedder) carriers() int {
+	return e.done + e.current
+}
+
+// mdctIndex returns the non-zero index of the right parity for bit closest
+// to the unrounded magnitude v.
+func mdctIndex(q int, v float64, bit bool) int {
+	magnitude := q
+	sign := 1
+	if q < 0 {
+		magnitude, sign = -q, -1
+	}
+	if (magnitude&1 == 1) == bit {
+		return q
+	}
+	if magnitude == 1 || v > float64(magnitude) {
+		magnitude++
+	} else {
+		magnitude--
+	}
+	return sign * magnitude
+}
+
+// mdctEncode encodes pcm the way encodeOutput does, with e as the quantizer
+// hook.
+func mdctEncode(pcm *audio.PCM, bitrate int, e *mdctEmbedder) ([]byte, error) {
+	e.low, e.high = mdctLines(pcm.SampleRate)
+	data, err := mp3enc.Encode(pcm.Samples, mp3enc.Config{
+		SampleRate: pcm.SampleRate,
+		Channels:   pcm.Channels,
+		Bitrate:    bitrate,
+		Mode:       mp3frame.ModeJointStereo,
+		Quantize:   e.quantize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode to MP3: %w", err)
+	}
+	return data, nil
+}
+
+func mdctBitrate(cover []byte, params *Params) int {
+	if params.Bitrate != 0 {
+		return params.Bitrate
+	}
+	return coverBitrate(cover)
+}
+
+// Capacity is three quarters of the carriers of a plain encode: the bits
+// embedding adds raise the global gains, which quantizes some carriers to
+// zero.
+func (MDCT) Capacity(cover []byte, params *Params) (int, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return 0, err
+	}
+	e := &mdctEmbedder{}
+	if _, err := mdctEncode(pcm, mdctBitrate(cover, params), e); err != nil {
+		return 0, err
+	}
+	if e.carriers()*3/4 < HeaderBits {
+		return 0, fmt.Errorf("not enough audio for parameter header")
+	}
+	return (e.carriers()*3/4 - HeaderBits) / 8, nil
+}
+
+func (MDCT) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	if params.OutputFormat != "" && params.OutputFormat != "mp3" {
+		return nil, fmt.Errorf("mdct method writes MP3 only")
+	}
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return nil, err
+	}
+	if pcm.Channels > maxChannels {
+		return nil, fmt.Errorf("unsupported channel count: %d", pcm.Channels)
+	}
+
+	header := &Header{
+		MethodID:      MDCTID,
+		NLsb:          params.NLsb,
+		UseRandomSeed: params.UseRandomSeed,
+		PayloadLength: len(payload),
+		Channels:      pcm.Channels,
+	}
+	headerBytes, err := header.Marshal(params.StegoKey)
+	if err != nil {
+		return nil, err
+	}
+	bits := append(BytesToBits(headerBytes), BytesToBits(payload)...)
+	mdctWhiten(bits, params.StegoKey)
+
+	fmt.Printf("Embedding %d bits in the quantization indexes of MDCT lines %d-%d Hz (%d Hz, %d channels)\n",
+		len(payload)*8, mdctLowHz, mdctHighHz, pcm.SampleRate, pcm.Channels)
+
+	e := &mdctEmbedder{bits: bits}
+	data, err := mdctEncode(pcm, mdctBitrate(cover, params), e)
+	if err != nil {
+		return nil, err
+	}
+	if e.carriers() < len(bits) {
+		return nil, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, e.carriers()-HeaderBits)
+	}
+	if params.CopyTags {
+		data = withCoverTags(cover, data)
+	}
+	return data, nil
+}
+
+// mdctWhiten XORs bits with the keyed stream of the mdct method.
+func mdctWhiten(bits []bool, stegoKey string) {
+	stream := keystream("mdct:", stegoKey, (len(bits)+7)/8)
+	for i := range bits {
+		if stream[i/8]>>(7-i%8)&1 == 1 {
+			bits[i] = !bits[i]
+		}
+	}
+}
+
+// mdctBits returns the bits of every carrier of an MP3 file, unwhitened
+// for stegoKey.
+func mdctBits(data []byte, stegoKey string) ([]bool, error) {
+	stream, err := mp3frame.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	low, high := mdctLines(stream.Frames[0].Header.SampleRate)
+
+	var bits []bool
+	mp3frame.Spectra(stream, func(granule int, xr, step [][576]float64) {
+		for i := low; i < high; i++ {
+			if xr[0][i] != 0 {
+				q := int(math.Round(math.Pow(math.Abs(xr[0][i])/step[0][i], 0.75)))
+				bits = append(bits, q&1 == 1)
+			}
+		}
+	})
+	mdctWhiten(bits, stegoKey)
+	return bits, nil
+}
+
+func (MDCT) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	header, bits, err := readMDCTHeader(stego, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	needed := HeaderBits + header.PayloadLength*8
+	if needed > len(bits) {
+		return nil, fmt.Errorf("payload length %d exceeds capacity", header.PayloadLength)
+	}
+	return BitsToBytes(bits[HeaderBits:needed]), nil
+}
+
+func (MDCT) Detect(stego []byte, stegoKey string) bool {
+	_, _, err := readMDCTHeader(stego, stegoKey)
+	return err == nil
+}
+
+func readMDCTHeader(stego []byte, stegoKey string) (*Header, []bool, error) {
+	if audio.IsWAV(stego) {
+		return nil, nil, fmt.Errorf("mdct method reads MP3 only")
+	}
+	bits, err := mdctBits(stego, stegoKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bits) < HeaderBits {
+		return nil, nil, fmt.Errorf("not enough audio for parameter header")
+	}
+	header, err := ParseHeader(BitsToBytes(bits[:HeaderBits]), stegoKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parameter header: %w", err)
+	}
+	if header.MethodID != MDCTID {
+		return nil, nil, fmt.Errorf("header belongs to method %d", header.MethodID)
+	}
+	return header, bits, nil
+}
@@ -0,0 +1,60 @@
+package stego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDCTRoundTrip(t *testing.T) {
+	cover := wavCover(t)
+	payload := []byte("payload carried in the parity of MDCT quantization indexes")
+	m, err := Lookup("mdct")
+	require.NoError(t, err)
+
+	for _, bitrate := range []int{128, 192} {
+		params := &Params{StegoKey: "mdctkey", NLsb: 1, Bitrate: bitrate}
+		capacity, err := m.Capacity(cover, params)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, capacity, len(payload))
+
+		stego, err := m.Embed(cover, payload, params)
+		require.NoError(t, err)
+
+		assert.True(t, m.Detect(stego, "mdctkey"))
+		assert.False(t, m.Detect(stego, "wrongkey"))
+
+		extracted, err := m.Extract(stego, "mdctkey")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+
+		// Capacity leaves room for the carriers embedding quantizes away.
+		_, err = m.Embed(cover, make([]byte, capacity), params)
+		assert.NoError(t, err)
+	}
+}
+
+func TestMDCTIndex(t *testing.T) {
+	for _, tc := range []struct {
+		q    int
+		v    float64
+		bit  bool
+		want int
+	}{
+		{3, 3.2, true, 3},
+		{3, 3.2, false, 4},
+		{3, 2.9, false, 2},
+		{-3, 2.9, false, -2},
+		// 1 never becomes 0, which would take the line out of the carriers.
+		{1, 0.7, false, 2},
+		{-1, 0.7, false, -2},
+	} {
+		assert.Equal(t, tc.want, mdctIndex(tc.q, tc.v, tc.bit), "%+v", tc)
+	}
+}
+
+func TestMDCTWritesMP3Only(t *testing.T) {
+	_, err := MDCT{}.Embed(wavCover(t), []byte("x"), &Params{StegoKey: "mdctkey", NLsb: 1, OutputFormat: "wav"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package stego
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/utils"
+)
+
+const PhaseID byte = 10
+
+// Phase hides the header and payload in the phases of the first segment of
+// phaseSegment sample frames: bin k of its spectrum gets phase π/2 for a 0
+// and -π/2 for a 1, one bit per bin from bin 1 on. Every later segment is
+// rotated by the same per-bin phase change, which keeps the phase
+// differences between segments that the ear is sensitive to. Magnitudes are
+// kept, except that a carrying bin too weak to survive rounding is raised to
+// phaseMinMagnitude.
+//
+// Capacity is fixed by the segment size rather than the length of the
+// cover. Extraction needs the samples exactly as written, so the output is
+// always WAV.
+type Phase struct{}
+
+const (
+	phaseSegment = 8192
+	phaseBins    = phaseSegment/2 - 1
+
+	// phaseMinMagnitude is about 80 times the spectral noise that rounding
+	// to 16 bits adds to a bin, and a fraction of one LSB in the samples.
+	phaseMinMagnitude = 2000
+
+	// phaseGrid is the grid the segment is aligned to. It starts at the
+	// first grid point after the first loud frame: everything up to that
+	// frame is untouched, so extraction finds the same point.
+	phaseGrid = 1024
+)
+
+func init() {
+	Register(Phase{})
+}
+
+func (Phase) Name() string { return "phase" }
+
+func (Phase) ID() byte { return PhaseID }
+
+func (Phase) Capacity(cover []byte, params *Params) (int, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := phaseStart(mixdown(pcm)); err != nil {
+		return 0, err
+	}
+	return (phaseBins - HeaderBits) / 8, nil
+}
+
+// phaseStart returns the first frame of the segment carrying the bits.
+func phaseStart(mono []float64) (int, error) {
+	start := (firstLoud(mono)/phaseGrid + 1) * phaseGrid
+	if start+phaseSegment > len(mono) {
+		return 0, fmt.Errorf("not enough audio for a %d-frame segment", phaseSegment)
+	}
+	return start, nil
+}
+
+func (Phase) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	// Re-encoding shifts and distorts the phases, so an MP3 output would
+	// never give the payload back.
+	format := params.OutputFormat
+	if format == "" {
+		format = "wav"
+	}
+	if format == "mp3" {
+		return nil, fmt.Errorf("phase method cannot write MP3: re-encoding destroys the hidden bits; write WAV instead")
+	}
+
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return nil, err
+	}
+	if pcm.Channels > maxChannels {
+		return nil, fmt.Errorf("unsupported channel count: %d", pcm.Channels)
+	}
+
+	start, err := phaseStart(mixdown(pcm))
+	if err != nil {
+		return nil, err
+	}
+	if capacity := phaseBins - HeaderBits; len(payload)*8 > capacity {
+		return nil, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, capacity)
+	}
+
+	header := &Header{
+		MethodID:      PhaseID,
+		NLsb:          params.NLsb,
+		UseRandomSeed: params.UseRandomSeed,
+		PayloadLength: len(payload),
+		Channels:      pcm.Channels,
+	}
+	order, err := utils.GeneratePositions(params.StegoKey, params.UseRandomSeed, phaseBins-HeaderBits, 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	// The header takes bins 1 to HeaderBits; payload bits go to the bins
+	// after it in key order. Bins nothing is written to keep their phase.
+	headerBytes, err := header.Marshal(params.StegoKey)
+	if err != nil {
+		return nil, err
+	}
+	bits := BytesToBits(headerBytes)
+	payloadBits := BytesToBits(payload)
+	bins := make([]int, HeaderBits, HeaderBits+len(payloadBits))
+	for i := range bins {
+		bins[i] = i + 1
+	}
+	for i, bit := range payloadBits {
+		bins = append(bins, HeaderBits+order[i]+1)
+		bits = append(bits, bit)
+	}
+
+	fmt.Printf("Embedding %d bits in the phases of a %d-frame segment (%d Hz, %d channels)\n",
+		len(payloadBits), phaseSegment, pcm.SampleRate, pcm.Channels)
+
+	out := pcm.Clone()
+	for ch := 0; ch < pcm.Channels; ch++ {
+		setPhases(out, ch, start, bins, bits)
+	}
+
+	// Only WAV gets this far, which needs no bitrate.
+	return encodeOutput(cover, out, format, 0)
+}
+
+// setPhases sets bin bins[i] of the segment at frame start of channel ch to
+// the phase of bits[i] and rotates every later segment by the same amount.
+func setPhases(pcm *audio.PCM, ch, start int, bins []int, bits []bool) {
+	rotation := make([]complex128, phaseSegment/2+1)
+	for k := range rotation {
+		rotation[k] = 1
+	}
+
+	for segment := start; segment+phaseSegment <= frames(pcm); segment += phaseSegment {
+		x := make([]complex128, phaseSegment)
+		for j := range x {
+			x[j] = complex(float64(pcm.Samples[(segment+j)*pcm.Channels+ch]), 0)
+		}
+		fft(x, false)
+
+		if segment == start {
+			for i, k := range bins {
+				phase := math.Pi / 2
+				if bits[i] {
+					phase = -phase
+				}
+				magnitude := math.Max(cmplx.Abs(x[k]), phaseMinMagnitude)
+				target := cmplx.Rect(magnitude, phase)
+				if x[k] != 0 {
+					rotation[k] = target / x[k]
+				}
+				x[k] = target
+				x[phaseSegment-k] = cmplx.Conj(target)
+			}
+		} else {
+			for _, k := range bins {
+				// Rotate without scaling: only the first segment's
+				// magnitudes may change.
+				r := rotation[k] / complex(cmplx.Abs(rotation[k]), 0)
+				x[k] *= r
+				x[phaseSegment-k] = cmplx.Conj(x[k])
+			}
+		}
+
+		fft(x, true)
+		for j := range x {
+			pcm.Samples[(segment+j)*pcm.Channels+ch] = clampSample(int(math.Round(real(x[j]) / phaseSegment)))
+		}
+	}
+}
+
+func (Phase) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return nil, err
+	}
+	header, phases, err := readPhaseHeader(pcm, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	if header.Channels != 0 && header.Channels != pcm.Channels {
+		return nil, fmt.Errorf("header records %d channels but the audio has %d", header.Channels, pcm.Channels)
+	}
+
+	needed := header.PayloadLength * 8
+	if needed > phaseBins-HeaderBits {
+		return nil, fmt.Errorf("payload length %d exceeds capacity", header.PayloadLength)
+	}
+	order, err := utils.GeneratePositions(stegoKey, header.UseRandomSeed, phaseBins-HeaderBits, 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+
+	bits := make([]bool, needed)
+	for i := range bits {
+		bits[i] = phases[HeaderBits+order[i]]
+	}
+	return BitsToBytes(bits), nil
+}
+
+func (Phase) Detect(stego []byte, stegoKey string) bool {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return false
+	}
+	_, _, err = readPhaseHeader(pcm, stegoKey)
+	return err == nil
+}
+
+// readPhaseHeader reads the bits of every bin of the carrying segment and
+// parses the header from the first HeaderBits of them.
+func readPhaseHeader(pcm *audio.PCM, stegoKey string) (*Header, []bool, error) {
+	mono := mixdown(pcm)
+	start, err := phaseStart(mono)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	x := make([]complex128, phaseSegment)
+	for j := range x {
+		x[j] = complex(mono[start+j], 0)
+	}
+	fft(x, false)
+
+	// A negative imaginary part is a phase near -π/2.
+	bits := make([]bool, phaseBins)
+	for k := range bits {
+		bits[k] = imag(x[k+1]) < 0
+	}
+
+	header, err := ParseHeader(BitsToBytes(bits[:HeaderBits]), stegoKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parameter header: %w", err)
+	}
+	if header.MethodID != PhaseID {
+		return nil, nil, fmt.Errorf("header belongs to method %d", header.MethodID)
+	}
+	return header, bits, nil
+}
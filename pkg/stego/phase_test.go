@@ -0,0 +1,95 @@
+package stego
+
+import (
+	"math"
+	"testing"
+
+	"audio-steganography-lsb/pkg/audio"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseRoundTrip(t *testing.T) {
+	cover := wavCover(t)
+	m, err := Lookup("phase")
+	require.NoError(t, err)
+
+	capacity, err := m.Capacity(cover, &Params{StegoKey: "phasekey", NLsb: 1})
+	require.NoError(t, err)
+	assert.Equal(t, (phaseBins-HeaderBits)/8, capacity)
+
+	payload := make([]byte, capacity)
+	for i := range payload {
+		payload[i] = byte(i*13 + 5)
+	}
+	for _, params := range []*Params{
+		{StegoKey: "phasekey", NLsb: 1},
+		{StegoKey: "phasekey", NLsb: 1, UseRandomSeed: true},
+	} {
+		stego, err := m.Embed(cover, payload, params)
+		require.NoError(t, err)
+		require.True(t, audio.IsWAV(stego))
+
+		assert.True(t, m.Detect(stego, "phasekey"))
+		assert.False(t, m.Detect(stego, "wrongkey"))
+
+		extracted, err := m.Extract(stego, "phasekey")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+	}
+
+	_, err = m.Embed(cover, make([]byte, capacity+1), &Params{StegoKey: "phasekey", NLsb: 1})
+	assert.Error(t, err)
+}
+
+func TestPhaseMP3Cover(t *testing.T) {
+	cover := readCover(t)
+	payload := []byte("phases of an MP3 cover")
+
+	// An MP3 cover is written back as WAV, from which the payload comes
+	// out again.
+	stego, err := Phase{}.Embed(cover, payload, &Params{StegoKey: "phasekey", NLsb: 1})
+	require.NoError(t, err)
+	require.True(t, audio.IsWAV(stego))
+	extracted, err := Phase{}.Extract(stego, "phasekey")
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+
+	_, err = Phase{}.Embed(cover, payload, &Params{StegoKey: "phasekey", NLsb: 1, OutputFormat: "mp3"})
+	assert.ErrorContains(t, err, "cannot write MP3")
+}
+
+func TestPhaseTransparency(t *testing.T) {
+	cover := wavCover(t)
+	pcm, err := audio.DecodeWAV(cover)
+	require.NoError(t, err)
+
+	stego, err := Phase{}.Embed(cover, []byte("quiet"), &Params{StegoKey: "phasekey", NLsb: 1})
+	require.NoError(t, err)
+	decoded, err := audio.DecodeWAV(stego)
+	require.NoError(t, err)
+
+	mono := mixdown(pcm)
+	start, err := phaseStart(mono)
+	require.NoError(t, err)
+	// Nothing before the segment changes, so extraction finds it again.
+	assert.Equal(t, pcm.Samples[:start*pcm.Channels], decoded.Samples[:start*pcm.Channels])
+	assert.Equal(t, firstLoud(mono), firstLoud(mixdown(decoded)))
+
+	// Segments after the first are only rotated: their magnitude spectra
+	// are unchanged up to rounding.
+	segment := start + phaseSegment
+	spectrum := func(p *audio.PCM) []complex128 {
+		x := make([]complex128, phaseSegment)
+		for j := range x {
+			x[j] = complex(float64(p.Samples[(segment+j)*p.Channels]), 0)
+		}
+		fft(x, false)
+		return x
+	}
+	before, after := spectrum(pcm), spectrum(decoded)
+	for k := 1; k < phaseSegment/2; k++ {
+		require.InDelta(t, math.Hypot(real(before[k]), imag(before[k])), math.Hypot(real(after[k]), imag(after[k])), 200, "bin %d", k)
+	}
+}
@@ -0,0 +1,381 @@
+package stego
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+)
+
+// Resync layout of the bitstream method. The payload is cut into blocks
+// and every block goes into one audio frame, so a block can be read from
+// its frame alone. A block starts, in bit 0 of the frame's first
+// embeddable positions, with a sync marker derived from the key and a head
+// holding the block index and a CRC. Every resyncHeaderEvery-th block
+// follows it with a copy of the parameter header; then come the payload
+// bytes at nLsb bits per position, in the other blocks also where the copy
+// would be. Everything after the marker is whitened with keystreams of the
+// key.
+//
+// Extraction scans every frame for the marker, takes the parameter header
+// from the first intact copy and keeps every block whose CRC matches.
+// Cutting frames from either end, dropping frames or flipping bits in some
+// of them only loses the blocks of those frames.
+const (
+	resyncMarkerBits = 32
+	// The head is a 16-bit block index and a 16-bit CRC.
+	resyncHeadBits    = 32
+	resyncHeaderEvery = 4
+)
+
+// resyncOverhead returns how many 1-bit positions block index uses before
+// its payload bytes.
+func resyncOverhead(index int) int {
+	if index%resyncHeaderEvery == 0 {
+		return resyncMarkerBits + resyncHeadBits + HeaderBits
+	}
+	return resyncMarkerBits + resyncHeadBits
+}
+
+// PartialError is returned by Bitstream.Extract when resynchronisation
+// found the payload but some of its blocks were lost. Payload holds what was
+// recovered, with the lost blocks zeroed, for callers that can use a
+// payload with gaps.
+type PartialError struct {
+	Payload []byte
+	Blocks  int
+	Lost    []int
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("lost %d of %d payload blocks, starting with block %d", len(e.Lost), e.Blocks, e.Lost[0])
+}
+
+// framePositions returns the embeddable positions of every audio frame,
+// those findEmbeddablePositions lists grouped by frame, except that every
+// frame is judged on its own bytes: the byte after it always counts as a
+// frame sync. A frame thus keeps its positions when the frames around it
+// are cut.
+func framePositions(mp3Data []byte) [][]int {
+	stream, err := mp3frame.Parse(mp3Data)
+	if err != nil {
+		return nil
+	}
+
+	frames := stream.Frames
+	if stream.Tag != nil {
+		frames = frames[1:]
+	}
+
+	grouped := make([][]int, len(frames))
+	for n, f := range frames {
+		frame := mp3Data[f.Offset : f.Offset+f.Length]
+		sync := func(j int) bool {
+			next := byte(0xFF)
+			if j+1 < len(frame) {
+				next = frame[j+1]
+			}
+			return j >= 0 && frame[j]&0xF0 == 0xF0 && next&0xE0 == 0xE0
+		}
+		for i := range frame {
+			if !sync(i) && !sync(i-1) && !sync(i-2) && !sync(i-3) {
+				grouped[n] = append(grouped[n], f.Offset+i)
+			}
+		}
+	}
+	return grouped
+}
+
+// resyncLayout gives the payload bytes of every block. All blocks use the
+// same positions, so those without a header copy hold HeaderSize*nLsb
+// bytes more.
+type resyncLayout struct {
+	// base is the size of a block with a header copy, which the header
+	// records in Param.
+	base, nLsb int
+}
+
+func (l resyncLayout) size(index int) int {
+	if index%resyncHeaderEvery == 0 {
+		return l.base
+	}
+	return l.base + HeaderSize*l.nLsb
+}
+
+// offset returns where the bytes of block index start in the payload.
+func (l resyncLayout) offset(index int) int {
+	group := resyncHeaderEvery*l.base + (resyncHeaderEvery-1)*HeaderSize*l.nLsb
+	offset := index / resyncHeaderEvery * group
+	for i := index - index%resyncHeaderEvery; i < index; i++ {
+		offset += l.size(i)
+	}
+	return offset
+}
+
+// blocks returns how many blocks n payload bytes take. Even an empty
+// payload takes one, for its header.
+func (l resyncLayout) blocks(n int) int {
+	count := max(n/l.offset(resyncHeaderEvery)*resyncHeaderEvery-1, 0)
+	for l.offset(count+1) < n {
+		count++
+	}
+	return count + 1
+}
+
+// resyncPlan returns the frames that carry blocks, in block order, and the
+// layout of the blocks: a block with a header copy holds the most that
+// nine frames in ten can hold. Smaller frames are skipped.
+func resyncPlan(frames [][]int, nLsb int) ([][]int, resyncLayout) {
+	capacity := func(positions []int) int {
+		return max(len(positions)-resyncOverhead(0), 0) * nLsb / 8
+	}
+	if len(frames) == 0 {
+		return nil, resyncLayout{}
+	}
+
+	capacities := make([]int, len(frames))
+	for i, positions := range frames {
+		capacities[i] = capacity(positions)
+	}
+	sort.Ints(capacities)
+	blockSize := min(capacities[len(capacities)/10], 0xFFFF)
+	if blockSize == 0 {
+		return nil, resyncLayout{}
+	}
+
+	var carriers [][]int
+	for _, positions := range frames {
+		if capacity(positions) >= blockSize {
+			carriers = append(carriers, positions)
+		}
+	}
+	return carriers, resyncLayout{base: blockSize, nLsb: nLsb}
+}
+
+func resyncCapacity(cover []byte, nLsb int) (int, error) {
+	carriers, layout := resyncPlan(framePositions(cover), nLsb)
+	if layout.base == 0 {
+		return 0, fmt.Errorf("frames too small for resync blocks")
+	}
+	return layout.offset(len(carriers)), nil
+}
+
+// resyncMarker returns the sync marker of stegoKey.
+func resyncMarker(stegoKey string) []bool {
+	sum := sha256.Sum256([]byte("sync:" + stegoKey))
+	return BytesToBits(sum[:resyncMarkerBits/8])
+}
+
+// resyncHead returns the head of block index, whitened, with a CRC over the
+// index and the unwhitened body.
+func resyncHead(index int, body []byte, stegoKey string) []byte {
+	head := binary.LittleEndian.AppendUint16(nil, uint16(index))
+	crc := crc32.ChecksumIEEE(append(append([]byte(nil), head...), body...))
+	head = binary.LittleEndian.AppendUint16(head, uint16(crc))
+	return xorStream(head, keystream("resync:", stegoKey, len(head)))
+}
+
+// resyncBody whitens or unwhitens the header copy and payload bytes of
+// block index.
+func resyncBody(index int, body []byte, stegoKey string) []byte {
+	return xorStream(body, keystream(fmt.Sprintf("resync %d:", index), stegoKey, len(body)))
+}
+
+func xorStream(data, stream []byte) []byte {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ stream[i]
+	}
+	return out
+}
+
+// writeResync writes header and payload as resync blocks.
+func writeResync(stego []byte, header *Header, payload []byte, stegoKey string, write lsbWriter) error {
+	carriers, layout := resyncPlan(framePositions(stego), header.NLsb)
+	if layout.base == 0 {
+		return fmt.Errorf("frames too small for resync blocks")
+	}
+	blocks := layout.blocks(len(payload))
+	if blocks > len(carriers) || blocks > 0xFFFF {
+		return fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, layout.offset(len(carriers))*8)
+	}
+	header.Param = layout.base
+
+	fmt.Printf("Embedding %d bits in %d resync blocks of %d-%d bytes, one per frame, using %d LSBs (capacity %d bits)\n",
+		len(payload)*8, blocks, layout.size(0), layout.size(1), header.NLsb, layout.offset(len(carriers))*8)
+
+	marker := resyncMarker(stegoKey)
+	for index := 0; index < blocks; index++ {
+		positions := carriers[index]
+		data := make([]byte, layout.size(index))
+		if start := layout.offset(index); start < len(payload) {
+			copy(data, payload[start:])
+		}
+
+		var body []byte
+		if index%resyncHeaderEvery == 0 {
+			var err error
+			if body, err = header.Marshal(stegoKey); err != nil {
+				return err
+			}
+		}
+		body = append(body, data...)
+
+		bits := append(append([]bool(nil), marker...), BytesToBits(resyncHead(index, body, stegoKey))...)
+		body = resyncBody(index, body, stegoKey)
+		headerBytes := len(body) - len(data)
+		bits = append(bits, BytesToBits(body[:headerBytes])...)
+		for i, bit := range bits {
+			write(stego, positions[i], []bool{bit})
+		}
+
+		dataBits := BytesToBits(body[headerBytes:])
+		dataPositions := positions[len(bits):]
+		for i := 0; i*header.NLsb < len(dataBits); i++ {
+			write(stego, dataPositions[i], dataBits[i*header.NLsb:min((i+1)*header.NLsb, len(dataBits))])
+		}
+	}
+	return nil
+}
+
+// resyncCandidate is a frame that starts with the sync marker.
+type resyncCandidate struct {
+	index     int
+	crc       uint16
+	positions []int
+}
+
+// readLSBs returns bit 0 of the bytes at positions.
+func readLSBs(stego []byte, positions []int) []bool {
+	bits := make([]bool, len(positions))
+	for i, pos := range positions {
+		bits[i] = stego[pos]&0x01 == 1
+	}
+	return bits
+}
+
+// scanResync finds every frame starting with the sync marker of stegoKey.
+func scanResync(stego []byte, stegoKey string) []resyncCandidate {
+	marker := resyncMarker(stegoKey)
+	var candidates []resyncCandidate
+	for _, positions := range framePositions(stego) {
+		if len(positions) < resyncMarkerBits+resyncHeadBits {
+			continue
+		}
+		found := readLSBs(stego, positions[:resyncMarkerBits])
+		match := true
+		for i := range marker {
+			match = match && found[i] == marker[i]
+		}
+		if !match {
+			continue
+		}
+
+		head := BitsToBytes(readLSBs(stego, positions[resyncMarkerBits:resyncMarkerBits+resyncHeadBits]))
+		head = xorStream(head, keystream("resync:", stegoKey, len(head)))
+		candidates = append(candidates, resyncCandidate{
+			index:     int(binary.LittleEndian.Uint16(head[0:2])),
+			crc:       binary.LittleEndian.Uint16(head[2:4]),
+			positions: positions,
+		})
+	}
+	return candidates
+}
+
+// readResyncBlock returns the payload bytes of c and whether its CRC holds,
+// given the layout header records.
+func readResyncBlock(stego []byte, c resyncCandidate, header *Header, stegoKey string) ([]byte, bool) {
+	start := resyncMarkerBits + resyncHeadBits
+	overhead := resyncOverhead(c.index)
+	blockSize := resyncLayout{base: header.Param, nLsb: header.NLsb}.size(c.index)
+	needed := overhead + (blockSize*8+header.NLsb-1)/header.NLsb
+	if len(c.positions) < needed {
+		return nil, false
+	}
+
+	bits := readLSBs(stego, c.positions[start:overhead])
+	for _, pos := range c.positions[overhead:needed] {
+		for i := 0; i < header.NLsb; i++ {
+			bits = append(bits, (stego[pos]>>i)&1 == 1)
+		}
+	}
+	body := resyncBody(c.index, BitsToBytes(bits[:(overhead-start)+blockSize*8]), stegoKey)
+
+	head := binary.LittleEndian.AppendUint16(nil, uint16(c.index))
+	if uint16(crc32.ChecksumIEEE(append(head, body...))) != c.crc {
+		return nil, false
+	}
+	return body[len(body)-blockSize:], true
+}
+
+// readResync scans stego for resync blocks and returns the parameter header
+// of the first intact copy and the payload bytes of every intact block by
+// index.
+func readResync(stego []byte, stegoKey string) (*Header, map[int][]byte, error) {
+	candidates := scanResync(stego, stegoKey)
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("no resync markers found")
+	}
+
+	var header *Header
+	for _, c := range candidates {
+		if c.index%resyncHeaderEvery != 0 || len(c.positions) < resyncOverhead(c.index) {
+			continue
+		}
+		start := resyncMarkerBits + resyncHeadBits
+		copied := BitsToBytes(readLSBs(stego, c.positions[start:start+HeaderBits]))
+		h, err := ParseHeader(resyncBody(c.index, copied, stegoKey), stegoKey)
+		if err != nil || h.MethodID != BitstreamID || h.Param == 0 {
+			continue
+		}
+		if _, ok := readResyncBlock(stego, c, h, stegoKey); ok {
+			header = h
+			break
+		}
+	}
+	if header == nil {
+		return nil, nil, fmt.Errorf("found %d resync blocks but no intact parameter header", len(candidates))
+	}
+
+	blocks := make(map[int][]byte)
+	for _, c := range candidates {
+		if _, ok := blocks[c.index]; ok {
+			continue
+		}
+		if data, ok := readResyncBlock(stego, c, header, stegoKey); ok {
+			blocks[c.index] = data
+		}
+	}
+	return header, blocks, nil
+}
+
+// extractResync reassembles the payload from the intact resync blocks. It
+// returns a *PartialError when blocks are missing.
+func extractResync(stego []byte, stegoKey string) ([]byte, error) {
+	header, blocks, err := readResync(stego, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := resyncLayout{base: header.Param, nLsb: header.NLsb}
+	count := layout.blocks(header.PayloadLength)
+	payload := make([]byte, layout.offset(count))
+	var lost []int
+	for index := 0; index < count; index++ {
+		data, ok := blocks[index]
+		if !ok {
+			lost = append(lost, index)
+			continue
+		}
+		copy(payload[layout.offset(index):], data)
+	}
+	payload = payload[:header.PayloadLength]
+
+	if len(lost) > 0 {
+		return nil, &PartialError{Payload: payload, Blocks: count, Lost: lost}
+	}
+	return payload, nil
+}
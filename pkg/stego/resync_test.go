@@ -0,0 +1,143 @@
+package stego
+
+import (
+	"errors"
+	"testing"
+
+	"audio-steganography-lsb/pkg/attacks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resyncPayload returns a payload ending halfway into resync block
+// blocks-1 of the cover, and the offset of block 2.
+func resyncPayload(t *testing.T, cover []byte, nLsb, blocks int) ([]byte, int) {
+	t.Helper()
+	_, layout := resyncPlan(framePositions(cover), nLsb)
+	require.Greater(t, layout.base, 0)
+	payload := make([]byte, layout.offset(blocks)-layout.size(blocks-1)/2)
+	for i := range payload {
+		payload[i] = byte(i*37 + i/5)
+	}
+	require.Equal(t, blocks, layout.blocks(len(payload)))
+	return payload, layout.offset(2)
+}
+
+func TestResyncRoundTrip(t *testing.T) {
+	cover := readCover(t)
+	for _, params := range []*Params{
+		{StegoKey: "resynckey", NLsb: 1, Resync: true},
+		{StegoKey: "resynckey", NLsb: 3, Resync: true, Matching: true, NoiseFill: true},
+	} {
+		payload, _ := resyncPayload(t, cover, params.NLsb, 9)
+		stego, err := Bitstream{}.Embed(cover, payload, params)
+		require.NoError(t, err)
+
+		extracted, err := Bitstream{}.Extract(stego, "resynckey")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+		assert.True(t, Bitstream{}.Detect(stego, "resynckey"))
+		assert.False(t, Bitstream{}.Detect(stego, "otherkey"))
+
+		m, err := Detect(stego, "resynckey")
+		require.NoError(t, err)
+		assert.Equal(t, BitstreamID, m.ID())
+	}
+
+	empty, err := Bitstream{}.Embed(cover, nil, &Params{StegoKey: "resynckey", NLsb: 1, Resync: true})
+	require.NoError(t, err)
+	extracted, err := Bitstream{}.Extract(empty, "resynckey")
+	require.NoError(t, err)
+	assert.Empty(t, extracted)
+}
+
+func TestResyncCapacity(t *testing.T) {
+	cover := readCover(t)
+	params := &Params{StegoKey: "resynckey", NLsb: 2, Resync: true}
+	capacity, err := Bitstream{}.Capacity(cover, params)
+	require.NoError(t, err)
+	// Most positions carry payload bits; markers, heads and header copies
+	// take the rest.
+	positions := len(findEmbeddablePositions(cover))
+	assert.Greater(t, capacity*8, positions*2*2/3)
+	assert.Less(t, capacity*8, positions*2)
+
+	_, err = Bitstream{}.Embed(cover, make([]byte, capacity), params)
+	require.NoError(t, err)
+	_, err = Bitstream{}.Embed(cover, make([]byte, capacity+1), params)
+	assert.ErrorContains(t, err, "data too large")
+
+	_, err = Bitstream{}.Embed(cover, []byte("x"), &Params{StegoKey: "resynckey", NLsb: 1, Resync: true, MatrixEmbedding: true})
+	assert.Error(t, err)
+}
+
+func TestResyncSurvivesCuts(t *testing.T) {
+	cover := readCover(t)
+	params := &Params{StegoKey: "resynckey", NLsb: 1, Resync: true}
+	payload, block2 := resyncPayload(t, cover, 1, 9)
+	stego, err := Bitstream{}.Embed(cover, payload, params)
+	require.NoError(t, err)
+
+	// The end of the file and silence in front carry no blocks.
+	trimmed, err := attacks.TrimEnd(stego, 100)
+	require.NoError(t, err)
+	lead, err := attacks.Silence(stego, 1)
+	require.NoError(t, err)
+	joined, err := attacks.Concatenate(lead, trimmed)
+	require.NoError(t, err)
+	extracted, err := Bitstream{}.Extract(joined, "resynckey")
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+
+	// Cutting two frames loses the first two blocks and the header copy in
+	// block 0; the copy in block 4 still describes the rest.
+	cut, err := attacks.TrimStart(stego, 2)
+	require.NoError(t, err)
+	_, err = Bitstream{}.Extract(cut, "resynckey")
+	var partial *PartialError
+	require.True(t, errors.As(err, &partial), "error %v", err)
+	assert.Equal(t, []int{0, 1}, partial.Lost)
+	assert.Equal(t, 9, partial.Blocks)
+	require.Len(t, partial.Payload, len(payload))
+	assert.Equal(t, make([]byte, block2), partial.Payload[:block2])
+	assert.Equal(t, payload[block2:], partial.Payload[block2:])
+
+	// Without resync blocks the same cut loses everything.
+	plain, err := Bitstream{}.Embed(cover, payload, &Params{StegoKey: "resynckey", NLsb: 1})
+	require.NoError(t, err)
+	cut, err = attacks.TrimStart(plain, 2)
+	require.NoError(t, err)
+	_, err = Bitstream{}.Extract(cut, "resynckey")
+	assert.False(t, errors.As(err, &partial))
+	assert.Error(t, err)
+}
+
+func TestResyncRejectsDamagedBlocks(t *testing.T) {
+	cover := readCover(t)
+	payload, block2 := resyncPayload(t, cover, 1, 9)
+	stego, err := Bitstream{}.Embed(cover, payload, &Params{StegoKey: "resynckey", NLsb: 1, Resync: true})
+	require.NoError(t, err)
+
+	// Flip one payload bit in every block from the third on.
+	candidates := scanResync(stego, "resynckey")
+	require.Len(t, candidates, 9)
+	damaged := append([]byte(nil), stego...)
+	for _, c := range candidates[2:] {
+		damaged[c.positions[resyncOverhead(c.index)+3]] ^= 1
+	}
+
+	_, err = Bitstream{}.Extract(damaged, "resynckey")
+	var partial *PartialError
+	require.True(t, errors.As(err, &partial), "error %v", err)
+	assert.Equal(t, []int{2, 3, 4, 5, 6, 7, 8}, partial.Lost)
+	assert.Equal(t, payload[:block2], partial.Payload[:block2])
+
+	// Once every header copy is damaged nothing can be read.
+	for _, c := range candidates[:1] {
+		damaged[c.positions[resyncMarkerBits+resyncHeadBits]] ^= 1
+	}
+	_, err = Bitstream{}.Extract(damaged, "resynckey")
+	assert.False(t, errors.As(err, &partial))
+	assert.Error(t, err)
+}
@@ -0,0 +1,972 @@
+package stego
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/lame"
+	"audio-steganography-lsb/pkg/mp3frame"
+	"audio-steganography-lsb/pkg/qim"
+	"audio-steganography-lsb/pkg/utils"
+)
+
+const (
+	LSBID               byte = 2
+	LSBRobustID         byte = 3
+	MP3CompatibleID     byte = 4
+	QuantizationNoiseID byte = 5
+	CodecAwareID        byte = 6
+)
+
+const defaultBitrate = 192
+
+// sampleScheme is how one sample-domain method hides bits. A carrier is a
+// run of span() consecutive samples holding bitsPerCarrier(h) bits.
+type sampleScheme interface {
+	span() int
+	bitsPerCarrier(h *Header) int
+	write(carrier []int16, bits []bool, h *Header, index int, stegoKey string)
+	read(carrier []int16, h *Header, index int, stegoKey string) []bool
+	// headerParam is the Param used for the parameter header itself, which
+	// has to be readable before the real Param is known.
+	headerParam() int
+	// param chooses the Param recorded in the header for this cover.
+	param(pcm *audio.PCM, params *Params) int
+	// guardBits is how many low bits of a sample write may change; it
+	// never touches the bits above them. Adaptive embedding scores blocks
+	// on those bits only, so extraction sees the same scores. It is -1 when
+	// write can carry into any bit.
+	guardBits(h *Header) int
+}
+
+// sampleMethod runs the decode -> modify -> write WAV pipeline shared by
+// every method that works on PCM samples.
+type sampleMethod struct {
+	name   string
+	id     byte
+	scheme sampleScheme
+}
+
+func init() {
+	Register(&sampleMethod{name: "lsb", id: LSBID, scheme: lsbScheme{}})
+	Register(&sampleMethod{name: "lsb-robust", id: LSBRobustID, scheme: robustScheme{}})
+	Register(&sampleMethod{name: "mp3-compatible", id: MP3CompatibleID, scheme: parityScheme{}})
+	Register(&sampleMethod{name: "quantization-noise", id: QuantizationNoiseID, scheme: ditherScheme{}})
+	Register(&sampleMethod{name: "codec-aware", id: CodecAwareID, scheme: codecAwareScheme{}})
+}
+
+func (m *sampleMethod) Name() string { return m.name }
+
+func (m *sampleMethod) ID() byte { return m.id }
+
+func (m *sampleMethod) headerConfig() *Header {
+	return &Header{MethodID: m.id, NLsb: 1, Param: m.scheme.headerParam()}
+}
+
+// headerSamples is the number of samples holding the parameter header h,
+// rounded up to whole sample frames. The header is always written
+// interleaved, since the layout is only known once it has been read.
+func (m *sampleMethod) headerSamples(h *Header, channels int) int {
+	n := headerBits(h.Deniable) * m.scheme.span()
+	return (n + channels - 1) / channels * channels
+}
+
+// sampleRegion is the run of samples [start, end) one payload occupies. It
+// covers whole sample frames and starts with the parameter header.
+type sampleRegion struct {
+	start, end int
+}
+
+// region converts a range of sample frames, the unit utils.Region splits,
+// into a sampleRegion.
+func region(pcm *audio.PCM, startFrame, endFrame int) sampleRegion {
+	return sampleRegion{start: startFrame * pcm.Channels, end: endFrame * pcm.Channels}
+}
+
+// blocks returns the region covering blocks [first, first+count).
+func blocks(pcm *audio.PCM, first, count int) sampleRegion {
+	start, end := utils.Region(frames(pcm), first, count)
+	return region(pcm, start, end)
+}
+
+func frames(pcm *audio.PCM) int {
+	return len(pcm.Samples) / pcm.Channels
+}
+
+// mixdown averages the channels of every sample frame.
+func mixdown(pcm *audio.PCM) []float64 {
+	mono := make([]float64, frames(pcm))
+	for i := range mono {
+		var sum float64
+		for ch := 0; ch < pcm.Channels; ch++ {
+			sum += float64(pcm.Samples[i*pcm.Channels+ch])
+		}
+		mono[i] = sum / float64(pcm.Channels)
+	}
+	return mono
+}
+
+// loudLevel is the mixdown level from which audio no longer counts as
+// silence. Methods that cannot hide anything in silence start after it.
+const loudLevel = 256
+
+// firstLoud returns the first frame of mono at loudLevel or above, or 0 if
+// there is none.
+func firstLoud(mono []float64) int {
+	for i, v := range mono {
+		if math.Abs(v) >= loudLevel {
+			return i
+		}
+	}
+	return 0
+}
+
+// perChannel returns how many payload carriers fit in each channel of r
+// when the payload is laid out per channel.
+func (m *sampleMethod) perChannel(pcm *audio.PCM, r sampleRegion, h *Header) int {
+	frames := (r.end - r.start - m.headerSamples(h, pcm.Channels)) / pcm.Channels
+	return frames / m.scheme.span()
+}
+
+// carriers returns the number of payload carriers in r, or -1 if the
+// header does not fit.
+func (m *sampleMethod) carriers(pcm *audio.PCM, r sampleRegion, h *Header) int {
+	samples := r.end - r.start - m.headerSamples(h, pcm.Channels)
+	if samples < 0 {
+		return -1
+	}
+	if h.Layout == LayoutPerChannel {
+		return m.perChannel(pcm, r, h) * pcm.Channels
+	}
+	return samples / m.scheme.span()
+}
+
+// carrierBits returns how many payload bits carrier i of r holds.
+func (m *sampleMethod) carrierBits(pcm *audio.PCM, r sampleRegion, h *Header, i int) int {
+	if !h.VariableLsb {
+		return m.scheme.bitsPerCarrier(h)
+	}
+	return amplitudeBits(pcm.Samples[m.payloadCarrier(pcm, r, h, i)[0]], h.NLsb)
+}
+
+// capacityBits returns how many payload bits r holds after the header, or
+// -1 if the header does not fit.
+func (m *sampleMethod) capacityBits(pcm *audio.PCM, r sampleRegion, h *Header) int {
+	n := m.carriers(pcm, r, h)
+	if n < 0 || !h.VariableLsb {
+		return n * m.scheme.bitsPerCarrier(h)
+	}
+	total := 0
+	for i := 0; i < n; i++ {
+		total += m.carrierBits(pcm, r, h, i)
+	}
+	return total
+}
+
+// fits reports whether r holds the header and h.PayloadLength bytes,
+// h.Adaptive times over for adaptive embedding.
+func (m *sampleMethod) fits(pcm *audio.PCM, r sampleRegion, h *Header) bool {
+	n := m.capacityBits(pcm, r, h)
+	spread := h.Adaptive
+	if spread < 1 {
+		spread = 1
+	}
+	return n >= 0 && n >= h.PayloadLength*8*spread
+}
+
+// amplitudeBits returns how many low bits of s carry payload in variable
+// nLsb mode, at most nLsb. Only the bits above the fourth, which no nLsb
+// changes, are looked at, so embedder and extractor agree. A sample of
+// magnitude at least 2^(n+4) carries n bits, keeping every change at least
+// 24 dB below the sample it is made in; samples under 32 carry nothing.
+func amplitudeBits(s int16, nLsb int) int {
+	a := int(s >> 4)
+	if a < 0 {
+		a = ^a
+	}
+	n := bits.Len(uint(a)) - 1
+	if n < 0 {
+		return 0
+	}
+	if n > nLsb {
+		return nLsb
+	}
+	return n
+}
+
+// variableLsb checks that the method supports params.VariableLsb.
+func (m *sampleMethod) variableLsb(params *Params) error {
+	if _, ok := m.scheme.(lsbScheme); params.VariableLsb && !ok {
+		return fmt.Errorf("%s method does not support variable nLsb", m.name)
+	}
+	return nil
+}
+
+func (m *sampleMethod) layout(pcm *audio.PCM, r sampleRegion, h *Header, stegoKey string) (order []int, err error) {
+	carriers := m.carriers(pcm, r, h)
+	if carriers < 0 {
+		return nil, fmt.Errorf("not enough samples for parameter header")
+	}
+
+	// GeneratePositions hands out n*nLsb/8 positions, so asking with 8
+	// makes every carrier after the header available.
+	if h.Adaptive == 0 {
+		order, err = utils.GeneratePositions(stegoKey, h.UseRandomSeed, carriers, 8)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate positions: %w", err)
+		}
+		return order, nil
+	}
+
+	selected := m.loudest(pcm, r, h, carriers, h.PayloadLength*8)
+	order, err = utils.GeneratePositions(stegoKey, h.UseRandomSeed, len(selected), 8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate positions: %w", err)
+	}
+	for i, j := range order {
+		order[i] = selected[j]
+	}
+	return order, nil
+}
+
+// adaptiveBlock is the number of payload carriers scored together by
+// adaptive embedding.
+const adaptiveBlock = 576
+
+// loudest returns, in increasing order, the payload carriers of the
+// highest-scoring blocks of r, taking whole blocks until they hold at least
+// need bits. A block scores the energy of its samples with the
+// guard bits shifted out, which embedding never changes.
+func (m *sampleMethod) loudest(pcm *audio.PCM, r sampleRegion, h *Header, carriers, need int) []int {
+	shift := uint(m.scheme.guardBits(h))
+	scores := make([]int64, (carriers+adaptiveBlock-1)/adaptiveBlock)
+	held := make([]int, len(scores))
+	for i := 0; i < carriers; i++ {
+		for _, k := range m.payloadCarrier(pcm, r, h, i) {
+			v := int64(pcm.Samples[k] >> shift)
+			scores[i/adaptiveBlock] += v * v
+		}
+		held[i/adaptiveBlock] += m.carrierBits(pcm, r, h, i)
+	}
+
+	ranked := make([]int, len(scores))
+	for b := range ranked {
+		ranked[b] = b
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+
+	chosen := 0
+	for n := 0; n < need && chosen < len(ranked); chosen++ {
+		n += held[ranked[chosen]]
+	}
+	picked := append([]int(nil), ranked[:chosen]...)
+	sort.Ints(picked)
+
+	var selected []int
+	for _, b := range picked {
+		for i := b * adaptiveBlock; i < carriers && i < (b+1)*adaptiveBlock; i++ {
+			selected = append(selected, i)
+		}
+	}
+	return selected
+}
+
+// headerCarrier returns the sample indices of header carrier i of r.
+func (m *sampleMethod) headerCarrier(r sampleRegion, i int) []int {
+	span := m.scheme.span()
+	indices := make([]int, span)
+	for j := range indices {
+		indices[j] = r.start + i*span + j
+	}
+	return indices
+}
+
+// payloadCarrier returns the sample indices of payload carrier i of r. Per
+// channel, carrier i lives in channel i/perChannel.
+func (m *sampleMethod) payloadCarrier(pcm *audio.PCM, r sampleRegion, h *Header, i int) []int {
+	span := m.scheme.span()
+	start := r.start + m.headerSamples(h, pcm.Channels)
+	indices := make([]int, span)
+	if h.Layout == LayoutPerChannel {
+		perChannel := m.perChannel(pcm, r, h)
+		ch, k := i/perChannel, i%perChannel
+		for j := range indices {
+			indices[j] = start + (k*span+j)*pcm.Channels + ch
+		}
+		return indices
+	}
+	for j := range indices {
+		indices[j] = start + i*span + j
+	}
+	return indices
+}
+
+func (m *sampleMethod) write(samples []int16, indices []int, bits []bool, h *Header, index int, stegoKey string) {
+	carrier := make([]int16, len(indices))
+	for j, k := range indices {
+		carrier[j] = samples[k]
+	}
+	m.scheme.write(carrier, bits, h, index, stegoKey)
+	for j, k := range indices {
+		samples[k] = carrier[j]
+	}
+}
+
+func (m *sampleMethod) read(samples []int16, indices []int, h *Header, index int, stegoKey string) []bool {
+	carrier := make([]int16, len(indices))
+	for j, k := range indices {
+		carrier[j] = samples[k]
+	}
+	return m.scheme.read(carrier, h, index, stegoKey)
+}
+
+func (m *sampleMethod) Capacity(cover []byte, params *Params) (int, error) {
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.variableLsb(params); err != nil {
+		return 0, err
+	}
+
+	h := &Header{MethodID: m.id, NLsb: params.NLsb, UseRandomSeed: params.UseRandomSeed, Layout: params.Layout, VariableLsb: params.VariableLsb, Deniable: params.Deniable}
+	n := m.capacityBits(pcm, blocks(pcm, 0, utils.Regions), h)
+	if n < 0 {
+		return 0, fmt.Errorf("not enough samples for parameter header")
+	}
+
+	return n / 8, nil
+}
+
+func (m *sampleMethod) Embed(cover, payload []byte, params *Params) ([]byte, error) {
+	return m.EmbedMany(cover, [][]byte{payload}, []string{params.StegoKey}, params)
+}
+
+func (m *sampleMethod) EmbedMany(cover []byte, payloads [][]byte, keys []string, params *Params) ([]byte, error) {
+	// Re-encoding to MP3 adds noise far above the bits these methods
+	// change, so an MP3 output would never give the payload back.
+	switch params.OutputFormat {
+	case "", "wav":
+	case "mp3":
+		return nil, fmt.Errorf("%s method cannot write MP3: re-encoding destroys the hidden bits; write WAV instead", m.name)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %q", params.OutputFormat)
+	}
+
+	pcm, err := decodeCover(cover)
+	if err != nil {
+		return nil, err
+	}
+	pcm = pcm.Clone()
+
+	if pcm.Channels > maxChannels {
+		return nil, fmt.Errorf("unsupported channel count: %d", pcm.Channels)
+	}
+
+	param := m.scheme.param(pcm, params)
+	if params.Adaptive && m.scheme.guardBits(&Header{NLsb: params.NLsb, Param: param}) < 0 {
+		return nil, fmt.Errorf("%s method does not support adaptive embedding", m.name)
+	}
+	if err := m.variableLsb(params); err != nil {
+		return nil, err
+	}
+
+	fillHeader := &Header{MethodID: m.id, NLsb: params.NLsb, Param: param, Channels: pcm.Channels, VariableLsb: params.VariableLsb}
+	if err := m.fill(pcm, fillHeader, params, keys); err != nil {
+		return nil, err
+	}
+
+	headers := make([]*Header, len(payloads))
+	regions := make([]sampleRegion, len(payloads))
+	plan := func(order []int, first int) (int, error) {
+		from := first
+		remaining := 0
+		for _, payload := range payloads {
+			remaining += len(payload)
+		}
+		for _, i := range order {
+			payload := payloads[i]
+			header := &Header{
+				MethodID:      m.id,
+				NLsb:          params.NLsb,
+				UseRandomSeed: params.UseRandomSeed,
+				PayloadLength: len(payload),
+				Param:         param,
+				Layout:        params.Layout,
+				Channels:      pcm.Channels,
+				Deniable:      hidesRegions(params, keys),
+				VariableLsb:   params.VariableLsb,
+			}
+
+			if params.Adaptive {
+				// Spread this payload over its share of what is left, so
+				// later payloads still find room.
+				header.Adaptive = 1
+				if len(payload) > 0 {
+					share := m.capacityBits(pcm, blocks(pcm, first, utils.Regions-first), header)
+					header.Adaptive = share / remaining / 8
+				}
+				if header.Adaptive < 1 {
+					header.Adaptive = 1
+				}
+				if header.Adaptive > maxAdaptive {
+					header.Adaptive = maxAdaptive
+				}
+			}
+			remaining -= len(payload)
+
+			var count int
+			for {
+				count = utils.RegionBlocks(frames(pcm), first, func(start, end int) bool {
+					return m.fits(pcm, region(pcm, start, end), header)
+				})
+				// Whole blocks and the headers of later payloads can leave
+				// slightly less than the share; spread a little less then.
+				if count > 0 || header.Adaptive <= 1 {
+					break
+				}
+				header.Adaptive--
+			}
+			if count == 0 {
+				r := blocks(pcm, first, utils.Regions-first)
+				capacity := m.capacityBits(pcm, r, header)
+				if capacity < 0 {
+					capacity = 0
+				}
+				return 0, fmt.Errorf("data too large: need %d bits, capacity is %d bits", len(payload)*8, capacity)
+			}
+			headers[i] = header
+			regions[i] = blocks(pcm, first, count)
+			first += count
+		}
+		return first - from, nil
+	}
+	if err := placeRegions(len(payloads), hidesRegions(params, keys), plan); err != nil {
+		return nil, err
+	}
+
+	for i, payload := range payloads {
+		if err := m.writeRegion(pcm, regions[i], headers[i], payload, keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return audio.EncodeWAV(pcm), nil
+}
+
+// fill writes noise into every carrier of the file, in file order, if
+// params asks for it. The payloads are written over it afterwards.
+func (m *sampleMethod) fill(pcm *audio.PCM, h *Header, params *Params, keys []string) error {
+	span := m.scheme.span()
+	perCarrier := m.scheme.bitsPerCarrier(h)
+	carriers := len(pcm.Samples) / span
+	noise, err := fillNoise(params, keys, (carriers*perCarrier+7)/8)
+	if err != nil || noise == nil {
+		return err
+	}
+
+	// The dithered schemes leave every carrier on a lattice offset by the
+	// key, so noise written under a recipient's key would show its holder
+	// where the other regions are.
+	stegoKey := keys[0]
+	if hidesRegions(params, keys) {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("failed to generate fill key: %w", err)
+		}
+		stegoKey = string(random)
+	}
+
+	bits := BytesToBits(noise)
+	whole := sampleRegion{start: 0, end: len(pcm.Samples)}
+	for i := 0; i < carriers; i++ {
+		n := perCarrier
+		if h.VariableLsb {
+			// Quiet samples stay untouched here too.
+			n = amplitudeBits(pcm.Samples[i], h.NLsb)
+		}
+		m.write(pcm.Samples, m.headerCarrier(whole, i), bits[i*perCarrier:i*perCarrier+n], h, i, stegoKey)
+	}
+	return nil
+}
+
+// writeRegion writes the header and payload into the samples of r.
+func (m *sampleMethod) writeRegion(pcm *audio.PCM, r sampleRegion, header *Header, payload []byte, stegoKey string) error {
+	order, err := m.layout(pcm, r, header, stegoKey)
+	if err != nil {
+		return err
+	}
+
+	headerBytes, err := header.Marshal(stegoKey)
+	if err != nil {
+		return err
+	}
+	if header.Deniable {
+		payload = whiten(payload, stegoKey, header.Nonce, HeaderSize)
+	}
+	bits := BytesToBits(payload)
+	capacity := 0
+	for _, i := range order {
+		capacity += m.carrierBits(pcm, r, header, i)
+	}
+	fmt.Printf("Embedding %d bits into %d samples (%d Hz, %d channels, %s) using %s (capacity %d bits)\n",
+		len(bits), r.end-r.start, pcm.SampleRate, pcm.Channels, header.Layout, m.name, capacity)
+
+	headerConfig := m.headerConfig()
+	for i, bit := range BytesToBits(headerBytes) {
+		m.write(pcm.Samples, m.headerCarrier(r, i), []bool{bit}, headerConfig, i, stegoKey)
+	}
+
+	for i, written := 0, 0; written < len(bits); i++ {
+		end := written + m.carrierBits(pcm, r, header, order[i])
+		if end > len(bits) {
+			end = len(bits)
+		}
+		if end > written {
+			m.write(pcm.Samples, m.payloadCarrier(pcm, r, header, order[i]), bits[written:end], header, len(headerBytes)*8+order[i], stegoKey)
+		}
+		written = end
+	}
+	return nil
+}
+
+func (m *sampleMethod) Extract(stego []byte, stegoKey string) ([]byte, error) {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return nil, err
+	}
+
+	header, r, err := m.findRegion(pcm, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+	if header.Channels != 0 && header.Channels != pcm.Channels {
+		return nil, fmt.Errorf("header records %d channels but the audio has %d", header.Channels, pcm.Channels)
+	}
+
+	order, err := m.layout(pcm, r, header, stegoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	needed := header.PayloadLength * 8
+	bits := make([]bool, 0, needed+header.NLsb)
+	for i := 0; len(bits) < needed; i++ {
+		n := m.carrierBits(pcm, r, header, order[i])
+		if n == 0 {
+			continue
+		}
+		bits = append(bits, m.read(pcm.Samples, m.payloadCarrier(pcm, r, header, order[i]), header, headerBits(header.Deniable)+order[i], stegoKey)[:n]...)
+	}
+
+	if header.Deniable {
+		return whiten(BitsToBytes(bits[:needed]), stegoKey, header.Nonce, HeaderSize), nil
+	}
+	return BitsToBytes(bits[:needed]), nil
+}
+
+func (m *sampleMethod) Detect(stego []byte, stegoKey string) bool {
+	pcm, err := decodeCover(stego)
+	if err != nil {
+		return false
+	}
+	_, _, err = m.findRegion(pcm, stegoKey)
+	return err == nil
+}
+
+// findRegion returns the header written with stegoKey and the region of
+// its payload.
+func (m *sampleMethod) findRegion(pcm *audio.PCM, stegoKey string) (*Header, sampleRegion, error) {
+	read := func(start int) (*Header, error) {
+		return m.readHeader(pcm, start*pcm.Channels, stegoKey)
+	}
+	fits := func(h *Header, start, end int) bool {
+		return m.fits(pcm, region(pcm, start, end), h)
+	}
+	header, start, end, err := findRegion(frames(pcm), read, fits)
+	if err != nil {
+		return nil, sampleRegion{}, err
+	}
+	return header, region(pcm, start, end), nil
+}
+
+// readHeader reads the parameter header stored from sample start on.
+func (m *sampleMethod) readHeader(pcm *audio.PCM, start int, stegoKey string) (*Header, error) {
+	if len(pcm.Samples)-start < m.headerSamples(&Header{}, pcm.Channels) {
+		return nil, fmt.Errorf("not enough samples for parameter header")
+	}
+
+	r := sampleRegion{start: start, end: len(pcm.Samples)}
+	headerConfig := m.headerConfig()
+	bits := make([]bool, min((len(pcm.Samples)-start)/m.scheme.span(), headerBits(true)))
+	for i := range bits {
+		bits[i] = m.read(pcm.Samples, m.headerCarrier(r, i), headerConfig, i, stegoKey)[0]
+	}
+
+	header, err := ParseHeader(BitsToBytes(bits), stegoKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameter header: %w", err)
+	}
+	if header.MethodID != m.id {
+		return nil, fmt.Errorf("header belongs to method %d", header.MethodID)
+	}
+	return header, nil
+}
+
+// lastDecoded keeps the last decoded file, keyed by its hash. mu guards it,
+// since the attack command and tests call methods from several goroutines.
+var lastDecoded struct {
+	mu  sync.Mutex
+	sum [sha256.Size]byte
+	pcm *audio.PCM
+}
+
+// decodeCover decodes a WAV or MP3 file. The last result is kept because
+// Detect runs every sample-domain method over the same file. Callers must
+// not modify the returned samples; clone them first.
+func decodeCover(data []byte) (*audio.PCM, error) {
+	sum := sha256.Sum256(data)
+	lastDecoded.mu.Lock()
+	if lastDecoded.pcm != nil && sum == lastDecoded.sum {
+		pcm := lastDecoded.pcm
+		lastDecoded.mu.Unlock()
+		return pcm, nil
+	}
+	lastDecoded.mu.Unlock()
+
+	pcm, err := audio.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+
+	lastDecoded.mu.Lock()
+	lastDecoded.sum = sum
+	lastDecoded.pcm = pcm
+	lastDecoded.mu.Unlock()
+	return pcm, nil
+}
+
+func coverBitrate(cover []byte) int {
+	if audio.IsWAV(cover) {
+		return defaultBitrate
+	}
+	stream, err := mp3frame.Parse(cover)
+	if err != nil {
+		return defaultBitrate
+	}
+	return stream.Frames[0].Header.Bitrate
+}
+
+func encodeOutput(cover []byte, pcm *audio.PCM, format string, bitrate int) ([]byte, error) {
+	if format == "" {
+		format = "mp3"
+		if audio.IsWAV(cover) {
+			format = "wav"
+		}
+	}
+
+	switch format {
+	case "wav":
+		return audio.EncodeWAV(pcm), nil
+	case "mp3":
+		encoder := lame.NewCodecAwareEncoder(pcm.SampleRate, pcm.Channels, bitrate)
+		data, err := encoder.EncodeMP3(pcm.Samples)
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %q", format)
+	}
+}
+
+// withCoverTags wraps a re-encoded MP3 in the tags of an MP3 cover. WAV
+// covers and WAV output are returned unchanged.
+func withCoverTags(cover, out []byte) []byte {
+	if audio.IsWAV(cover) || audio.IsWAV(out) {
+		return out
+	}
+	stream, err := mp3frame.Parse(cover)
+	if err != nil {
+		return out
+	}
+
+	leading, trailing := stream.Tags()
+	tagged := make([]byte, 0, len(leading)+len(out)+len(trailing))
+	tagged = append(tagged, leading...)
+	tagged = append(tagged, out...)
+	return append(tagged, trailing...)
+}
+
+// keyedUniform returns a value in [0, 1) that depends only on the key and
+// the carrier index, so embedder and extractor derive the same dither.
+func keyedUniform(stegoKey string, index, stream int) float64 {
+	seed := sha256.Sum256([]byte("dither:" + stegoKey))
+	x := binary.LittleEndian.Uint64(seed[:8]) ^ uint64(index)*0x9E3779B97F4A7C15 ^ uint64(stream)<<56
+	// splitmix64 finalizer
+	x ^= x >> 30
+	x *= 0xBF58476D1CE4E5B9
+	x ^= x >> 27
+	x *= 0x94D049BB133111EB
+	x ^= x >> 31
+	return float64(x>>11) / float64(1<<53)
+}
+
+func clampSample(v int) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+// lsbScheme is classic LSB replacement: nLsb bits per sample.
+type lsbScheme struct{}
+
+func (lsbScheme) span() int { return 1 }
+
+func (lsbScheme) bitsPerCarrier(h *Header) int { return h.NLsb }
+
+func (lsbScheme) write(carrier []int16, bits []bool, h *Header, index int, stegoKey string) {
+	for i, bit := range bits {
+		mask := int16(1) << i
+		carrier[0] &^= mask
+		if bit {
+			carrier[0] |= mask
+		}
+	}
+}
+
+func (lsbScheme) read(carrier []int16, h *Header, index int, stegoKey string) []bool {
+	bits := make([]bool, h.NLsb)
+	for i := range bits {
+		bits[i] = (carrier[0]>>i)&1 == 1
+	}
+	return bits
+}
+
+func (lsbScheme) headerParam() int { return 0 }
+
+func (lsbScheme) param(pcm *audio.PCM, params *Params) int { return 0 }
+
+func (lsbScheme) guardBits(h *Header) int { return h.NLsb }
+
+// robustScheme writes each bit three times into bit plane nLsb+1 and
+// re-centres the bits below it, so that small distortions of up to a
+// quarter of the plane size do not flip the bit. Extraction takes a
+// majority vote.
+type robustScheme struct{}
+
+func (robustScheme) span() int { return 3 }
+
+func (robustScheme) bitsPerCarrier(h *Header) int { return 1 }
+
+func (robustScheme) write(carrier []int16, bits []bool, h *Header, index int, stegoKey string) {
+	plane := uint(h.NLsb + 1)
+	block := 1 << (plane + 1)
+	for i := range carrier {
+		v := int(carrier[i]) &^ (block - 1)
+		if bits[0] {
+			v |= 1 << plane
+		}
+		v |= 1 << (plane - 1)
+		carrier[i] = clampSample(v)
+	}
+}
+
+func (robustScheme) read(carrier []int16, h *Header, index int, stegoKey string) []bool {
+	plane := uint(h.NLsb + 1)
+	votes := 0
+	for _, s := range carrier {
+		if (int(s)>>plane)&1 == 1 {
+			votes++
+		}
+	}
+	return []bool{votes*2 > len(carrier)}
+}
+
+func (robustScheme) headerParam() int { return 0 }
+
+func (robustScheme) param(pcm *audio.PCM, params *Params) int { return 0 }
+
+// guardBits covers the vote plane and the re-centred bits below it.
+func (robustScheme) guardBits(h *Header) int { return h.NLsb + 2 }
+
+// parityScheme encodes a bit in the parity of the sample magnitude: odd
+// for 1, even for 0. The sign is never changed.
+type parityScheme struct{}
+
+func (parityScheme) span() int { return 1 }
+
+func (parityScheme) bitsPerCarrier(h *Header) int { return 1 }
+
+func (parityScheme) write(carrier []int16, bits []bool, h *Header, index int, stegoKey string) {
+	s := int(carrier[0])
+	magnitude := s
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	if (magnitude%2 == 1) == bits[0] {
+		return
+	}
+	switch {
+	case s > 0:
+		s--
+	case s < 0:
+		s++
+	default:
+		s = 1
+	}
+	carrier[0] = int16(s)
+}
+
+func (parityScheme) read(carrier []int16, h *Header, index int, stegoKey string) []bool {
+	magnitude := int(carrier[0])
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	return []bool{magnitude%2 == 1}
+}
+
+func (parityScheme) headerParam() int { return 0 }
+
+func (parityScheme) param(pcm *audio.PCM, params *Params) int { return 0 }
+
+// guardBits is -1: stepping the magnitude by one can carry.
+func (parityScheme) guardBits(h *Header) int { return -1 }
+
+// ditherScheme quantizes each sample, offset by a keyed triangular (TPDF)
+// dither, onto a lattice of step Param and stores the bit in the parity of
+// the lattice index. The step is estimated from the RMS level of the cover.
+type ditherScheme struct{}
+
+const (
+	ditherHeaderStep = 16
+	ditherMinStep    = 4
+	ditherMaxStep    = 64
+)
+
+func (ditherScheme) span() int { return 1 }
+
+func (ditherScheme) bitsPerCarrier(h *Header) int { return 1 }
+
+func (ditherScheme) dither(step, index int, stegoKey string) float64 {
+	return (keyedUniform(stegoKey, index, 0) + keyedUniform(stegoKey, index, 1) - 1) * float64(step) / 2
+}
+
+func (d ditherScheme) write(carrier []int16, bits []bool, h *Header, index int, stegoKey string) {
+	step := float64(h.Param)
+	dither := d.dither(h.Param, index, stegoKey)
+	x := (float64(carrier[0]) + dither) / step
+	q := math.Round(x)
+	if (int64(q)&1 == 1) != bits[0] {
+		if x > q {
+			q++
+		} else {
+			q--
+		}
+	}
+	v := int(math.Round(q*step - dither))
+	if v > 32767 || v < -32768 {
+		// Move to the same-parity lattice point on the other side.
+		if v > 0 {
+			q -= 2
+		} else {
+			q += 2
+		}
+		v = int(math.Round(q*step - dither))
+	}
+	carrier[0] = clampSample(v)
+}
+
+func (d ditherScheme) read(carrier []int16, h *Header, index int, stegoKey string) []bool {
+	q := math.Round((float64(carrier[0]) + d.dither(h.Param, index, stegoKey)) / float64(h.Param))
+	return []bool{int64(q)&1 == 1}
+}
+
+func (ditherScheme) headerParam() int { return ditherHeaderStep }
+
+func (ditherScheme) guardBits(h *Header) int { return -1 }
+
+func (ditherScheme) param(pcm *audio.PCM, params *Params) int {
+	var sum float64
+	for _, s := range pcm.Samples {
+		sum += float64(s) * float64(s)
+	}
+	rms := 0.0
+	if len(pcm.Samples) > 0 {
+		rms = math.Sqrt(sum / float64(len(pcm.Samples)))
+	}
+
+	// Aim for quantization noise roughly 50 dB under the signal, scaled by
+	// the requested nLsb.
+	step := int(rms/256) << (params.NLsb - 1)
+	if step < ditherMinStep {
+		step = ditherMinStep
+	}
+	if step > ditherMaxStep {
+		step = ditherMaxStep
+	}
+	return step
+}
+
+// codecAwareScheme is dither-modulation QIM on single samples with a
+// keyed dither. The step is codecAwareMinStep doubled for every --lsb above
+// one and is recorded in Param, so extraction never has to guess it.
+type codecAwareScheme struct{}
+
+const (
+	codecAwareHeaderStep = 8
+	codecAwareMinStep    = 8
+)
+
+func (codecAwareScheme) span() int { return 1 }
+
+func (codecAwareScheme) bitsPerCarrier(h *Header) int { return 1 }
+
+// dither returns the keyed dither of a carrier, uniform over one step.
+func (codecAwareScheme) dither(step, index int, stegoKey string) float64 {
+	return (keyedUniform(stegoKey, index, 2) - 0.5) * float64(step)
+}
+
+func (c codecAwareScheme) write(carrier []int16, bits []bool, h *Header, index int, stegoKey string) {
+	q := qim.Quantizer{Step: float64(h.Param)}
+	y := math.Round(q.Embed(float64(carrier[0]), c.dither(h.Param, index, stegoKey), bits[0]))
+	// Move to the same lattice a step further in.
+	if y > 32767 {
+		y -= q.Step
+	} else if y < -32768 {
+		y += q.Step
+	}
+	carrier[0] = int16(y)
+}
+
+// soft returns the soft decision for the bit of a carrier.
+func (c codecAwareScheme) soft(carrier []int16, h *Header, index int, stegoKey string) float64 {
+	q := qim.Quantizer{Step: float64(h.Param)}
+	return q.Soft(float64(carrier[0]), c.dither(h.Param, index, stegoKey))
+}
+
+func (c codecAwareScheme) read(carrier []int16, h *Header, index int, stegoKey string) []bool {
+	return []bool{c.soft(carrier, h, index, stegoKey) > 0}
+}
+
+func (codecAwareScheme) headerParam() int { return codecAwareHeaderStep }
+
+func (codecAwareScheme) guardBits(h *Header) int { return -1 }
+
+func (codecAwareScheme) param(pcm *audio.PCM, params *Params) int {
+	return codecAwareMinStep << (params.NLsb - 1)
+}
@@ -0,0 +1,470 @@
+package stego
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"audio-steganography-lsb/pkg/audio"
+	"audio-steganography-lsb/pkg/mp3enc"
+	"audio-steganography-lsb/pkg/mp3frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wavCover decodes the first few seconds of the MP3 cover into a WAV file,
+// which keeps the sample-domain tests fast.
+func wavCover(t *testing.T) []byte {
+	t.Helper()
+	pcm, err := audio.DecodeMP3(readCover(t))
+	require.NoError(t, err)
+	pcm.Samples = pcm.Samples[:5*pcm.SampleRate*pcm.Channels]
+	return audio.EncodeWAV(pcm)
+}
+
+func TestSampleMethodsRoundTrip(t *testing.T) {
+	cover := wavCover(t)
+	payload := []byte("payload carried in the decoded samples")
+
+	for _, name := range []string{"lsb", "lsb-robust", "mp3-compatible", "quantization-noise", "codec-aware"} {
+		for _, params := range []*Params{
+			{StegoKey: "samplekey", NLsb: 1},
+			{StegoKey: "samplekey", NLsb: 3, UseRandomSeed: true, OutputFormat: "wav"},
+			{StegoKey: "samplekey", NLsb: 2, Layout: LayoutPerChannel},
+		} {
+			t.Run(name, func(t *testing.T) {
+				m, err := Lookup(name)
+				require.NoError(t, err)
+
+				stego, err := m.Embed(cover, payload, params)
+				require.NoError(t, err)
+				assert.True(t, audio.IsWAV(stego))
+
+				assert.True(t, m.Detect(stego, params.StegoKey))
+				assert.False(t, m.Detect(stego, "wrongkey"))
+
+				detected, err := Detect(stego, params.StegoKey)
+				require.NoError(t, err)
+				assert.Equal(t, m.ID(), detected.ID())
+
+				extracted, err := m.Extract(stego, params.StegoKey)
+				require.NoError(t, err)
+				assert.Equal(t, payload, extracted)
+			})
+		}
+	}
+}
+
+func TestSampleMethodMP3Cover(t *testing.T) {
+	pcm, err := audio.DecodeWAV(wavCover(t))
+	require.NoError(t, err)
+	pcm.Samples = pcm.Samples[:2*pcm.SampleRate*pcm.Channels]
+	cover, err := mp3enc.Encode(pcm.Samples, mp3enc.Config{SampleRate: pcm.SampleRate, Channels: 2, Bitrate: 128, Mode: mp3frame.ModeJointStereo})
+	require.NoError(t, err)
+
+	for _, name := range []string{"lsb", "lsb-robust", "mp3-compatible", "quantization-noise", "codec-aware"} {
+		m, err := Lookup(name)
+		require.NoError(t, err)
+
+		// An MP3 cover gives a WAV file, which extracts.
+		for _, payload := range [][]byte{[]byte("secret"), make([]byte, 6), {0x5A, 0xC3, 0x0F, 0xF0, 0x99, 0x66}} {
+			stego, err := m.Embed(cover, payload, &Params{StegoKey: "mp3cover", NLsb: 1})
+			require.NoError(t, err, name)
+			require.True(t, audio.IsWAV(stego), name)
+			extracted, err := m.Extract(stego, "mp3cover")
+			require.NoError(t, err, name)
+			assert.Equal(t, payload, extracted, name)
+		}
+
+		_, err = m.Embed(cover, []byte("lossy"), &Params{StegoKey: "mp3cover", NLsb: 1, OutputFormat: "mp3", CopyTags: true})
+		assert.ErrorContains(t, err, "cannot write MP3", name)
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	pcm, err := audio.DecodeWAV(wavCover(t))
+	require.NoError(t, err)
+	pcm.Samples = pcm.Samples[:pcm.SampleRate*pcm.Channels]
+	encoded, err := mp3enc.Encode(pcm.Samples, mp3enc.Config{SampleRate: pcm.SampleRate, Channels: 2, Bitrate: 128, Mode: mp3frame.ModeJointStereo})
+	require.NoError(t, err)
+	cover := withTags(t, encoded)
+
+	out, err := encodeOutput(cover, pcm, "", 160)
+	require.NoError(t, err)
+	stream, err := mp3frame.Parse(out)
+	require.NoError(t, err)
+	assert.Equal(t, 160, stream.Frames[0].Header.Bitrate)
+	decoded, err := audio.DecodeMP3(out)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(decoded.Samples), len(pcm.Samples)+2*mp3enc.Delay)
+
+	// Tags of the cover wrap the re-encoded audio unchanged.
+	coverStream, err := mp3frame.Parse(cover)
+	require.NoError(t, err)
+	leading, trailing := coverStream.Tags()
+	tagged := withCoverTags(cover, out)
+	stream, err = mp3frame.Parse(tagged)
+	require.NoError(t, err)
+	assert.Equal(t, leading, tagged[:stream.ID3v2Size])
+	assert.Equal(t, trailing, tagged[stream.AudioEnd:])
+	assert.Equal(t, out, tagged[stream.ID3v2Size:stream.AudioEnd])
+
+	// WAV output has nowhere to put MP3 tags.
+	wav, err := encodeOutput(cover, pcm, "wav", 160)
+	require.NoError(t, err)
+	assert.Equal(t, wav, withCoverTags(cover, wav))
+
+	mono := &audio.PCM{Samples: make([]int16, 44100), SampleRate: 44100, Channels: 1}
+	for i := range mono.Samples {
+		mono.Samples[i] = int16((i*29)%3000 - 1500)
+	}
+	out, err = encodeOutput(audio.EncodeWAV(mono), mono, "mp3", 128)
+	require.NoError(t, err)
+	decoded, err = audio.DecodeMP3(out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, decoded.Channels)
+	assert.Equal(t, 44100, decoded.SampleRate)
+
+	_, err = encodeOutput(cover, pcm, "ogg", 128)
+	assert.Error(t, err)
+}
+
+func TestSampleMethodWAVCover(t *testing.T) {
+	pcm := &audio.PCM{Samples: make([]int16, 40000), SampleRate: 8000, Channels: 1}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = int16((i*37)%2000 - 1000)
+	}
+	cover := audio.EncodeWAV(pcm)
+
+	m, err := Lookup("lsb")
+	require.NoError(t, err)
+
+	params := &Params{StegoKey: "wavkey", NLsb: 2}
+	capacity, err := m.Capacity(cover, params)
+	require.NoError(t, err)
+	assert.Equal(t, (len(pcm.Samples)-HeaderBits)*2/8, capacity)
+
+	payload := make([]byte, capacity)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	stego, err := m.Embed(cover, payload, params)
+	require.NoError(t, err)
+	require.True(t, audio.IsWAV(stego))
+
+	decoded, err := audio.DecodeWAV(stego)
+	require.NoError(t, err)
+	for i, s := range decoded.Samples {
+		diff := int(s) - int(pcm.Samples[i])
+		assert.LessOrEqual(t, diff*diff, 9)
+	}
+
+	extracted, err := m.Extract(stego, params.StegoKey)
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+
+	_, err = m.Embed(cover, make([]byte, capacity+1), params)
+	assert.Error(t, err)
+}
+
+func TestSampleMethodPerChannelLayout(t *testing.T) {
+	pcm := &audio.PCM{Samples: make([]int16, 20000), SampleRate: 8000, Channels: 2}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = int16((i*53)%4000 - 2000)
+	}
+	cover := audio.EncodeWAV(pcm)
+
+	m, err := Lookup("lsb-robust")
+	require.NoError(t, err)
+
+	params := &Params{StegoKey: "layout", NLsb: 1, Layout: LayoutPerChannel}
+	capacity, err := m.Capacity(cover, params)
+	require.NoError(t, err)
+	frames := (len(pcm.Samples) - HeaderBits*3) / 2
+	assert.Equal(t, frames/3*2/8, capacity)
+
+	payload := []byte("left channel only")
+	stego, err := m.Embed(cover, payload, params)
+	require.NoError(t, err)
+
+	decoded, err := audio.DecodeWAV(stego)
+	require.NoError(t, err)
+	// The payload takes the first 3 of 64 blocks, 468 frames: 168 for the
+	// header and 100 carriers per channel. The left channel holds bits
+	// 0-99, so only the first 36 right channel carriers are used and
+	// nothing after the region is touched.
+	for i := (168+36*3)*2 + 1; i < 468*2; i += 2 {
+		require.Equal(t, pcm.Samples[i], decoded.Samples[i], "sample %d", i)
+	}
+	for i := 468 * 2; i < len(pcm.Samples); i++ {
+		require.Equal(t, pcm.Samples[i], decoded.Samples[i], "sample %d", i)
+	}
+
+	extracted, err := m.Extract(stego, params.StegoKey)
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+}
+
+func TestRobustSurvivesNoise(t *testing.T) {
+	pcm := &audio.PCM{Samples: make([]int16, 30000), SampleRate: 8000, Channels: 1}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = int16((i*113)%6000 - 3000)
+	}
+
+	m, err := Lookup("lsb-robust")
+	require.NoError(t, err)
+
+	payload := []byte("survives small distortions")
+	stego, err := m.Embed(audio.EncodeWAV(pcm), payload, &Params{StegoKey: "robust", NLsb: 2})
+	require.NoError(t, err)
+
+	noisy, err := audio.DecodeWAV(stego)
+	require.NoError(t, err)
+	for i := range noisy.Samples {
+		noisy.Samples[i] += int16(i%3 - 1)
+	}
+	// Flip one sample of every carrier outright; the majority vote covers it.
+	for i := 0; i < len(noisy.Samples); i += 3 {
+		noisy.Samples[i] ^= 1 << 3
+	}
+
+	extracted, err := m.Extract(audio.EncodeWAV(noisy), "robust")
+	require.NoError(t, err)
+	assert.Equal(t, payload, extracted)
+}
+
+// codecAwareBER embeds a keyed bit in every sample of the cover with the
+// codec-aware quantizer at step, re-encodes to MP3 and reads the bits back
+// from the decoded samples, past the encoder delay. It returns the bit
+// error rate and the mean soft-decision magnitude of wrong and right bits.
+func codecAwareBER(t *testing.T, cover []byte, step, bitrate int) (ber, wrong, right float64) {
+	t.Helper()
+	pcm, err := audio.DecodeWAV(cover)
+	require.NoError(t, err)
+	h := &Header{Param: step}
+	scheme := codecAwareScheme{}
+	bits := make([]bool, len(pcm.Samples))
+	for i := range pcm.Samples {
+		bits[i] = keyedUniform("payload", i, 0) < 0.5
+		scheme.write(pcm.Samples[i:i+1], bits[i:i+1], h, i, "berkey")
+	}
+
+	data, err := encodeOutput(cover, pcm, "mp3", bitrate)
+	require.NoError(t, err)
+	decoded, err := audio.DecodeMP3(data)
+	require.NoError(t, err)
+
+	errors := 0
+	for i, bit := range bits {
+		j := i + mp3enc.Delay*pcm.Channels
+		soft := scheme.soft(decoded.Samples[j:j+1], h, i, "berkey")
+		if (soft > 0) != bit {
+			errors++
+			wrong += math.Abs(soft)
+		} else {
+			right += math.Abs(soft)
+		}
+	}
+	return float64(errors) / float64(len(bits)), wrong / float64(errors), right / float64(len(bits)-errors)
+}
+
+func TestCodecAwareBitErrorsAfterReencoding(t *testing.T) {
+	cover := wavCover(t)
+	step := codecAwareScheme{}.param(nil, &Params{NLsb: 1})
+
+	// At its own step the bits drown in the coding noise of a re-encode,
+	// which is hundreds of LSBs: the method needs WAV output.
+	ber, _, _ := codecAwareBER(t, cover, step, 192)
+	t.Logf("step %d: BER %.3f after re-encoding at 192 kbps", step, ber)
+	assert.Greater(t, ber, 0.4)
+
+	// Only steps far too coarse to be inaudible start to get through, and
+	// the soft decisions then tell the wrong bits from the right ones.
+	previous := ber
+	for _, coarse := range []int{1024, 4096} {
+		ber, wrong, right := codecAwareBER(t, cover, coarse, 192)
+		t.Logf("step %d: BER %.3f, soft magnitude %.2f wrong, %.2f right", coarse, ber, wrong, right)
+		assert.Less(t, ber, previous)
+		assert.Less(t, wrong, right)
+		previous = ber
+	}
+	assert.Less(t, previous, 0.25)
+}
+
+func TestCodecAwareStep(t *testing.T) {
+	cover := wavCover(t)
+	m, err := Lookup("codec-aware")
+	require.NoError(t, err)
+
+	// The step follows --lsb and nothing else, and the header records it.
+	for nLsb, want := range map[int]int{1: 8, 2: 16, 3: 32, 4: 64} {
+		for _, bitrate := range []int{0, 128, 320} {
+			stego, err := m.Embed(cover, []byte("step"), &Params{StegoKey: "stepkey", NLsb: nLsb, Bitrate: bitrate})
+			require.NoError(t, err)
+			pcm, err := audio.DecodeWAV(stego)
+			require.NoError(t, err)
+			header, _, err := m.(*sampleMethod).findRegion(pcm, "stepkey")
+			require.NoError(t, err)
+			assert.Equal(t, want, header.Param, "nLsb %d, bitrate %d", nLsb, bitrate)
+
+			extracted, err := m.Extract(stego, "stepkey")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("step"), extracted)
+		}
+	}
+}
+
+func TestSampleMethodAdaptive(t *testing.T) {
+	// Half a second of near silence, then a loud passage.
+	pcm := &audio.PCM{Samples: make([]int16, 40000), SampleRate: 8000, Channels: 1}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = int16((i*7)%16 - 8)
+		if i >= 4000 {
+			pcm.Samples[i] = int16((i*113)%6000 - 3000)
+		}
+	}
+	cover := audio.EncodeWAV(pcm)
+	payload := []byte("only where the music is loud enough to hide it")
+
+	for _, name := range []string{"lsb", "lsb-robust"} {
+		t.Run(name, func(t *testing.T) {
+			m, err := Lookup(name)
+			require.NoError(t, err)
+
+			stego, err := m.Embed(cover, payload, &Params{StegoKey: "adaptive", NLsb: 1, UseRandomSeed: true, Adaptive: true})
+			require.NoError(t, err)
+
+			decoded, err := audio.DecodeWAV(stego)
+			require.NoError(t, err)
+			// Only the header lands in the quiet passage.
+			header := m.(*sampleMethod).headerSamples(&Header{}, 1)
+			for i := header; i < 4000; i++ {
+				require.Equal(t, pcm.Samples[i], decoded.Samples[i], "sample %d", i)
+			}
+
+			extracted, err := m.Extract(stego, "adaptive")
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+		})
+	}
+
+	t.Run("several payloads", func(t *testing.T) {
+		m, err := Lookup("lsb")
+		require.NoError(t, err)
+		payloads := [][]byte{payload, []byte("second")}
+		stego, err := EmbedMany(m, cover, payloads, []string{"first", "second"}, &Params{NLsb: 2, Adaptive: true})
+		require.NoError(t, err)
+		for i, key := range []string{"first", "second"} {
+			extracted, err := m.Extract(stego, key)
+			require.NoError(t, err)
+			assert.Equal(t, payloads[i], extracted)
+		}
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		m, err := Lookup("mp3-compatible")
+		require.NoError(t, err)
+		_, err = m.Embed(cover, payload, &Params{StegoKey: "adaptive", NLsb: 1, Adaptive: true})
+		assert.ErrorContains(t, err, "does not support adaptive embedding")
+	})
+}
+
+func TestAmplitudeBits(t *testing.T) {
+	for _, tc := range []struct {
+		s    int16
+		nLsb int
+		want int
+	}{
+		{0, 4, 0},
+		{31, 4, 0},
+		{-32, 4, 0},
+		{32, 4, 1},
+		{-33, 4, 1},
+		{64, 4, 2},
+		{255, 4, 3},
+		{256, 4, 4},
+		{-32768, 4, 4},
+		{32767, 2, 2},
+	} {
+		assert.Equal(t, tc.want, amplitudeBits(tc.s, tc.nLsb), "s=%d nLsb=%d", tc.s, tc.nLsb)
+	}
+
+	// Changing the low four bits never changes the count.
+	for s := -2000; s < 2000; s++ {
+		assert.Equal(t, amplitudeBits(int16(s), 4), amplitudeBits(int16(s)^0x0F, 4), "s=%d", s)
+	}
+}
+
+func TestSampleMethodVariableLsb(t *testing.T) {
+	// A fade-in from silence to full scale.
+	pcm := &audio.PCM{Samples: make([]int16, 40000), SampleRate: 8000, Channels: 1}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = int16(((i*113)%2000 - 1000) * i / 1300)
+	}
+	cover := audio.EncodeWAV(pcm)
+
+	m, err := Lookup("lsb")
+	require.NoError(t, err)
+
+	fixed, err := m.Capacity(cover, &Params{StegoKey: "variable", NLsb: 1})
+	require.NoError(t, err)
+	variable, err := m.Capacity(cover, &Params{StegoKey: "variable", NLsb: 4, VariableLsb: true})
+	require.NoError(t, err)
+	assert.Greater(t, variable, fixed*2)
+
+	payload := make([]byte, variable*3/4)
+	for i := range payload {
+		payload[i] = byte(i * 31)
+	}
+	for _, params := range []*Params{
+		{StegoKey: "variable", NLsb: 4, VariableLsb: true, UseRandomSeed: true},
+		{StegoKey: "variable", NLsb: 3, VariableLsb: true, NoiseFill: true},
+	} {
+		stego, err := m.Embed(cover, payload[:variable*params.NLsb/8], params)
+		require.NoError(t, err)
+
+		decoded, err := audio.DecodeWAV(stego)
+		require.NoError(t, err)
+		header := m.(*sampleMethod).headerSamples(&Header{}, 1)
+		for i := header; i < len(pcm.Samples); i++ {
+			diff := int(decoded.Samples[i]) - int(pcm.Samples[i])
+			assert.Less(t, diff*diff, 1<<(2*amplitudeBits(pcm.Samples[i], params.NLsb)), "sample %d", i)
+		}
+
+		extracted, err := m.Extract(stego, "variable")
+		require.NoError(t, err)
+		assert.Equal(t, payload[:variable*params.NLsb/8], extracted)
+	}
+
+	_, err = m.Embed(cover, make([]byte, variable+1), &Params{StegoKey: "variable", NLsb: 4, VariableLsb: true})
+	assert.Error(t, err)
+
+	robust, err := Lookup("lsb-robust")
+	require.NoError(t, err)
+	_, err = robust.Capacity(cover, &Params{StegoKey: "variable", NLsb: 2, VariableLsb: true})
+	assert.ErrorContains(t, err, "does not support variable nLsb")
+}
+
+func TestDecodeCoverConcurrent(t *testing.T) {
+	long := wavCover(t)
+	pcm, err := audio.Decode(long)
+	require.NoError(t, err)
+	pcm.Samples = pcm.Samples[:len(pcm.Samples)/2]
+	short := audio.EncodeWAV(pcm)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(data []byte, want int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				decoded, err := decodeCover(data)
+				if assert.NoError(t, err) {
+					assert.Len(t, decoded.Samples, want)
+				}
+			}
+		}([][]byte{long, short}[i%2], []int{2 * len(pcm.Samples), len(pcm.Samples)}[i%2])
+	}
+	wg.Wait()
+}
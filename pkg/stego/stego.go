@@ -0,0 +1,572 @@
+package stego
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+
+	"audio-steganography-lsb/pkg/utils"
+)
+
+// Params carries the embedding options chosen by the user. Methods record
+// whatever they need from it in the parameter header so that extraction only
+// needs the stego key.
+type Params struct {
+	StegoKey      string
+	NLsb          int
+	UseRandomSeed bool
+	// OutputFormat is "wav" or "mp3" for methods that re-encode decoded
+	// samples; empty keeps the container of the cover.
+	OutputFormat string
+	// Bitrate is the MP3 bitrate in kbps used when re-encoding; zero keeps
+	// the bitrate of the cover.
+	Bitrate int
+	// Layout decides how sample-domain methods walk the channels of the
+	// cover.
+	Layout ChannelLayout
+	// CopyTags copies the ID3v2, APE and ID3v1 tags of an MP3 cover to a
+	// re-encoded MP3 output.
+	CopyTags bool
+	// Deniable encrypts every header and payload under its key and fills
+	// all unused positions with random bits, so that without a key nothing
+	// tells a payload from noise. Only the bitstream method supports it.
+	Deniable bool
+	// NoiseFill overwrites every embeddable position no payload uses with
+	// noise keyed by the stego key, so the end of the payload cannot be
+	// found from where the LSB statistics change.
+	NoiseFill bool
+	// Matching embeds by LSB matching (±1) instead of LSB replacement.
+	// Only the bitstream method supports it.
+	Matching bool
+	// MatrixEmbedding layers a Hamming code over the carriers, sized from
+	// the payload-to-capacity ratio, so fewer positions change per bit.
+	// Only the bitstream method supports it.
+	MatrixEmbedding bool
+	// Resync cuts the payload into blocks, one per MP3 frame, each opened
+	// by a keyed sync marker and its index, and repeats the parameter
+	// header every few blocks, so that a file with frames cut from it
+	// still gives up every intact block. Only the bitstream method
+	// supports it.
+	Resync bool
+	// Adaptive spreads each payload over a larger region and embeds only
+	// in its loudest blocks, where changes are least audible. Only the lsb
+	// and lsb-robust methods support it.
+	Adaptive bool
+	// VariableLsb lets every sample carry between 0 and NLsb bits
+	// depending on its amplitude, so loud samples carry more than quiet
+	// ones. Only the lsb method supports it.
+	VariableLsb bool
+}
+
+// ChannelLayout is the order in which sample-domain methods visit samples.
+type ChannelLayout int
+
+const (
+	// LayoutInterleaved uses the samples in file order, alternating
+	// between channels.
+	LayoutInterleaved ChannelLayout = iota
+	// LayoutPerChannel fills the first channel before moving on to the
+	// next, so a carrier spanning several samples stays in one channel.
+	LayoutPerChannel
+)
+
+func (l ChannelLayout) String() string {
+	switch l {
+	case LayoutInterleaved:
+		return "interleaved"
+	case LayoutPerChannel:
+		return "per-channel"
+	default:
+		return fmt.Sprintf("ChannelLayout(%d)", int(l))
+	}
+}
+
+// ParseChannelLayout accepts the names printed by ChannelLayout.String. An
+// empty name selects LayoutInterleaved.
+func ParseChannelLayout(name string) (ChannelLayout, error) {
+	switch name {
+	case "", "interleaved":
+		return LayoutInterleaved, nil
+	case "per-channel":
+		return LayoutPerChannel, nil
+	default:
+		return 0, fmt.Errorf("unknown channel layout: %q", name)
+	}
+}
+
+// Method is one embedding technique. Embed and Extract work on whole files so
+// that a method is free to work on the bitstream, on decoded samples or on
+// the tags. The payload is opaque to the method.
+type Method interface {
+	Name() string
+	ID() byte
+	// Capacity returns how many payload bytes the cover can carry.
+	Capacity(cover []byte, params *Params) (int, error)
+	Embed(cover, payload []byte, params *Params) ([]byte, error)
+	Extract(stego []byte, stegoKey string) ([]byte, error)
+	// Detect reports whether the file carries a header written by this
+	// method with the given key.
+	Detect(stego []byte, stegoKey string) bool
+}
+
+// MultiEmbedder is implemented by methods that can hide several payloads in
+// one cover, each under its own key. Every payload gets its own region of
+// the cover, placed at random, with its header and payload encrypted under
+// its key and random noise everywhere else, so extracting with one key
+// neither finds nor reveals the others. params.StegoKey is ignored in
+// favour of keys.
+type MultiEmbedder interface {
+	EmbedMany(cover []byte, payloads [][]byte, keys []string, params *Params) ([]byte, error)
+}
+
+// EmbedMany hides payloads[i] under keys[i]. A single payload goes through
+// Method.Embed; several need a MultiEmbedder.
+func EmbedMany(m Method, cover []byte, payloads [][]byte, keys []string, params *Params) ([]byte, error) {
+	if len(payloads) != len(keys) {
+		return nil, fmt.Errorf("%d payloads but %d keys", len(payloads), len(keys))
+	}
+	if len(payloads) == 1 {
+		single := *params
+		single.StegoKey = keys[0]
+		return m.Embed(cover, payloads[0], &single)
+	}
+
+	multi, ok := m.(MultiEmbedder)
+	if !ok {
+		return nil, fmt.Errorf("%s method cannot hide several payloads in one cover", m.Name())
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			return nil, fmt.Errorf("every payload needs a different key")
+		}
+		seen[key] = true
+	}
+	return multi.EmbedMany(cover, payloads, keys, params)
+}
+
+var (
+	methodsByName = make(map[string]Method)
+	methodsByID   = make(map[byte]Method)
+)
+
+// Register makes a method available to embed and extract. It panics on a
+// duplicate name or ID, since that is a programming error.
+func Register(m Method) {
+	if _, ok := methodsByName[m.Name()]; ok {
+		panic(fmt.Sprintf("stego: method %q registered twice", m.Name()))
+	}
+	if other, ok := methodsByID[m.ID()]; ok {
+		panic(fmt.Sprintf("stego: method ID %d used by both %q and %q", m.ID(), other.Name(), m.Name()))
+	}
+	methodsByName[m.Name()] = m
+	methodsByID[m.ID()] = m
+}
+
+func Lookup(name string) (Method, error) {
+	m, ok := methodsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding method: %q", name)
+	}
+	return m, nil
+}
+
+func LookupID(id byte) (Method, error) {
+	m, ok := methodsByID[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding method ID: %d", id)
+	}
+	return m, nil
+}
+
+// Methods returns every registered method ordered by ID.
+func Methods() []Method {
+	methods := make([]Method, 0, len(methodsByID))
+	for _, m := range methodsByID {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].ID() < methods[j].ID() })
+	return methods
+}
+
+// Capacities reports the payload capacity in bytes of every registered
+// method for a cover. Methods that cannot use the cover are left out.
+func Capacities(cover []byte, params *Params) map[string]int {
+	capacities := make(map[string]int)
+	for _, m := range Methods() {
+		if capacity, err := m.Capacity(cover, params); err == nil {
+			capacities[m.Name()] = capacity
+		}
+	}
+	return capacities
+}
+
+// Detect finds the method whose header is present in the stego file.
+func Detect(stego []byte, stegoKey string) (Method, error) {
+	for _, m := range Methods() {
+		if m.Detect(stego, stegoKey) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no embedded data found for this key")
+}
+
+// findRegion looks for a header readable with the stego key at the start of
+// every block of utils.Regions and returns it with the carriers [start, end)
+// of the blocks its payload occupies. read returns the header stored from
+// carrier start on; fits reports whether carriers [start, end) hold the
+// header and its payload. When no block holds a header, the error for block
+// 0 is returned, since that is where a lone payload lives.
+func findRegion(total int, read func(start int) (*Header, error), fits func(h *Header, start, end int) bool) (*Header, int, int, error) {
+	var firstErr error
+	for first := 0; first < utils.Regions; first++ {
+		start, _ := utils.Region(total, first, 1)
+		h, err := read(start)
+		if err != nil {
+			if first == 0 {
+				firstErr = err
+			}
+			continue
+		}
+
+		count := utils.RegionBlocks(total, first, func(start, end int) bool { return fits(h, start, end) })
+		if count == 0 {
+			return nil, 0, 0, fmt.Errorf("payload length %d exceeds capacity", h.PayloadLength)
+		}
+		start, end := utils.Region(total, first, count)
+		return h, start, end, nil
+	}
+	return nil, 0, 0, firstErr
+}
+
+// hidesRegions reports whether the regions of an embedding must not give
+// each other away: in deniable mode and whenever several payloads share
+// the cover. Their headers and payloads are then encrypted under their own
+// keys and a fresh nonce, the regions are placed at random and everything
+// around them is filled with random noise.
+func hidesRegions(params *Params, keys []string) bool {
+	return params.Deniable || len(keys) > 1
+}
+
+// placeRegions decides where the regions of n payloads go. plan lays the
+// payloads out in consecutive runs of blocks, in the given order, from
+// block first on, and returns how many blocks they take; it must not write
+// anything, since it may be called more than once and the last call made
+// is the layout to use. With hide set the payloads come in a random order
+// and start at a random block within the slack the packed layout leaves,
+// so neither the place of a region nor the blocks in front of it tell its
+// reader whether other payloads exist.
+func placeRegions(n int, hide bool, plan func(order []int, first int) (int, error)) error {
+	if !hide {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		_, err := plan(order, 0)
+		return err
+	}
+
+	order, err := shuffled(n)
+	if err != nil {
+		return err
+	}
+	used, err := plan(order, 0)
+	if err != nil {
+		return err
+	}
+	first, err := randIntn(utils.Regions - used + 1)
+	if err != nil || first == 0 {
+		return err
+	}
+	if _, err := plan(order, first); err != nil {
+		// Block boundaries round differently further on; the packed
+		// layout is known to fit.
+		_, err = plan(order, 0)
+		return err
+	}
+	return nil
+}
+
+// shuffled returns 0..n-1 in a random order.
+func shuffled(n int) ([]int, error) {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j, err := randIntn(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
+
+// randIntn returns a uniform random number in [0, n) from crypto/rand.
+func randIntn(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return int(v.Int64()), nil
+}
+
+const (
+	headerMagic0 = 0xAB
+	headerMagic1 = 0xCD
+
+	flagRandomSeed = 1 << 0
+	flagPerChannel = 1 << 1
+	flagMatching   = 1 << 2
+	flagVariable   = 1 << 3
+
+	// The high nibble of the flags byte holds the channel count.
+	channelsShift = 4
+	maxChannels   = 15
+
+	// The high nibble of the nLsb byte holds the adaptive spread factor.
+	adaptiveShift = 4
+	maxAdaptive   = 15
+)
+
+// HeaderSize is the size in bytes of the parameter header every method
+// writes in front of its payload.
+const HeaderSize = 14
+
+// HeaderBits is HeaderSize in bits, i.e. the number of 1-LSB carrier
+// positions the header occupies.
+const HeaderBits = HeaderSize * 8
+
+// deniableNonceSize is the random nonce in front of a deniable header. It is
+// the IV of the keystream that encrypts the header and its payload, so no
+// two embeddings under one key share a keystream.
+const deniableNonceSize = aes.BlockSize
+
+// headerBits returns the number of carriers a header takes: HeaderBits,
+// plus the nonce in front of a deniable one.
+func headerBits(deniable bool) int {
+	if deniable {
+		return HeaderBits + deniableNonceSize*8
+	}
+	return HeaderBits
+}
+
+// Header is the parameter header: magic, method ID, embedding options, a key
+// check and the payload length. Methods embed it with fixed settings so it
+// can be read before nLsb and Param are known.
+type Header struct {
+	MethodID      byte
+	NLsb          int
+	UseRandomSeed bool
+	PayloadLength int
+	// Param is a method-specific setting, such as a quantization step or
+	// the matrix embedding code parameter k.
+	Param int
+	// Layout and Channels describe how sample-domain methods laid out the
+	// payload. Channels is zero for methods that do not work on samples.
+	Layout   ChannelLayout
+	Channels int
+	// Deniable headers are encrypted with the key's keystream, as is the
+	// payload after them, so they look like random bits.
+	Deniable bool
+	// Nonce is the IV of a deniable header's keystream, stored in front of
+	// it. Marshal picks a random one when it is empty.
+	Nonce []byte
+	// Matching records that the payload was written by LSB matching. It
+	// does not change extraction.
+	Matching bool
+	// Adaptive is the spread factor of adaptive embedding: the region
+	// holds Adaptive times the carriers the payload needs and only its
+	// loudest blocks carry it. Zero means every carrier is used.
+	Adaptive int
+	// VariableLsb records that NLsb is only the most any sample carries.
+	VariableLsb bool
+}
+
+// Marshal encodes the header. It fails rather than truncate a field that
+// does not fit, so a header never decodes to other parameters than were
+// embedded. A deniable header comes out as its nonce followed by the
+// encrypted header, headerBits(true) bits in all.
+func (h *Header) Marshal(stegoKey string) ([]byte, error) {
+	switch {
+	case h.NLsb < 1 || h.NLsb > 4:
+		return nil, fmt.Errorf("invalid nLsb value: %d", h.NLsb)
+	case h.Adaptive < 0 || h.Adaptive > maxAdaptive:
+		return nil, fmt.Errorf("adaptive spread factor %d out of range 0-%d", h.Adaptive, maxAdaptive)
+	case h.Channels < 0 || h.Channels > maxChannels:
+		return nil, fmt.Errorf("unsupported channel count: %d", h.Channels)
+	case h.Param < 0 || h.Param > math.MaxUint16:
+		return nil, fmt.Errorf("method parameter %d out of range 0-%d", h.Param, math.MaxUint16)
+	case h.PayloadLength < 0 || int64(h.PayloadLength) > math.MaxUint32:
+		return nil, fmt.Errorf("invalid payload length: %d", h.PayloadLength)
+	}
+
+	b := make([]byte, HeaderSize)
+	b[0] = headerMagic0
+	b[1] = headerMagic1
+	b[2] = h.MethodID
+	b[3] = byte(h.NLsb) | byte(h.Adaptive)<<adaptiveShift
+	b[4] = byte(h.Channels) << channelsShift
+	if h.UseRandomSeed {
+		b[4] |= flagRandomSeed
+	}
+	if h.Layout == LayoutPerChannel {
+		b[4] |= flagPerChannel
+	}
+	if h.Matching {
+		b[4] |= flagMatching
+	}
+	if h.VariableLsb {
+		b[4] |= flagVariable
+	}
+	copy(b[5:8], keyCheck(stegoKey))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(h.PayloadLength))
+	binary.LittleEndian.PutUint16(b[12:14], uint16(h.Param))
+	if !h.Deniable {
+		return b, nil
+	}
+	if h.Nonce == nil {
+		h.Nonce = make([]byte, deniableNonceSize)
+		if _, err := rand.Read(h.Nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+	}
+	return append(append([]byte(nil), h.Nonce...), whiten(b, stegoKey, h.Nonce, 0)...), nil
+}
+
+// ParseHeader reads a plain header from the start of b, or a deniable one
+// when b holds headerBits(true) bits and no plain header.
+func ParseHeader(b []byte, stegoKey string) (*Header, error) {
+	if len(b) < HeaderSize {
+		return nil, fmt.Errorf("invalid header length")
+	}
+	h, err := parseHeader(b[:HeaderSize], stegoKey)
+	if err == nil || len(b) < HeaderSize+deniableNonceSize {
+		return h, err
+	}
+	nonce := b[:deniableNonceSize]
+	if h, deniableErr := parseHeader(whiten(b[deniableNonceSize:deniableNonceSize+HeaderSize], stegoKey, nonce, 0), stegoKey); deniableErr == nil {
+		h.Deniable = true
+		h.Nonce = append([]byte(nil), nonce...)
+		return h, nil
+	}
+	return nil, err
+}
+
+func parseHeader(b []byte, stegoKey string) (*Header, error) {
+	if b[0] != headerMagic0 || b[1] != headerMagic1 {
+		return nil, fmt.Errorf("invalid magic bytes")
+	}
+
+	check := keyCheck(stegoKey)
+	if b[5] != check[0] || b[6] != check[1] || b[7] != check[2] {
+		return nil, fmt.Errorf("key checksum mismatch")
+	}
+
+	h := &Header{
+		MethodID:      b[2],
+		NLsb:          int(b[3] & (1<<adaptiveShift - 1)),
+		UseRandomSeed: b[4]&flagRandomSeed != 0,
+		PayloadLength: int(binary.LittleEndian.Uint32(b[8:12])),
+		Param:         int(binary.LittleEndian.Uint16(b[12:14])),
+		Channels:      int(b[4] >> channelsShift),
+		Matching:      b[4]&flagMatching != 0,
+		Adaptive:      int(b[3] >> adaptiveShift),
+		VariableLsb:   b[4]&flagVariable != 0,
+	}
+	if b[4]&flagPerChannel != 0 {
+		h.Layout = LayoutPerChannel
+	}
+	if h.NLsb < 1 || h.NLsb > 4 {
+		return nil, fmt.Errorf("invalid nLsb value: %d", h.NLsb)
+	}
+
+	return h, nil
+}
+
+// whiten XORs data with the keystream of stegoKey and nonce from byte
+// offset on. It is its own inverse. Deniable headers use offset 0 and their
+// payloads continue at HeaderSize.
+func whiten(data []byte, stegoKey string, nonce []byte, offset int) []byte {
+	stream := nonceKeystream("deniable:", stegoKey, nonce, offset+len(data))
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ stream[offset+i]
+	}
+	return out
+}
+
+// keystream returns n bytes of AES-CTR keystream under a key derived from
+// label and stegoKey.
+func keystream(label, stegoKey string, n int) []byte {
+	return nonceKeystream(label, stegoKey, make([]byte, aes.BlockSize), n)
+}
+
+// nonceKeystream is keystream with nonce as the CTR IV.
+func nonceKeystream(label, stegoKey string, nonce []byte, n int) []byte {
+	key := sha256.Sum256([]byte(label + stegoKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		// A 32-byte key is always valid.
+		panic(err)
+	}
+	stream := make([]byte, n)
+	cipher.NewCTR(block, nonce).XORKeyStream(stream, stream)
+	return stream
+}
+
+// fillNoise returns the bytes used to fill unused carriers: keyed noise for
+// Params.NoiseFill, or true randomness in deniable mode and whenever
+// several payloads share the cover, where noise one key could regenerate
+// would let its holder find the other regions. It returns nil when no fill
+// is needed.
+func fillNoise(params *Params, keys []string, n int) ([]byte, error) {
+	switch {
+	case hidesRegions(params, keys):
+		noise := make([]byte, n)
+		if _, err := rand.Read(noise); err != nil {
+			return nil, fmt.Errorf("failed to generate noise: %w", err)
+		}
+		return noise, nil
+	case params.NoiseFill:
+		return keystream("fill:", keys[0], n), nil
+	}
+	return nil, nil
+}
+
+func keyCheck(stegoKey string) []byte {
+	sum := sha256.Sum256([]byte("header:" + stegoKey))
+	return sum[:3]
+}
+
+func BytesToBits(data []byte) []bool {
+	bits := make([]bool, len(data)*8)
+	for i, b := range data {
+		for j := 0; j < 8; j++ {
+			bits[i*8+j] = (b>>j)&1 == 1
+		}
+	}
+	return bits
+}
+
+// BitsToBytes packs bits LSB-first, dropping a trailing partial byte.
+func BitsToBytes(bits []bool) []byte {
+	data := make([]byte, len(bits)/8)
+	for i := range data {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				b |= 1 << j
+			}
+		}
+		data[i] = b
+	}
+	return data
+}
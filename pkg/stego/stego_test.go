@@ -0,0 +1,588 @@
+package stego
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"audio-steganography-lsb/pkg/mp3frame"
+	"audio-steganography-lsb/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const coverPath = "../../test/cover-1.mp3"
+
+func readCover(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(coverPath)
+	require.NoError(t, err)
+	return data
+}
+
+func TestRegistry(t *testing.T) {
+	m, err := Lookup("bitstream")
+	require.NoError(t, err)
+	assert.Equal(t, BitstreamID, m.ID())
+
+	m, err = LookupID(BitstreamID)
+	require.NoError(t, err)
+	assert.Equal(t, "bitstream", m.Name())
+
+	_, err = Lookup("nonexistent")
+	assert.Error(t, err)
+
+	_, err = LookupID(0xFF)
+	assert.Error(t, err)
+
+	methods := Methods()
+	require.NotEmpty(t, methods)
+	for i := 1; i < len(methods); i++ {
+		assert.Less(t, methods[i-1].ID(), methods[i].ID())
+	}
+
+	assert.Panics(t, func() { Register(Bitstream{}) })
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	header := &Header{
+		MethodID:      BitstreamID,
+		NLsb:          3,
+		UseRandomSeed: true,
+		PayloadLength: 123456,
+		Param:         320,
+		Layout:        LayoutPerChannel,
+		Channels:      2,
+		Adaptive:      7,
+		VariableLsb:   true,
+	}
+
+	data, err := header.Marshal("testkey")
+	require.NoError(t, err)
+	require.Len(t, data, HeaderSize)
+
+	parsed, err := ParseHeader(data, "testkey")
+	require.NoError(t, err)
+	assert.Equal(t, header, parsed)
+
+	_, err = ParseHeader(data, "otherkey")
+	assert.Error(t, err)
+
+	_, err = ParseHeader(data[:4], "testkey")
+	assert.Error(t, err)
+
+	data[0] = 0
+	_, err = ParseHeader(data, "testkey")
+	assert.Error(t, err)
+
+	// Fields that do not fit are rejected rather than truncated.
+	for _, h := range []Header{
+		{NLsb: 5},
+		{NLsb: 1, Adaptive: maxAdaptive + 1},
+		{NLsb: 1, Channels: maxChannels + 1},
+		{NLsb: 1, Param: 1 << 16},
+		{NLsb: 1, PayloadLength: -1},
+	} {
+		_, err := h.Marshal("testkey")
+		assert.Error(t, err, "%+v", h)
+	}
+}
+
+func TestParseChannelLayout(t *testing.T) {
+	for _, layout := range []ChannelLayout{LayoutInterleaved, LayoutPerChannel} {
+		parsed, err := ParseChannelLayout(layout.String())
+		require.NoError(t, err)
+		assert.Equal(t, layout, parsed)
+	}
+
+	layout, err := ParseChannelLayout("")
+	require.NoError(t, err)
+	assert.Equal(t, LayoutInterleaved, layout)
+
+	_, err = ParseChannelLayout("diagonal")
+	assert.Error(t, err)
+}
+
+func TestBitsConversion(t *testing.T) {
+	data := []byte{0x00, 0x01, 0x80, 0xA5, 0xFF}
+	bits := BytesToBits(data)
+	require.Len(t, bits, len(data)*8)
+	assert.True(t, bits[8])
+	assert.True(t, bits[23])
+	assert.Equal(t, data, BitsToBytes(bits))
+	assert.Equal(t, data[:2], BitsToBytes(bits[:20]))
+}
+
+func TestBitstreamRoundTrip(t *testing.T) {
+	cover := readCover(t)
+	payload := []byte("payload carried by the bitstream method")
+
+	for _, tc := range []struct {
+		name   string
+		params *Params
+	}{
+		{"sequential 1 LSB", &Params{StegoKey: "key1", NLsb: 1}},
+		{"sequential 4 LSB", &Params{StegoKey: "key4", NLsb: 4}},
+		{"random 2 LSB", &Params{StegoKey: "randomkey", NLsb: 2, UseRandomSeed: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Bitstream{}
+			stego, err := m.Embed(cover, payload, tc.params)
+			require.NoError(t, err)
+			assert.Len(t, stego, len(cover))
+			assert.NotEqual(t, cover, stego)
+
+			assert.True(t, m.Detect(stego, tc.params.StegoKey))
+			assert.False(t, m.Detect(stego, "wrongkey"))
+
+			detected, err := Detect(stego, tc.params.StegoKey)
+			require.NoError(t, err)
+			assert.Equal(t, BitstreamID, detected.ID())
+
+			extracted, err := m.Extract(stego, tc.params.StegoKey)
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+		})
+	}
+}
+
+// withTags replaces the ID3v2 tag of an MP3 file with a large one full of
+// sync-like bytes, as album art would be, and appends APE and ID3v1 tags.
+func withTags(t *testing.T, mp3 []byte) []byte {
+	t.Helper()
+	stream, err := mp3frame.Parse(mp3)
+	require.NoError(t, err)
+
+	body := make([]byte, 9000)
+	for i := range body {
+		body[i] = byte(i * 31)
+	}
+	id3 := []byte{'I', 'D', '3', 4, 0, 0,
+		byte(len(body) >> 21 & 0x7F), byte(len(body) >> 14 & 0x7F), byte(len(body) >> 7 & 0x7F), byte(len(body) & 0x7F)}
+	id3 = append(id3, body...)
+
+	ape := append([]byte("0123456789abcdef"), "APETAGEX"...)
+	ape = binary.LittleEndian.AppendUint32(ape, 2000)
+	ape = binary.LittleEndian.AppendUint32(ape, 16+32)
+	ape = binary.LittleEndian.AppendUint32(ape, 1)
+	ape = append(ape, make([]byte, 12)...)
+
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+
+	var out []byte
+	out = append(out, id3...)
+	out = append(out, mp3[stream.ID3v2Size:stream.AudioEnd]...)
+	out = append(out, ape...)
+	return append(out, id3v1...)
+}
+
+func TestBitstreamPreservesTags(t *testing.T) {
+	cover := withTags(t, readCover(t))
+	stream, err := mp3frame.Parse(cover)
+	require.NoError(t, err)
+	require.NotNil(t, stream.Tag)
+	require.Equal(t, 9010, stream.ID3v2Size)
+	require.Equal(t, 48, stream.APESize)
+	require.Equal(t, 128, stream.ID3v1Size)
+
+	// Everything up to the end of the Info frame and everything after the
+	// last audio frame must come through untouched.
+	head := stream.Frames[0].Offset + stream.Frames[0].Length
+	tail := stream.AudioEnd
+
+	for _, params := range []*Params{
+		{StegoKey: "tags", NLsb: 1},
+		{StegoKey: "tags", NLsb: 4, UseRandomSeed: true},
+	} {
+		capacity, err := Bitstream{}.Capacity(cover, params)
+		require.NoError(t, err)
+		payload := make([]byte, capacity)
+		for i := range payload {
+			payload[i] = byte(i*7 + 1)
+		}
+
+		stego, err := Bitstream{}.Embed(cover, payload, params)
+		require.NoError(t, err)
+		assert.Equal(t, cover[:head], stego[:head])
+		assert.Equal(t, cover[tail:], stego[tail:])
+
+		extracted, err := Bitstream{}.Extract(stego, params.StegoKey)
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+	}
+}
+
+func TestBitstreamRejectsNonMP3(t *testing.T) {
+	_, err := Bitstream{}.Capacity(make([]byte, 4096), &Params{StegoKey: "key", NLsb: 1})
+	assert.Error(t, err)
+}
+
+func TestBitstreamCapacity(t *testing.T) {
+	cover := readCover(t)
+	params := &Params{StegoKey: "key", NLsb: 1}
+
+	capacity, err := Bitstream{}.Capacity(cover, params)
+	require.NoError(t, err)
+	assert.Greater(t, capacity, 0)
+
+	_, err = Bitstream{}.Embed(cover, make([]byte, capacity+1024), params)
+	assert.Error(t, err)
+
+	_, err = Bitstream{}.Capacity([]byte("tiny"), params)
+	assert.Error(t, err)
+}
+
+func TestDetectCleanCover(t *testing.T) {
+	_, err := Detect(readCover(t), "anykey")
+	assert.Error(t, err)
+}
+
+func TestCapacities(t *testing.T) {
+	cover := readCover(t)
+	capacities := Capacities(cover, &Params{StegoKey: "key", NLsb: 1})
+	for _, m := range Methods() {
+		if m.ID() == APICID {
+			// The cover has no album art.
+			assert.NotContains(t, capacities, m.Name())
+			continue
+		}
+		assert.Greater(t, capacities[m.Name()], 0, m.Name())
+	}
+
+	assert.Empty(t, Capacities([]byte("tiny"), &Params{StegoKey: "key", NLsb: 1}))
+}
+
+func TestEmbedMany(t *testing.T) {
+	keys := []string{"alice", "bob", "carol"}
+	payloads := [][]byte{
+		[]byte("for alice only"),
+		make([]byte, 3000),
+		[]byte("carol's share of the secret"),
+	}
+	for i := range payloads[1] {
+		payloads[1][i] = byte(i * 17)
+	}
+
+	for _, tc := range []struct {
+		method string
+		cover  []byte
+	}{
+		{"bitstream", readCover(t)},
+		{"lsb", wavCover(t)},
+		{"id3", readCover(t)},
+		{"apic", withPicture(t, "../../test/test_png.png", "image/png")},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			m, err := Lookup(tc.method)
+			require.NoError(t, err)
+
+			params := &Params{NLsb: 1, UseRandomSeed: true}
+			stego, err := EmbedMany(m, tc.cover, payloads, keys, params)
+			require.NoError(t, err)
+
+			// Every key finds its own payload and nothing else.
+			for i, key := range keys {
+				assert.True(t, m.Detect(stego, key))
+				extracted, err := m.Extract(stego, key)
+				require.NoError(t, err)
+				assert.Equal(t, payloads[i], extracted)
+			}
+			assert.False(t, m.Detect(stego, "mallory"))
+		})
+	}
+
+	_, err := EmbedMany(Bitstream{}, readCover(t), payloads[:2], []string{"alice", "alice"}, &Params{NLsb: 1})
+	assert.ErrorContains(t, err, "different key")
+}
+
+func TestEmbedManyHidesRegions(t *testing.T) {
+	cover := readCover(t)
+	payloads := [][]byte{[]byte("for alice only"), []byte("bob's share of the secret")}
+	stego, err := EmbedMany(Bitstream{}, cover, payloads, []string{"alice", "bob"}, &Params{NLsb: 1})
+	require.NoError(t, err)
+
+	// No block start shows a plaintext header, and alice's key reads no
+	// header but her own.
+	positions := findEmbeddablePositions(stego)
+	magic := []byte{headerMagic0, headerMagic1}
+	found := 0
+	for first := 0; first < utils.Regions; first++ {
+		start, _ := utils.Region(len(positions), first, 1)
+		bits := make([]bool, 16)
+		for i := range bits {
+			bits[i] = stego[positions[start+i]]&1 == 1
+		}
+		assert.NotEqual(t, magic, BitsToBytes(bits), "block %d", first)
+
+		if h, err := readBitstreamHeader(stego, positions[start:], "alice"); err == nil {
+			assert.Equal(t, len(payloads[0]), h.PayloadLength)
+			found++
+		}
+	}
+	assert.Equal(t, 1, found)
+
+	// The rest of the cover is random noise, not alice's keystream.
+	ones := 0
+	for _, pos := range positions {
+		ones += int(stego[pos] & 1)
+	}
+	assert.InDelta(t, 0.5, float64(ones)/float64(len(positions)), 0.01)
+}
+
+func TestEmbedManyFreshHeaders(t *testing.T) {
+	cover := readCover(t)
+	keys := []string{"alice", "bob"}
+	payloads := [][]byte{[]byte("for alice only"), []byte("bob's share of the secret")}
+
+	// Embedding the same payloads under the same keys twice gives every
+	// key a different header, so two stego files cannot be linked by it.
+	headers := make(map[string][][]byte)
+	for i := 0; i < 2; i++ {
+		stego, err := EmbedMany(Bitstream{}, cover, payloads, keys, &Params{NLsb: 1})
+		require.NoError(t, err)
+		for j, key := range keys {
+			header, positions, err := findBitstreamRegion(stego, findEmbeddablePositions(stego), key)
+			require.NoError(t, err)
+			require.True(t, header.Deniable)
+			headers[key] = append(headers[key], BitsToBytes(readLSBs(stego, positions[:headerBits(true)])))
+
+			extracted, err := Bitstream{}.Extract(stego, key)
+			require.NoError(t, err)
+			assert.Equal(t, payloads[j], extracted)
+		}
+	}
+	for _, key := range keys {
+		assert.NotEqual(t, headers[key][0], headers[key][1], key)
+		assert.NotEqual(t, headers[key][0][deniableNonceSize:], headers[key][1][deniableNonceSize:], key)
+	}
+}
+
+func TestEmbedManyTooLarge(t *testing.T) {
+	cover := readCover(t)
+	params := &Params{NLsb: 1}
+	capacity, err := Bitstream{}.Capacity(cover, params)
+	require.NoError(t, err)
+
+	// A payload that fills the cover on its own leaves no room for a second.
+	_, err = EmbedMany(Bitstream{}, cover, [][]byte{make([]byte, capacity), {1}}, []string{"alice", "bob"}, params)
+	assert.ErrorContains(t, err, "data too large")
+
+	_, err = Bitstream{}.Embed(cover, make([]byte, capacity), &Params{StegoKey: "alice", NLsb: 1})
+	assert.NoError(t, err)
+}
+
+func TestDeniableBitstream(t *testing.T) {
+	cover := readCover(t)
+	decoy := []byte("shopping list: eggs, milk, bread")
+	hidden := make([]byte, 2000)
+	for i := range hidden {
+		hidden[i] = byte(i * 31)
+	}
+
+	params := &Params{NLsb: 1, Deniable: true}
+	stego, err := EmbedMany(Bitstream{}, cover, [][]byte{decoy, hidden}, []string{"decoykey", "realkey"}, params)
+	require.NoError(t, err)
+
+	extracted, err := Bitstream{}.Extract(stego, "decoykey")
+	require.NoError(t, err)
+	assert.Equal(t, decoy, extracted)
+	extracted, err = Bitstream{}.Extract(stego, "realkey")
+	require.NoError(t, err)
+	assert.Equal(t, hidden, extracted)
+	assert.False(t, Bitstream{}.Detect(stego, "otherkey"))
+
+	// Headers carry no plaintext magic and every embeddable LSB, used or
+	// not, looks like a fair coin.
+	positions := findEmbeddablePositions(stego)
+	magic := BytesToBits([]byte{headerMagic0, headerMagic1})
+	plain := true
+	ones := 0
+	for i, pos := range positions {
+		if i < len(magic) && (stego[pos]&1 == 1) != magic[i] {
+			plain = false
+		}
+		ones += int(stego[pos] & 1)
+	}
+	assert.False(t, plain)
+	assert.InDelta(t, 0.5, float64(ones)/float64(len(positions)), 0.01)
+}
+
+func TestDeniableHeaderRoundTrip(t *testing.T) {
+	h := &Header{MethodID: BitstreamID, NLsb: 2, PayloadLength: 1234, Deniable: true}
+	b, err := h.Marshal("key")
+	require.NoError(t, err)
+	assert.NotEqual(t, []byte{headerMagic0, headerMagic1}, b[:2])
+
+	parsed, err := ParseHeader(b, "key")
+	require.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	_, err = ParseHeader(b, "otherkey")
+	assert.Error(t, err)
+	_, err = ParseHeader(b[deniableNonceSize:], "key")
+	assert.Error(t, err)
+}
+
+func TestDeniableNonce(t *testing.T) {
+	cover := readCover(t)
+	payload := []byte("the same payload under the same key")
+	params := &Params{StegoKey: "key", NLsb: 1, Deniable: true}
+
+	// Every embedding picks its own nonce, so neither the header nor the
+	// keystream over the payload repeats.
+	var headers [][]byte
+	var nonces [][]byte
+	for i := 0; i < 2; i++ {
+		stego, err := Bitstream{}.Embed(cover, payload, params)
+		require.NoError(t, err)
+		header, positions, err := findBitstreamRegion(stego, findEmbeddablePositions(stego), "key")
+		require.NoError(t, err)
+		require.True(t, header.Deniable)
+		headers = append(headers, BitsToBytes(readLSBs(stego, positions[:headerBits(true)])))
+		nonces = append(nonces, header.Nonce)
+
+		extracted, err := Bitstream{}.Extract(stego, "key")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+	}
+	assert.NotEqual(t, headers[0], headers[1])
+	assert.NotEqual(t, headers[0][deniableNonceSize:], headers[1][deniableNonceSize:])
+	assert.NotEqual(t, whiten(payload, "key", nonces[0], HeaderSize), whiten(payload, "key", nonces[1], HeaderSize))
+}
+
+func TestNoiseFill(t *testing.T) {
+	payload := []byte("short payload")
+
+	for _, tc := range []struct {
+		method string
+		cover  []byte
+	}{
+		{"bitstream", readCover(t)},
+		{"lsb", wavCover(t)},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			m, err := Lookup(tc.method)
+			require.NoError(t, err)
+			// A stretch of the file well past the end of the payload.
+			tail := func(data []byte) []byte { return data[len(data)*3/4 : len(data)*7/8] }
+
+			plain, err := m.Embed(tc.cover, payload, &Params{StegoKey: "fillkey", NLsb: 1})
+			require.NoError(t, err)
+			assert.Equal(t, tail(tc.cover), tail(plain))
+
+			params := &Params{StegoKey: "fillkey", NLsb: 1, NoiseFill: true}
+			filled, err := m.Embed(tc.cover, payload, params)
+			require.NoError(t, err)
+			assert.NotEqual(t, tail(tc.cover), tail(filled))
+
+			extracted, err := m.Extract(filled, "fillkey")
+			require.NoError(t, err)
+			assert.Equal(t, payload, extracted)
+
+			// The noise is keyed, so embedding again gives the same file.
+			again, err := m.Embed(tc.cover, payload, params)
+			require.NoError(t, err)
+			assert.Equal(t, filled, again)
+		})
+	}
+}
+
+func TestMatchLSBs(t *testing.T) {
+	for _, tc := range []struct {
+		b    byte
+		bits []bool
+		up   bool
+		want byte
+	}{
+		{0x42, []bool{false}, true, 0x42},
+		{0x42, []bool{true}, true, 0x43},
+		{0x42, []bool{true}, false, 0x41},
+		// At the edges of the low nibble the only neighbour is used, so
+		// 0x00 and 0xFF never wrap and the high nibble never changes.
+		{0x00, []bool{true}, false, 0x01},
+		{0xFF, []bool{false}, true, 0xFE},
+		{0xEF, []bool{false}, true, 0xEE},
+		{0xF0, []bool{true}, false, 0xF1},
+		// Several bits move to the nearest byte with the wanted low bits.
+		{0x47, []bool{false, false}, true, 0x48},
+		{0x45, []bool{false, false}, true, 0x44},
+		{0x4E, []bool{true, true, true, false}, false, 0x47},
+	} {
+		assert.Equal(t, tc.want, matchLSBs(tc.b, tc.bits, tc.up), "%#x %v", tc.b, tc.bits)
+	}
+}
+
+func TestBitstreamMatching(t *testing.T) {
+	cover := readCover(t)
+	payload := make([]byte, 4000)
+	for i := range payload {
+		payload[i] = byte(i*37 + 11)
+	}
+
+	for _, nLsb := range []int{1, 3} {
+		params := &Params{StegoKey: "matchkey", NLsb: nLsb, Matching: true}
+		stego, err := Bitstream{}.Embed(cover, payload, params)
+		require.NoError(t, err)
+
+		header, _, err := findBitstreamRegion(stego, findEmbeddablePositions(stego), "matchkey")
+		require.NoError(t, err)
+		assert.True(t, header.Matching)
+
+		extracted, err := Bitstream{}.Extract(stego, "matchkey")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+
+		if nLsb == 1 {
+			// Every change is ±1, in both directions.
+			up, down := 0, 0
+			for i := range cover {
+				switch int(stego[i]) - int(cover[i]) {
+				case 0:
+				case 1:
+					up++
+				case -1:
+					down++
+				default:
+					t.Fatalf("byte %d changed from %#x to %#x", i, cover[i], stego[i])
+				}
+			}
+			assert.Greater(t, up, 1000)
+			assert.Greater(t, down, 1000)
+		}
+	}
+}
+
+func TestBitstreamMatchingNoiseFill(t *testing.T) {
+	cover := readCover(t)
+	payload := []byte("matched over a matched fill")
+	for _, keys := range [][]string{{"matchkey"}, {"matchkey", "otherkey"}} {
+		payloads := make([][]byte, len(keys))
+		for i := range payloads {
+			payloads[i] = payload
+		}
+		params := &Params{NLsb: 1, Matching: true, NoiseFill: true}
+		stego, err := EmbedMany(Bitstream{}, cover, payloads, keys, params)
+		require.NoError(t, err)
+
+		extracted, err := Bitstream{}.Extract(stego, "matchkey")
+		require.NoError(t, err)
+		assert.Equal(t, payload, extracted)
+
+		// Every change is ±1, and the fill moves even bytes down and odd
+		// bytes up too, which replacement never does.
+		against := 0
+		for i := range cover {
+			switch int(stego[i]) - int(cover[i]) {
+			case 0:
+			case 1, -1:
+				if (stego[i] > cover[i]) == (cover[i]&1 == 1) {
+					against++
+				}
+			default:
+				t.Fatalf("byte %d changed from %#x to %#x", i, cover[i], stego[i])
+			}
+		}
+		assert.Greater(t, against, len(findEmbeddablePositions(cover))/5)
+	}
+}
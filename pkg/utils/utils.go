@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 func ValidateStegoKey(key string) error {
@@ -31,11 +34,37 @@ func GeneratePositions(key string, useRandomSeed bool, totalSamples, nLsb int) (
 	return generateSequentialPositions(totalSamples, nLsb), nil
 }
 
+// Regions is the number of equal blocks a cover's carriers are divided into
+// so that several payloads, each under its own key, can share the cover.
+// Every payload occupies a run of whole blocks; its header sits at the
+// start of the first one and GeneratePositions only hands out carriers
+// inside the run.
+const Regions = 64
+
+// Region returns the carriers [start, end) covered by blocks
+// [first, first+count) when total carriers are split into Regions blocks.
+func Region(total, first, count int) (start, end int) {
+	return first * total / Regions, (first + count) * total / Regions
+}
+
+// RegionBlocks returns the fewest blocks starting at block first for which
+// fits reports the carriers [start, end) to be enough, or 0 if even every
+// remaining block is not.
+func RegionBlocks(total, first int, fits func(start, end int) bool) int {
+	for count := 1; first+count <= Regions; count++ {
+		if fits(Region(total, first, count)) {
+			return count
+		}
+	}
+	return 0
+}
+
 func generateRandomPositions(key string, totalSamples, nLsb int) ([]int, error) {
 	hash := sha256.Sum256([]byte(key))
 	
 	neededPositions := (totalSamples * nLsb) / 8
 	positions := make([]int, 0, neededPositions)
+	used := make(map[int]bool, neededPositions)
 	
 	hashIndex := 0
 	attempts := 0
@@ -45,8 +74,9 @@ func generateRandomPositions(key string, totalSamples, nLsb int) ([]int, error)
 		pos := int(hash[hashIndex%len(hash)]) + int(hash[(hashIndex+1)%len(hash)])*256
 		pos = pos % totalSamples
 		
-		if !contains(positions, pos) {
+		if !used[pos] {
 			positions = append(positions, pos)
+			used[pos] = true
 		}
 		
 		hashIndex++
@@ -55,12 +85,14 @@ func generateRandomPositions(key string, totalSamples, nLsb int) ([]int, error)
 	
 	for len(positions) < neededPositions {
 		pos := len(positions) % totalSamples
-		if !contains(positions, pos) {
+		if !used[pos] {
 			positions = append(positions, pos)
+			used[pos] = true
 		} else {
 			for i := 0; i < totalSamples; i++ {
-				if !contains(positions, i) {
+				if !used[i] {
 					positions = append(positions, i)
+					used[i] = true
 					break
 				}
 			}
@@ -123,3 +155,34 @@ func WriteFile(filePath string, data []byte) error {
 func CalculateCapacity(totalSamples, nLsb int) int {
 	return (totalSamples * nLsb) / 8
 }
+
+// ExpandAudioPaths replaces every directory in paths with the MP3 and WAV
+// files directly inside it, sorted by name. Other paths are kept as given.
+func ExpandAudioPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %w", err)
+		}
+		var found []string
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".mp3" || ext == ".wav") {
+				found = append(found, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no MP3 or WAV files in %s", path)
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
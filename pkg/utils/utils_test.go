@@ -183,6 +183,21 @@ func TestGeneratePositionsDeterministic(t *testing.T) {
 	assert.Equal(t, positions1, positions2)
 }
 
+func TestRegion(t *testing.T) {
+	start, end := Region(6400, 0, Regions)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 6400, end)
+
+	start, end = Region(6400, 3, 2)
+	assert.Equal(t, 300, start)
+	assert.Equal(t, 500, end)
+
+	// 250 carriers from block 2 on need three blocks.
+	fits := func(start, end int) bool { return end-start >= 250 }
+	assert.Equal(t, 3, RegionBlocks(6400, 2, fits))
+	assert.Equal(t, 0, RegionBlocks(6400, 62, fits))
+}
+
 func TestContains(t *testing.T) {
 	slice := []int{1, 2, 3, 4, 5}
 
@@ -363,3 +378,18 @@ func TestWriteFileOverwrite(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, newContent, content)
 }
+
+func TestExpandAudioPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.mp3", "a.WAV", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub.mp3"), 0755))
+
+	files, err := ExpandAudioPaths([]string{"single.mp3", dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"single.mp3", filepath.Join(dir, "a.WAV"), filepath.Join(dir, "b.mp3")}, files)
+
+	_, err = ExpandAudioPaths([]string{filepath.Join(dir, "sub.mp3")})
+	assert.Error(t, err)
+}